@@ -0,0 +1,18 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"github.com/dlorch/base-nfs/localfs"
+	"github.com/dlorch/base-nfs/nfsv3"
+)
+
+// newLocalFileSystem returns a file system serving a local directory
+func newLocalFileSystem(root string) (nfsv3.FileSystem, error) {
+	return localfs.New(root)
+}
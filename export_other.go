@@ -0,0 +1,19 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+
+	"github.com/dlorch/base-nfs/nfsv3"
+)
+
+// newLocalFileSystem fails, local directories can only be exported on Linux
+func newLocalFileSystem(root string) (nfsv3.FileSystem, error) {
+	return nil, errors.New("-export is only supported on Linux")
+}
@@ -0,0 +1,182 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localfs
+
+import (
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// constants which the syscall package doesn't define on every architecture
+const (
+	oPath             = 0x200000 // O_PATH opens an object without reading it, e.g. a directory with only search permission
+	atSymlinkNofollow = 0x100    // AT_SYMLINK_NOFOLLOW
+	atRemoveDir       = 0x200    // AT_REMOVEDIR
+)
+
+// openat opens name in directory dirfd without following a symbolic link in its place
+func openat(dirfd int, name string, flag int, mode uint32) (int, error) {
+	for {
+		fd, err := syscall.Openat(dirfd, name, flag|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, mode)
+
+		if err != syscall.EINTR {
+			return fd, err
+		}
+	}
+}
+
+// lstatat returns the status of name in directory dirfd without following a symbolic link
+func lstatat(dirfd int, name string) (*syscall.Stat_t, error) {
+	fd, err := openat(dirfd, name, oPath, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer syscall.Close(fd)
+
+	var stat syscall.Stat_t
+
+	err = syscall.Fstat(fd, &stat)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &stat, nil
+}
+
+// chmodat changes the permission bits of name in directory dirfd. Symbolic links have
+// no permission bits, and fchmodat would follow them, so the object is opened first
+// and changed through its descriptor in /proc, as glibc does for AT_SYMLINK_NOFOLLOW.
+func chmodat(dirfd int, name string, mode uint32) error {
+	fd, err := openat(dirfd, name, oPath, 0)
+
+	if err != nil {
+		return err
+	}
+
+	defer syscall.Close(fd)
+
+	var stat syscall.Stat_t
+
+	err = syscall.Fstat(fd, &stat)
+
+	if err != nil {
+		return err
+	}
+
+	if stat.Mode&syscall.S_IFMT == syscall.S_IFLNK {
+		return nil
+	}
+
+	return syscall.Chmod("/proc/self/fd/"+strconv.Itoa(fd), mode)
+}
+
+// utimesat sets the access and modification time of name in directory dirfd without
+// following a symbolic link
+func utimesat(dirfd int, name string, times *[2]syscall.Timespec) error {
+	namePtr, err := syscall.BytePtrFromString(name)
+
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall6(syscall.SYS_UTIMENSAT, uintptr(dirfd), uintptr(unsafe.Pointer(namePtr)), uintptr(unsafe.Pointer(times)), atSymlinkNofollow, 0, 0)
+
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// unlinkat removes name from directory dirfd. flags is AT_REMOVEDIR for directories.
+func unlinkat(dirfd int, name string, flags int) error {
+	namePtr, err := syscall.BytePtrFromString(name)
+
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_UNLINKAT, uintptr(dirfd), uintptr(unsafe.Pointer(namePtr)), uintptr(flags))
+
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// symlinkat creates a symbolic link called name in directory dirfd pointing to target
+func symlinkat(target string, dirfd int, name string) error {
+	targetPtr, err := syscall.BytePtrFromString(target)
+
+	if err != nil {
+		return err
+	}
+
+	namePtr, err := syscall.BytePtrFromString(name)
+
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_SYMLINKAT, uintptr(unsafe.Pointer(targetPtr)), uintptr(dirfd), uintptr(unsafe.Pointer(namePtr)))
+
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// linkat creates a hard link called newName in directory newDirfd to the object
+// oldName in directory oldDirfd, which is linked itself if it is a symbolic link
+func linkat(oldDirfd int, oldName string, newDirfd int, newName string) error {
+	oldPtr, err := syscall.BytePtrFromString(oldName)
+
+	if err != nil {
+		return err
+	}
+
+	newPtr, err := syscall.BytePtrFromString(newName)
+
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall6(syscall.SYS_LINKAT, uintptr(oldDirfd), uintptr(unsafe.Pointer(oldPtr)), uintptr(newDirfd), uintptr(unsafe.Pointer(newPtr)), 0, 0)
+
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// readlinkat returns the target of the symbolic link name in directory dirfd
+func readlinkat(dirfd int, name string) (string, error) {
+	namePtr, err := syscall.BytePtrFromString(name)
+
+	if err != nil {
+		return "", err
+	}
+
+	for size := 256; ; size *= 2 {
+		buffer := make([]byte, size)
+
+		n, _, errno := syscall.Syscall6(syscall.SYS_READLINKAT, uintptr(dirfd), uintptr(unsafe.Pointer(namePtr)), uintptr(unsafe.Pointer(&buffer[0])), uintptr(size), 0, 0)
+
+		if errno != 0 {
+			return "", errno
+		}
+
+		if int(n) < size {
+			return string(buffer[:n]), nil
+		}
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package localfs implements a file system which can be served by the nfsv3 package
+and passes all operations through to a directory of the local file system.
+
+Inode numbers are used as FileIDs, which keeps file handles stable across restarts
+of the server. Symbolic links are never followed by the server (clients resolve them
+on their side): names are resolved one component at a time relative to the exported
+directory with openat and O_NOFOLLOW, so objects outside of it can't be reached, even
+if a directory is replaced by a symbolic link concurrently.
+
+The package relies on system calls specific to Linux and is only built there.
+*/
+package localfs
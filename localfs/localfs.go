@@ -0,0 +1,1062 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package localfs
+
+import (
+	"errors"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dlorch/base-nfs/nfsv3"
+)
+
+// NameMax is the maximum length of a file name
+const NameMax = 255
+
+// Limits of the cache of FileIDs not found in the exported directory tree
+const (
+	missingCacheSize = 1024        // number of FileIDs remembered
+	missingCacheTTL  = time.Minute // time until the tree is searched again
+)
+
+// errFound stops the walk through the directory tree once an inode is found
+var errFound = errors.New("localfs: found")
+
+// FileSystem serves a directory of the local file system
+type FileSystem struct {
+	root        string // absolute path of the exported directory
+	rootFD      int    // the exported directory opened with O_PATH, all paths are resolved relative to it
+	rootID      uint64 // inode number of the exported directory
+	device      uint64 // device of the exported directory
+	mutex       sync.RWMutex
+	paths       map[uint64]string           // inode number to path relative to root
+	generations map[uint64]cachedGeneration // inode number to generation number
+	missing     map[uint64]time.Time        // inode numbers not found, to when they were searched
+	listing     *listing                    // most recently read directory
+}
+
+// cachedGeneration is the cached generation number of an inode, which is read again
+// once the change time of the inode changes, e.g. because the inode was reused
+type cachedGeneration struct {
+	ctime      syscall.Timespec
+	generation uint32
+}
+
+// listing is the cached listing of a directory, which is valid as long
+// as the modification and change time of the directory are unchanged
+type listing struct {
+	dirID   uint64
+	mtime   syscall.Timespec
+	ctime   syscall.Timespec
+	entries []nfsv3.DirEntry
+}
+
+// New returns a file system serving the directory root
+func New(root string) (*FileSystem, error) {
+	root, err := filepath.Abs(root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	root, err = filepath.EvalSymlinks(root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// kept open for the lifetime of the file system, so that the exported directory can't be exchanged
+	rootFD, err := syscall.Open(root, oPath|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var stat syscall.Stat_t
+
+	err = syscall.Fstat(rootFD, &stat)
+
+	if err != nil {
+		syscall.Close(rootFD)
+		return nil, err
+	}
+
+	fileSystem := &FileSystem{
+		root:        root,
+		rootFD:      rootFD,
+		rootID:      uint64(stat.Ino),
+		device:      uint64(stat.Dev),
+		paths:       map[uint64]string{uint64(stat.Ino): "."},
+		generations: make(map[uint64]cachedGeneration),
+		missing:     make(map[uint64]time.Time),
+	}
+
+	return fileSystem, nil
+}
+
+// checkName verifies that name is a single path component
+func checkName(name string) error {
+	switch {
+	case name == "", name == ".", name == "..":
+		return syscall.EINVAL
+	case len(name) > NameMax:
+		return syscall.ENAMETOOLONG
+	case strings.ContainsAny(name, "/\x00"):
+		return syscall.EINVAL
+	}
+
+	return nil
+}
+
+// remember records the path of an inode
+func (fileSystem *FileSystem) remember(fileID uint64, relative string) {
+	fileSystem.mutex.Lock()
+	fileSystem.paths[fileID] = relative
+	delete(fileSystem.missing, fileID)
+	fileSystem.mutex.Unlock()
+}
+
+// forget removes the path of an inode, if it is still recorded under that path
+func (fileSystem *FileSystem) forget(fileID uint64, relative string) {
+	fileSystem.mutex.Lock()
+	if fileSystem.paths[fileID] == relative {
+		delete(fileSystem.paths, fileID)
+		delete(fileSystem.generations, fileID)
+	}
+	fileSystem.mutex.Unlock()
+}
+
+// openDirectory opens the directory at a path relative to root. The path is resolved
+// one component at a time without following symbolic links, so that the directory is
+// within root even if a component is replaced by a symbolic link concurrently. The
+// directory is opened with O_PATH, which is enough for the *at system calls, and must
+// be closed by the caller.
+func (fileSystem *FileSystem) openDirectory(relative string) (int, error) {
+	fd, err := openat(fileSystem.rootFD, ".", oPath|syscall.O_DIRECTORY, 0)
+
+	if err != nil || relative == "." {
+		return fd, err
+	}
+
+	for _, name := range strings.Split(relative, string(filepath.Separator)) {
+		next, err := openat(fd, name, oPath|syscall.O_DIRECTORY, 0)
+		syscall.Close(fd)
+
+		if err != nil {
+			return -1, err
+		}
+
+		fd = next
+	}
+
+	return fd, nil
+}
+
+// openParent opens the directory containing the object at a path relative to root,
+// and returns the name of the object within it. The exported directory itself is "."
+// within itself. The directory must be closed by the caller.
+func (fileSystem *FileSystem) openParent(relative string) (int, string, error) {
+	if relative == "." {
+		fd, err := fileSystem.openDirectory(".")
+		return fd, ".", err
+	}
+
+	fd, err := fileSystem.openDirectory(filepath.Dir(relative))
+
+	return fd, filepath.Base(relative), err
+}
+
+// stat returns the status of the object at a path relative to root without following
+// symbolic links, and verifies that it belongs to the exported file system
+func (fileSystem *FileSystem) stat(relative string) (*syscall.Stat_t, error) {
+	dirfd, name, err := fileSystem.openParent(relative)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer syscall.Close(dirfd)
+
+	return fileSystem.statAt(dirfd, name)
+}
+
+// statAt returns the status of the object name in directory dirfd without following
+// symbolic links, and verifies that it belongs to the exported file system
+func (fileSystem *FileSystem) statAt(dirfd int, name string) (*syscall.Stat_t, error) {
+	stat, err := lstatat(dirfd, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if uint64(stat.Dev) != fileSystem.device {
+		return nil, syscall.EXDEV
+	}
+
+	return stat, nil
+}
+
+// resolve returns the path relative to root of an inode. If the recorded path is
+// unknown or out of date, e.g. after a restart or a rename by another process,
+// the exported directory tree is searched for the inode.
+func (fileSystem *FileSystem) resolve(fileID uint64) (string, *syscall.Stat_t, error) {
+	fileSystem.mutex.RLock()
+	relative, found := fileSystem.paths[fileID]
+	fileSystem.mutex.RUnlock()
+
+	if found {
+		stat, err := fileSystem.stat(relative)
+
+		if err == nil && uint64(stat.Ino) == fileID {
+			return relative, stat, nil
+		}
+	}
+
+	relative, err := fileSystem.search(fileID)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	stat, err := fileSystem.stat(relative)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	fileSystem.remember(fileID, relative)
+
+	return relative, stat, nil
+}
+
+// search walks the exported directory tree to find the path of an inode. Inodes which
+// weren't found are reported as stale without walking the tree again for a while, so
+// that clients retrying stale file handles don't keep the server busy.
+func (fileSystem *FileSystem) search(fileID uint64) (string, error) {
+	fileSystem.mutex.RLock()
+	searched, missing := fileSystem.missing[fileID]
+	fileSystem.mutex.RUnlock()
+
+	if missing && time.Since(searched) < missingCacheTTL {
+		return "", syscall.ESTALE
+	}
+
+	relative, err := fileSystem.walk(fileID)
+
+	if err == syscall.ESTALE {
+		fileSystem.mutex.Lock()
+		if len(fileSystem.missing) >= missingCacheSize {
+			fileSystem.missing = make(map[uint64]time.Time)
+		}
+		fileSystem.missing[fileID] = time.Now()
+		fileSystem.mutex.Unlock()
+	}
+
+	return relative, err
+}
+
+// walk walks the exported directory tree to find the path of an inode. The path found
+// is only a hint, which is resolved safely like any other path.
+func (fileSystem *FileSystem) walk(fileID uint64) (string, error) {
+	var relative string
+
+	err := filepath.Walk(fileSystem.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // skip unreadable parts of the tree
+		}
+
+		stat := info.Sys().(*syscall.Stat_t)
+
+		if uint64(stat.Dev) != fileSystem.device {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if uint64(stat.Ino) == fileID {
+			relative, err = filepath.Rel(fileSystem.root, path)
+			if err != nil {
+				return err
+			}
+			return errFound
+		}
+
+		return nil
+	})
+
+	if err == errFound {
+		return relative, nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return "", syscall.ESTALE
+}
+
+// resolveDirectory returns the path relative to root of a directory inode
+func (fileSystem *FileSystem) resolveDirectory(dirID uint64) (string, error) {
+	relative, stat, err := fileSystem.resolve(dirID)
+
+	if err != nil {
+		return "", err
+	}
+
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		return "", syscall.ENOTDIR
+	}
+
+	return relative, nil
+}
+
+// openEntry opens directory dirID and returns it together with the path relative to
+// root of the entry name in it. The directory must be closed by the caller.
+func (fileSystem *FileSystem) openEntry(dirID uint64, name string) (int, string, error) {
+	err := checkName(name)
+
+	if err != nil {
+		return -1, "", err
+	}
+
+	dir, err := fileSystem.resolveDirectory(dirID)
+
+	if err != nil {
+		return -1, "", err
+	}
+
+	dirfd, err := fileSystem.openDirectory(dir)
+
+	if err != nil {
+		return -1, "", err
+	}
+
+	return dirfd, filepath.Join(dir, name), nil
+}
+
+// created records a newly created object called name in directory dirfd, applies its
+// initial attributes and returns its inode number
+func (fileSystem *FileSystem) created(dirfd int, name string, relative string, attributes nfsv3.SAttr3) (uint64, error) {
+	stat, err := fileSystem.statAt(dirfd, name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	fileID := uint64(stat.Ino)
+	fileSystem.remember(fileID, relative)
+
+	// an unprivileged server can't give new objects away, so they belong to the user running it
+	if os.Geteuid() != 0 {
+		attributes.UID.SetIt = 0
+		attributes.GID.SetIt = 0
+	}
+
+	err = fileSystem.setAttributes(dirfd, name, stat, attributes)
+
+	return fileID, err
+}
+
+// createMode returns the permission bits for a new object
+func createMode(attributes nfsv3.SAttr3, defaultMode uint32) uint32 {
+	if attributes.Mode.SetIt == 1 {
+		return attributes.Mode.Mode & 07777
+	}
+
+	return defaultMode
+}
+
+// Root returns the inode number of the exported directory
+func (fileSystem *FileSystem) Root() uint64 {
+	return fileSystem.rootID
+}
+
+// Lookup returns the inode number of the object called name in directory dirID
+func (fileSystem *FileSystem) Lookup(dirID uint64, name string) (uint64, error) {
+	dir, err := fileSystem.resolveDirectory(dirID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var relative string
+
+	switch name {
+	case ".":
+		return dirID, nil
+	case "..":
+		if dirID == fileSystem.rootID {
+			return dirID, nil // never leave the exported directory
+		}
+		relative = filepath.Dir(dir)
+	default:
+		err = checkName(name)
+
+		if err != nil {
+			return 0, err
+		}
+
+		relative = filepath.Join(dir, name)
+	}
+
+	stat, err := fileSystem.stat(relative)
+
+	if err != nil {
+		return 0, err
+	}
+
+	fileSystem.remember(uint64(stat.Ino), relative)
+
+	return uint64(stat.Ino), nil
+}
+
+// GetAttr returns the attributes of an object
+func (fileSystem *FileSystem) GetAttr(fileID uint64) (nfsv3.FAttr3, error) {
+	_, stat, err := fileSystem.resolve(fileID)
+
+	if err != nil {
+		return nfsv3.FAttr3{}, err
+	}
+
+	return attributes(stat), nil
+}
+
+// setAttributes applies attributes to the object name in directory dirfd
+func (fileSystem *FileSystem) setAttributes(dirfd int, name string, stat *syscall.Stat_t, attributes nfsv3.SAttr3) error {
+	isSymlink := stat.Mode&syscall.S_IFMT == syscall.S_IFLNK
+
+	if attributes.UID.SetIt == 1 && attributes.UID.UID != stat.Uid || attributes.GID.SetIt == 1 && attributes.GID.GID != stat.Gid {
+		uid, gid := -1, -1
+
+		if attributes.UID.SetIt == 1 {
+			uid = int(attributes.UID.UID)
+		}
+
+		if attributes.GID.SetIt == 1 {
+			gid = int(attributes.GID.GID)
+		}
+
+		err := syscall.Fchownat(dirfd, name, uid, gid, atSymlinkNofollow)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if attributes.Mode.SetIt == 1 && !isSymlink {
+		err := chmodat(dirfd, name, attributes.Mode.Mode&07777)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if attributes.Size.SetIt == 1 {
+		switch stat.Mode & syscall.S_IFMT {
+		case syscall.S_IFREG:
+		case syscall.S_IFDIR:
+			return syscall.EISDIR
+		default: // opening a FIFO or device could block
+			return syscall.EINVAL
+		}
+
+		fd, err := openat(dirfd, name, syscall.O_WRONLY|syscall.O_NONBLOCK, 0)
+
+		if err != nil {
+			return err
+		}
+
+		err = syscall.Ftruncate(fd, int64(attributes.Size.Size))
+		syscall.Close(fd)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if (attributes.ATime.SetIt != nfsv3.DontChange || attributes.MTime.SetIt != nfsv3.DontChange) && !isSymlink {
+		atime, mtime := timeOf(stat.Atim), timeOf(stat.Mtim)
+
+		switch attributes.ATime.SetIt {
+		case nfsv3.SetToServerTime:
+			atime = time.Now()
+		case nfsv3.SetToClientTime:
+			atime = time.Unix(int64(attributes.ATime.ATime.Seconds), int64(attributes.ATime.ATime.NSeconds))
+		}
+
+		switch attributes.MTime.SetIt {
+		case nfsv3.SetToServerTime:
+			mtime = time.Now()
+		case nfsv3.SetToClientTime:
+			mtime = time.Unix(int64(attributes.MTime.MTime.Seconds), int64(attributes.MTime.MTime.NSeconds))
+		}
+
+		times := [2]syscall.Timespec{syscall.NsecToTimespec(atime.UnixNano()), syscall.NsecToTimespec(mtime.UnixNano())}
+		err := utimesat(dirfd, name, &times)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetAttr changes the attributes of an object
+func (fileSystem *FileSystem) SetAttr(fileID uint64, attributes nfsv3.SAttr3) error {
+	relative, stat, err := fileSystem.resolve(fileID)
+
+	if err != nil {
+		return err
+	}
+
+	dirfd, name, err := fileSystem.openParent(relative)
+
+	if err != nil {
+		return err
+	}
+
+	defer syscall.Close(dirfd)
+
+	return fileSystem.setAttributes(dirfd, name, stat, attributes)
+}
+
+// Generation returns the inode generation number of an object, which tells apart
+// successive files reusing the same inode number
+func (fileSystem *FileSystem) Generation(fileID uint64) (uint32, error) {
+	relative, stat, err := fileSystem.resolve(fileID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	switch stat.Mode & syscall.S_IFMT {
+	case syscall.S_IFREG, syscall.S_IFDIR:
+	default: // opening devices or FIFOs could block or have side effects
+		return 0, nil
+	}
+
+	fileSystem.mutex.RLock()
+	cached, found := fileSystem.generations[fileID]
+	fileSystem.mutex.RUnlock()
+
+	if found && cached.ctime == stat.Ctim { // spares opening the file on every call
+		return cached.generation, nil
+	}
+
+	fileGeneration, err := fileSystem.generation(relative)
+
+	if err != nil {
+		if found { // e.g. the permissions were removed, which doesn't make the inode a new one
+			return cached.generation, nil
+		}
+
+		return 0, err
+	}
+
+	fileSystem.mutex.Lock()
+	fileSystem.generations[fileID] = cachedGeneration{ctime: stat.Ctim, generation: fileGeneration}
+	fileSystem.mutex.Unlock()
+
+	return fileGeneration, nil
+}
+
+// generation returns the inode generation number of the object at a path relative to root
+func (fileSystem *FileSystem) generation(relative string) (uint32, error) {
+	dirfd, name, err := fileSystem.openParent(relative)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer syscall.Close(dirfd)
+
+	return generation(dirfd, name)
+}
+
+// openFile opens a regular file without following symbolic links
+func (fileSystem *FileSystem) openFile(fileID uint64, flag int) (*os.File, error) {
+	relative, stat, err := fileSystem.resolve(fileID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch stat.Mode & syscall.S_IFMT {
+	case syscall.S_IFREG:
+	case syscall.S_IFDIR:
+		return nil, syscall.EISDIR
+	default:
+		return nil, syscall.EINVAL
+	}
+
+	dirfd, name, err := fileSystem.openParent(relative)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer syscall.Close(dirfd)
+
+	// if the file was replaced by a FIFO in the meantime, opening it doesn't block
+	fd, err := openat(dirfd, name, flag|syscall.O_NONBLOCK, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var opened syscall.Stat_t
+
+	err = syscall.Fstat(fd, &opened)
+
+	if err == nil && opened.Mode&syscall.S_IFMT != syscall.S_IFREG {
+		err = syscall.EINVAL
+	}
+
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(fd), relative), nil
+}
+
+// Read reads up to count bytes at offset from a regular file
+func (fileSystem *FileSystem) Read(fileID uint64, offset uint64, count uint32) ([]byte, bool, error) {
+	file, err := fileSystem.openFile(fileID, os.O_RDONLY)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	size := uint64(info.Size())
+
+	if offset >= size {
+		return []byte{}, true, nil
+	}
+
+	if uint64(count) > size-offset {
+		count = uint32(size - offset)
+	}
+
+	data := make([]byte, count)
+	n, err := file.ReadAt(data, int64(offset))
+
+	if err != nil && n < len(data) && !errors.Is(err, io.EOF) {
+		return nil, false, err
+	}
+
+	return data[:n], offset+uint64(n) >= size, nil
+}
+
+// Write writes data at offset to a regular file
+func (fileSystem *FileSystem) Write(fileID uint64, offset uint64, data []byte) (uint32, error) {
+	file, err := fileSystem.openFile(fileID, os.O_WRONLY)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer file.Close()
+
+	n, err := file.WriteAt(data, int64(offset))
+
+	return uint32(n), err
+}
+
+// Commit flushes a regular file to disk. The whole file is synced regardless of the range.
+func (fileSystem *FileSystem) Commit(fileID uint64, offset uint64, count uint32) error {
+	file, err := fileSystem.openFile(fileID, os.O_RDONLY)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return file.Sync()
+}
+
+// Create creates a regular file called name in directory dirID
+func (fileSystem *FileSystem) Create(dirID uint64, name string, attributes nfsv3.SAttr3) (uint64, error) {
+	dirfd, relative, err := fileSystem.openEntry(dirID, name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer syscall.Close(dirfd)
+
+	fd, err := openat(dirfd, name, syscall.O_WRONLY|syscall.O_CREAT|syscall.O_EXCL, createMode(attributes, 0644))
+
+	if err != nil {
+		return 0, err
+	}
+
+	syscall.Close(fd)
+
+	return fileSystem.created(dirfd, name, relative, attributes)
+}
+
+// MkDir creates a directory called name in directory dirID
+func (fileSystem *FileSystem) MkDir(dirID uint64, name string, attributes nfsv3.SAttr3) (uint64, error) {
+	dirfd, relative, err := fileSystem.openEntry(dirID, name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer syscall.Close(dirfd)
+
+	err = syscall.Mkdirat(dirfd, name, createMode(attributes, 0755))
+
+	if err != nil {
+		return 0, err
+	}
+
+	return fileSystem.created(dirfd, name, relative, attributes)
+}
+
+// Symlink creates a symbolic link called name in directory dirID pointing to target
+func (fileSystem *FileSystem) Symlink(dirID uint64, name string, target string, attributes nfsv3.SAttr3) (uint64, error) {
+	dirfd, relative, err := fileSystem.openEntry(dirID, name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer syscall.Close(dirfd)
+
+	err = symlinkat(target, dirfd, name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return fileSystem.created(dirfd, name, relative, attributes)
+}
+
+// MkNod creates a device node, socket or named pipe called name in directory dirID
+func (fileSystem *FileSystem) MkNod(dirID uint64, name string, ftype uint32, rdev nfsv3.SpecData3, attributes nfsv3.SAttr3) (uint64, error) {
+	dirfd, relative, err := fileSystem.openEntry(dirID, name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer syscall.Close(dirfd)
+
+	err = mknod(dirfd, name, ftype, createMode(attributes, 0644), rdev)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return fileSystem.created(dirfd, name, relative, attributes)
+}
+
+// Readlink returns the target of a symbolic link
+func (fileSystem *FileSystem) Readlink(fileID uint64) (string, error) {
+	relative, stat, err := fileSystem.resolve(fileID)
+
+	if err != nil {
+		return "", err
+	}
+
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFLNK {
+		return "", syscall.EINVAL
+	}
+
+	dirfd, name, err := fileSystem.openParent(relative)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer syscall.Close(dirfd)
+
+	return readlinkat(dirfd, name)
+}
+
+// Remove removes the non-directory object called name from directory dirID
+func (fileSystem *FileSystem) Remove(dirID uint64, name string) error {
+	dirfd, relative, err := fileSystem.openEntry(dirID, name)
+
+	if err != nil {
+		return err
+	}
+
+	defer syscall.Close(dirfd)
+
+	stat, err := fileSystem.statAt(dirfd, name)
+
+	if err != nil {
+		return err
+	}
+
+	if stat.Mode&syscall.S_IFMT == syscall.S_IFDIR {
+		return syscall.EISDIR
+	}
+
+	err = unlinkat(dirfd, name, 0)
+
+	if err != nil {
+		return err
+	}
+
+	fileSystem.forget(uint64(stat.Ino), relative)
+
+	return nil
+}
+
+// RmDir removes the empty directory called name from directory dirID
+func (fileSystem *FileSystem) RmDir(dirID uint64, name string) error {
+	switch name {
+	case ".":
+		return syscall.EINVAL
+	case "..":
+		return syscall.ENOTEMPTY
+	}
+
+	dirfd, relative, err := fileSystem.openEntry(dirID, name)
+
+	if err != nil {
+		return err
+	}
+
+	defer syscall.Close(dirfd)
+
+	stat, err := fileSystem.statAt(dirfd, name)
+
+	if err != nil {
+		return err
+	}
+
+	err = unlinkat(dirfd, name, atRemoveDir)
+
+	if err != nil {
+		return err
+	}
+
+	fileSystem.forget(uint64(stat.Ino), relative)
+
+	return nil
+}
+
+// cookie derives the cookie of a directory entry from its name, which keeps cookies
+// valid across restarts. Cookies 1 and 2 are reserved for "." and "..". The highest
+// bit is left clear, so that colliding cookies can be moved up without overflowing.
+func cookie(name string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+
+	if cookie := hash.Sum64() >> 1; cookie > 2 {
+		return cookie
+	}
+
+	return 3
+}
+
+// CookieVerifier returns the verifier of the cookies of a directory. As cookies are
+// derived from the names of the entries, they remain valid across restarts, and the
+// verifier never changes.
+func (fileSystem *FileSystem) CookieVerifier(dirID uint64) ([nfsv3.NFS3CookieVerfSize]byte, error) {
+	return [nfsv3.NFS3CookieVerfSize]byte{}, nil
+}
+
+// ReadDir returns all entries of directory dirID sorted by cookie. The listing of
+// the most recently read directory is cached, so that reading a large directory
+// in many small chunks doesn't have to go through all its entries every time.
+func (fileSystem *FileSystem) ReadDir(dirID uint64) ([]nfsv3.DirEntry, error) {
+	dir, err := fileSystem.resolveDirectory(dirID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dirfd, err := fileSystem.openDirectory(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer syscall.Close(dirfd)
+
+	var dirStat syscall.Stat_t
+
+	err = syscall.Fstat(dirfd, &dirStat)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fileSystem.mutex.RLock()
+	cached := fileSystem.listing
+	fileSystem.mutex.RUnlock()
+
+	if cached != nil && cached.dirID == dirID && cached.mtime == dirStat.Mtim && cached.ctime == dirStat.Ctim {
+		return cached.entries, nil
+	}
+
+	fd, err := openat(dirfd, ".", syscall.O_RDONLY|syscall.O_DIRECTORY, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	file := os.NewFile(uintptr(fd), dir)
+	names, err := file.Readdirnames(-1)
+	file.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	parentID, err := fileSystem.Lookup(dirID, "..")
+
+	if err != nil {
+		return nil, err
+	}
+
+	dirEntries := make([]nfsv3.DirEntry, 0, len(names)+2)
+	dirEntries = append(dirEntries, nfsv3.DirEntry{FileID: dirID, Name: ".", Cookie: 1})
+	dirEntries = append(dirEntries, nfsv3.DirEntry{FileID: parentID, Name: "..", Cookie: 2})
+
+	for _, name := range names {
+		stat, err := fileSystem.statAt(dirfd, name)
+
+		if err != nil {
+			continue // removed in the meantime, or on another file system
+		}
+
+		fileSystem.remember(uint64(stat.Ino), filepath.Join(dir, name))
+		dirEntries = append(dirEntries, nfsv3.DirEntry{FileID: uint64(stat.Ino), Name: name, Cookie: cookie(name)})
+	}
+
+	sort.Slice(dirEntries, func(i, j int) bool {
+		if dirEntries[i].Cookie == dirEntries[j].Cookie {
+			return dirEntries[i].Name < dirEntries[j].Name
+		}
+		return dirEntries[i].Cookie < dirEntries[j].Cookie
+	})
+
+	// names whose hashes collide get the next free cookies in the order of their names,
+	// so that no entry is skipped or repeated when reading the directory in chunks
+	for i := 1; i < len(dirEntries); i++ {
+		if dirEntries[i].Cookie <= dirEntries[i-1].Cookie {
+			dirEntries[i].Cookie = dirEntries[i-1].Cookie + 1
+		}
+	}
+
+	fileSystem.mutex.Lock()
+	fileSystem.listing = &listing{
+		dirID:   dirID,
+		mtime:   dirStat.Mtim,
+		ctime:   dirStat.Ctim,
+		entries: dirEntries,
+	}
+	fileSystem.mutex.Unlock()
+
+	return dirEntries, nil
+}
+
+// Rename atomically moves the object fromName in directory fromDirID to toName in directory toDirID
+func (fileSystem *FileSystem) Rename(fromDirID uint64, fromName string, toDirID uint64, toName string) error {
+	fromDirfd, from, err := fileSystem.openEntry(fromDirID, fromName)
+
+	if err != nil {
+		return err
+	}
+
+	defer syscall.Close(fromDirfd)
+
+	toDirfd, to, err := fileSystem.openEntry(toDirID, toName)
+
+	if err != nil {
+		return err
+	}
+
+	defer syscall.Close(toDirfd)
+
+	err = syscall.Renameat(fromDirfd, fromName, toDirfd, toName)
+
+	if err != nil {
+		return err
+	}
+
+	// update the recorded paths of the renamed object and everything below it
+	fileSystem.mutex.Lock()
+	for fileID, relative := range fileSystem.paths {
+		if relative == from {
+			fileSystem.paths[fileID] = to
+		} else if strings.HasPrefix(relative, from+string(filepath.Separator)) {
+			fileSystem.paths[fileID] = to + relative[len(from):]
+		}
+	}
+	fileSystem.mutex.Unlock()
+
+	return nil
+}
+
+// Link creates a hard link called name in directory dirID to object fileID
+func (fileSystem *FileSystem) Link(fileID uint64, dirID uint64, name string) error {
+	relative, stat, err := fileSystem.resolve(fileID)
+
+	if err != nil {
+		return err
+	}
+
+	if stat.Mode&syscall.S_IFMT == syscall.S_IFDIR {
+		return syscall.EISDIR
+	}
+
+	fromDirfd, fromName, err := fileSystem.openParent(relative)
+
+	if err != nil {
+		return err
+	}
+
+	defer syscall.Close(fromDirfd)
+
+	dirfd, _, err := fileSystem.openEntry(dirID, name)
+
+	if err != nil {
+		return err
+	}
+
+	defer syscall.Close(dirfd)
+
+	return linkat(fromDirfd, fromName, dirfd, name)
+}
+
+// StatFS returns the resource usage of the file system containing the exported directory
+func (fileSystem *FileSystem) StatFS(fileID uint64) (nfsv3.FileSystemStat, error) {
+	_, _, err := fileSystem.resolve(fileID)
+
+	if err != nil {
+		return nfsv3.FileSystemStat{}, err
+	}
+
+	return statFS(fileSystem.rootFD)
+}
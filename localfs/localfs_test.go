@@ -0,0 +1,319 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package localfs_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dlorch/base-nfs/localfs"
+	"github.com/dlorch/base-nfs/nfsv3"
+)
+
+func newFileSystem(t *testing.T) (*localfs.FileSystem, string) {
+	dir, err := ioutil.TempDir("", "localfs")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	root := filepath.Join(dir, "export")
+	err = os.Mkdir(root, 0755)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	fs, err := localfs.New(root)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return fs, dir
+}
+
+func TestWriteRead(t *testing.T) {
+	fs, dir := newFileSystem(t)
+	defer os.RemoveAll(dir)
+
+	fileID, err := fs.Create(fs.Root(), "hello.txt", nfsv3.SAttr3{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = fs.Write(fileID, 0, []byte("Hello, NFS"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	got, eof, err := fs.Read(fileID, 7, 100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(got) != "NFS" || !eof {
+		t.Fatalf("Expected %q with EOF but got %q (eof=%v)", "NFS", got, eof)
+	}
+
+	attributes, err := fs.GetAttr(fileID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if attributes.FileID != fileID || attributes.Size != 10 || attributes.Type != nfsv3.NF3Reg {
+		t.Fatalf("Unexpected attributes %+v", attributes)
+	}
+}
+
+// TestStableFileIDs verifies that FileIDs handed out by one instance can be resolved
+// by a new instance serving the same directory, as happens after a server restart
+func TestStableFileIDs(t *testing.T) {
+	fs, dir := newFileSystem(t)
+	defer os.RemoveAll(dir)
+
+	subdirID, _ := fs.MkDir(fs.Root(), "subdir", nfsv3.SAttr3{})
+	fileID, _ := fs.Create(subdirID, "file", nfsv3.SAttr3{})
+
+	restarted, err := localfs.New(filepath.Join(dir, "export"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	attributes, err := restarted.GetAttr(fileID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if attributes.FileID != fileID {
+		t.Fatalf("Expected FileID %d but got %d", fileID, attributes.FileID)
+	}
+
+	generation, _ := fs.Generation(fileID)
+	restartedGeneration, _ := restarted.Generation(fileID)
+	if generation != restartedGeneration {
+		t.Fatalf("Expected generation %d but got %d", generation, restartedGeneration)
+	}
+
+	err = fs.Remove(subdirID, "file")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = restarted.GetAttr(fileID)
+	if err != syscall.ESTALE {
+		t.Fatalf("Expected %v but got %v", syscall.ESTALE, err)
+	}
+}
+
+func TestGenerationWithoutPermissions(t *testing.T) {
+	fs, dir := newFileSystem(t)
+	defer os.RemoveAll(dir)
+
+	fileID, _ := fs.Create(fs.Root(), "file", nfsv3.SAttr3{})
+
+	generation, err := fs.Generation(fileID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// the file can no longer be opened by unprivileged servers, but remains the same file
+	err = fs.SetAttr(fileID, nfsv3.SAttr3{Mode: nfsv3.SetMode3{SetIt: 1, Mode: 0}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	unreadableGeneration, err := fs.Generation(fileID)
+	if err != nil || unreadableGeneration != generation {
+		t.Fatalf("Expected generation %d but got %d (%v)", generation, unreadableGeneration, err)
+	}
+}
+
+func TestMissingFileIDs(t *testing.T) {
+	fs, dir := newFileSystem(t)
+	defer os.RemoveAll(dir)
+
+	fileID, _ := fs.Create(fs.Root(), "file", nfsv3.SAttr3{})
+
+	// move the file out of the export behind the back of the file system
+	err := os.Rename(filepath.Join(dir, "export", "file"), filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = fs.GetAttr(fileID)
+	if err != syscall.ESTALE {
+		t.Fatalf("Expected %v but got %v", syscall.ESTALE, err)
+	}
+
+	// the tree isn't searched again right away
+	err = os.Rename(filepath.Join(dir, "file"), filepath.Join(dir, "export", "moved"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = fs.GetAttr(fileID)
+	if err != syscall.ESTALE {
+		t.Fatalf("Expected %v but got %v", syscall.ESTALE, err)
+	}
+
+	// but the file is found again once looked up
+	movedID, err := fs.Lookup(fs.Root(), "moved")
+	if err != nil || movedID != fileID {
+		t.Fatalf("Expected %v but got %v (%v)", fileID, movedID, err)
+	}
+
+	_, err = fs.GetAttr(fileID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestNoEscape(t *testing.T) {
+	fs, dir := newFileSystem(t)
+	defer os.RemoveAll(dir)
+
+	err := ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	parentID, err := fs.Lookup(fs.Root(), "..")
+	if err != nil || parentID != fs.Root() {
+		t.Fatalf("Expected %d but got %d (%v)", fs.Root(), parentID, err)
+	}
+
+	_, err = fs.Lookup(fs.Root(), "../secret")
+	if err != syscall.EINVAL {
+		t.Fatalf("Expected %v but got %v", syscall.EINVAL, err)
+	}
+
+	linkID, err := fs.Symlink(fs.Root(), "link", "../secret", nfsv3.SAttr3{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, _, err = fs.Read(linkID, 0, 100)
+	if err != syscall.EINVAL {
+		t.Fatalf("Expected %v but got %v", syscall.EINVAL, err)
+	}
+
+	err = os.Symlink(dir, filepath.Join(dir, "export", "outside"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = fs.Create(linkID, "x", nfsv3.SAttr3{})
+	if err != syscall.ENOTDIR {
+		t.Fatalf("Expected %v but got %v", syscall.ENOTDIR, err)
+	}
+
+	outsideID, _ := fs.Lookup(fs.Root(), "outside")
+	_, err = fs.Lookup(outsideID, "secret")
+	if err != syscall.ENOTDIR {
+		t.Fatalf("Expected %v but got %v", syscall.ENOTDIR, err)
+	}
+}
+
+func TestRenameDirectory(t *testing.T) {
+	fs, dir := newFileSystem(t)
+	defer os.RemoveAll(dir)
+
+	fromID, _ := fs.MkDir(fs.Root(), "from", nfsv3.SAttr3{})
+	fileID, _ := fs.Create(fromID, "file", nfsv3.SAttr3{})
+
+	err := fs.Rename(fs.Root(), "from", fs.Root(), "to")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = fs.Write(fileID, 0, []byte("moved"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "export", "to", "file"))
+	if err != nil || string(data) != "moved" {
+		t.Fatalf("Expected %q but got %q (%v)", "moved", data, err)
+	}
+}
+
+// TestNoEscapeRace swaps a directory for a symbolic link pointing outside of the
+// exported directory while it is being used
+func TestNoEscapeRace(t *testing.T) {
+	fs, dir := newFileSystem(t)
+	defer os.RemoveAll(dir)
+
+	outside := filepath.Join(dir, "outside")
+	sub := filepath.Join(dir, "export", "sub")
+
+	err := os.Mkdir(outside, 0755)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = ioutil.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	subID, err := fs.MkDir(fs.Root(), "sub", nfsv3.SAttr3{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	done := make(chan struct{})
+	swapped := make(chan struct{})
+
+	go func() {
+		defer close(swapped)
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			os.Rename(sub, sub+".real")
+			os.Symlink(outside, sub)
+			os.Remove(sub)
+			os.Rename(sub+".real", sub)
+		}
+	}()
+
+	var waitGroup sync.WaitGroup
+
+	deadline := time.Now().Add(time.Second)
+
+	for worker := 0; worker < 4; worker++ {
+		waitGroup.Add(1)
+
+		go func(worker int) {
+			defer waitGroup.Done()
+
+			for i := 0; time.Now().Before(deadline); i++ {
+				if _, err := fs.Lookup(subID, "secret"); err == nil {
+					t.Errorf("Expected the file outside not to be found")
+					return
+				}
+
+				fs.Create(subID, fmt.Sprintf("file%d-%d", worker, i), nfsv3.SAttr3{})
+			}
+		}(worker)
+	}
+
+	waitGroup.Wait()
+	close(done)
+	<-swapped
+
+	entries, err := ioutil.ReadDir(outside)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected only the secret outside but got %d entries (%v)", len(entries), err)
+	}
+}
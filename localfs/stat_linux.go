@@ -0,0 +1,143 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localfs
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/dlorch/base-nfs/nfsv3"
+)
+
+// fileTypes maps the file type bits of a mode onto the NFS file types
+var fileTypes = map[uint32]uint32{
+	syscall.S_IFREG:  nfsv3.NF3Reg,
+	syscall.S_IFDIR:  nfsv3.NF3Dir,
+	syscall.S_IFBLK:  nfsv3.NF3Blk,
+	syscall.S_IFCHR:  nfsv3.NF3Chr,
+	syscall.S_IFLNK:  nfsv3.NF3Lnk,
+	syscall.S_IFSOCK: nfsv3.NF3Sock,
+	syscall.S_IFIFO:  nfsv3.NF3FIFO,
+}
+
+// attributes converts the status of a local file into NFS attributes
+func attributes(stat *syscall.Stat_t) nfsv3.FAttr3 {
+	return nfsv3.FAttr3{
+		Type:  fileTypes[stat.Mode&syscall.S_IFMT],
+		Mode:  stat.Mode & 07777,
+		Nlink: uint32(stat.Nlink),
+		UID:   stat.Uid,
+		GID:   stat.Gid,
+		Size:  uint64(stat.Size),
+		Used:  uint64(stat.Blocks) * 512,
+		RDev: nfsv3.SpecData3{
+			SpecData1: major(uint64(stat.Rdev)),
+			SpecData2: minor(uint64(stat.Rdev)),
+		},
+		FSID:   uint64(stat.Dev),
+		FileID: uint64(stat.Ino),
+		ATime:  nfsTime(stat.Atim),
+		MTime:  nfsTime(stat.Mtim),
+		CTime:  nfsTime(stat.Ctim),
+	}
+}
+
+// major returns the major number of a Linux device number
+func major(dev uint64) uint32 {
+	return uint32(((dev >> 8) & 0xfff) | ((dev >> 32) & 0xfffff000))
+}
+
+// minor returns the minor number of a Linux device number
+func minor(dev uint64) uint32 {
+	return uint32((dev & 0xff) | ((dev >> 12) & 0xffffff00))
+}
+
+// mkdev returns the Linux device number for a major and minor number
+func mkdev(major uint32, minor uint32) uint64 {
+	return uint64(minor&0xff) | uint64(major&0xfff)<<8 | uint64(minor&0xffffff00)<<12 | uint64(major&0xfffff000)<<32
+}
+
+// nfsTime converts a timespec into an NFS time
+func nfsTime(ts syscall.Timespec) nfsv3.NFSTime3 {
+	return nfsv3.NFSTime3{
+		Seconds:  uint32(ts.Sec),
+		NSeconds: uint32(ts.Nsec),
+	}
+}
+
+// timeOf converts a timespec into a time.Time
+func timeOf(ts syscall.Timespec) time.Time {
+	return time.Unix(int64(ts.Sec), int64(ts.Nsec))
+}
+
+// mknod creates a device node, socket or named pipe called name in directory dirfd
+func mknod(dirfd int, name string, ftype uint32, mode uint32, rdev nfsv3.SpecData3) error {
+	var dev uint64
+
+	switch ftype {
+	case nfsv3.NF3Chr:
+		mode |= syscall.S_IFCHR
+		dev = mkdev(rdev.SpecData1, rdev.SpecData2)
+	case nfsv3.NF3Blk:
+		mode |= syscall.S_IFBLK
+		dev = mkdev(rdev.SpecData1, rdev.SpecData2)
+	case nfsv3.NF3Sock:
+		mode |= syscall.S_IFSOCK
+	case nfsv3.NF3FIFO:
+		mode |= syscall.S_IFIFO
+	default:
+		return nfsv3.StatusError(nfsv3.NFS3ErrBadType)
+	}
+
+	return syscall.Mknodat(dirfd, name, mode, int(dev))
+}
+
+// statFS returns the resource usage of the file system containing the open object fd
+func statFS(fd int) (nfsv3.FileSystemStat, error) {
+	var statfs syscall.Statfs_t
+
+	err := syscall.Fstatfs(fd, &statfs)
+
+	if err != nil {
+		return nfsv3.FileSystemStat{}, err
+	}
+
+	fileSystemStat := nfsv3.FileSystemStat{
+		TotalBytes: uint64(statfs.Blocks) * uint64(statfs.Bsize),
+		FreeBytes:  uint64(statfs.Bfree) * uint64(statfs.Bsize),
+		AvailBytes: uint64(statfs.Bavail) * uint64(statfs.Bsize),
+		TotalFiles: uint64(statfs.Files),
+		FreeFiles:  uint64(statfs.Ffree),
+		AvailFiles: uint64(statfs.Ffree),
+	}
+
+	return fileSystemStat, nil
+}
+
+// fsIocGetVersion is the FS_IOC_GETVERSION ioctl, _IOR('v', 1, long)
+const fsIocGetVersion = 0x80007601 | uintptr(unsafe.Sizeof(int(0)))<<16
+
+// generation returns the inode generation number of the regular file or directory name
+// in directory dirfd. File systems which don't support generation numbers report 0.
+func generation(dirfd int, name string) (uint32, error) {
+	fd, err := openat(dirfd, name, syscall.O_RDONLY|syscall.O_NONBLOCK, 0)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer syscall.Close(fd)
+
+	var version int
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), fsIocGetVersion, uintptr(unsafe.Pointer(&version)))
+
+	if errno != 0 {
+		return 0, nil
+	}
+
+	return uint32(version), nil
+}
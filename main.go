@@ -5,15 +5,143 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/dlorch/base-nfs/memfs"
 	"github.com/dlorch/base-nfs/mountv3"
 	"github.com/dlorch/base-nfs/nfsv3"
 	"github.com/dlorch/base-nfs/portmapv2"
+	"github.com/dlorch/base-nfs/rpcv2"
 )
 
+var exportDirectory = flag.String("export", "", "serve this local directory instead of an in-memory file system")
+var keyFile = flag.String("key", "", "file with the key signing file handles, created if missing (keeps file handles valid across restarts)")
+var tlsCertFile = flag.String("tls-cert", "", "PEM certificate offered to clients of RPC-over-TLS")
+var tlsKeyFile = flag.String("tls-key", "", "PEM private key of the certificate given with -tls-cert")
+var tlsClientCAFile = flag.String("tls-client-ca", "", "PEM certificate authorities client certificates must be signed by (requires mutual TLS)")
+var mountPort = flag.Uint("mount-port", 892, "TCP port of the mount service, 0 to serve it on the NFS port only")
+var tlsRequired = flag.Bool("tls-required", false, "reject NFS and mount calls on connections without TLS")
+var shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second, "time given to calls in progress to finish when shutting down")
+
+// exportPath is the path under which the file system is exported
+const exportPath = "/volume1/Public"
+
+// nfsPort is the TCP port of the NFS service, which also serves the mount and portmap programs
+const nfsPort = 2049
+
+// fileHandleKeySize is the size of a newly generated file handle key
+const fileHandleKeySize = 32
+
+// gopherSource is the content of the example file every new file system starts with
+const gopherSource = `package main
+
+import "fmt"
+
 func main() {
+	fmt.Println("Hello, Gopher!")
+}
+`
+
+// newFileSystem returns the file system served by the NFS service
+func newFileSystem() (nfsv3.FileSystem, error) {
+	if *exportDirectory != "" {
+		return newLocalFileSystem(*exportDirectory)
+	}
+
+	return newMemoryFileSystem()
+}
+
+// newMemoryFileSystem returns an in-memory file system with an example file
+func newMemoryFileSystem() (*memfs.FileSystem, error) {
+	fileSystem := memfs.New(1<<30, 1<<20)
+
+	err := fileSystem.SetAttr(fileSystem.Root(), nfsv3.SAttr3{
+		Mode: nfsv3.SetMode3{SetIt: 1, Mode: 0777},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	gopherID, err := fileSystem.Create(fileSystem.Root(), "gopher.go", nfsv3.SAttr3{
+		Mode: nfsv3.SetMode3{SetIt: 1, Mode: 0666},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = fileSystem.Write(gopherID, 0, []byte(gopherSource))
+
+	return fileSystem, err
+}
+
+// loadTLSConfig returns the configuration of RPC-over-TLS, or nil if no certificate is configured
+func loadTLSConfig() (*tls.Config, error) {
+	if *tlsCertFile == "" {
+		return nil, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(*tlsCertFile, *tlsKeyFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{Certificates: []tls.Certificate{certificate}}
+
+	if *tlsClientCAFile != "" {
+		pemCerts, err := ioutil.ReadFile(*tlsClientCAFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		config.ClientCAs = x509.NewCertPool()
+
+		if !config.ClientCAs.AppendCertsFromPEM(pemCerts) {
+			return nil, fmt.Errorf("No certificates found in '%s'", *tlsClientCAFile)
+		}
+
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// loadFileHandleKey reads the key signing file handles from path. A random key
+// is generated and stored in path if the file does not exist yet.
+func loadFileHandleKey(path string) ([]byte, error) {
+	key, err := ioutil.ReadFile(path)
+
+	if err == nil || !os.IsNotExist(err) {
+		return key, err
+	}
+
+	key = make([]byte, fileHandleKeySize)
+
+	_, err = rand.Read(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return key, ioutil.WriteFile(path, key, 0600)
+}
+
+func main() {
+	flag.Parse()
+
 	portmapService := portmapv2.NewPortmapService()
 
 	err := portmapService.AddListener("udp", ":111")
@@ -32,20 +160,49 @@ func main() {
 
 	go portmapService.HandleClients()
 
-	mountService := mountv3.NewMountService()
+	fileSystem, err := newFileSystem()
+
+	if err != nil {
+		fmt.Println("Error: ", err.Error())
+		os.Exit(1)
+	}
+
+	nfsv3Service := nfsv3.NewNFSv3Service(&nfsv3.Export{Path: exportPath, FileSystem: fileSystem})
+
+	if *keyFile != "" {
+		key, err := loadFileHandleKey(*keyFile)
+
+		if err != nil {
+			fmt.Println("Error: ", err.Error())
+			os.Exit(1)
+		}
+
+		nfsv3Service.SetFileHandleKey(key)
+	}
 
-	err = mountService.AddListener("tcp", ":892")
+	tlsConfig, err := loadTLSConfig()
 
 	if err != nil {
 		fmt.Println("Error: ", err.Error())
 		os.Exit(1)
 	}
 
-	go mountService.HandleClients()
+	if tlsConfig != nil {
+		nfsv3Service.SetTLSConfig(tlsConfig, *tlsRequired)
+	}
+
+	mountService := mountv3.NewMountService(nfsv3Service)
 
-	nfsv3Service := nfsv3.NewNFSv3Service()
+	if tlsConfig != nil {
+		mountService.SetTLSConfig(tlsConfig, *tlsRequired)
+	}
 
-	err = nfsv3Service.AddListener("tcp", ":2049")
+	// clients behind firewalls which only open the NFS port can mount with
+	// "-o port=2049,mountport=2049"
+	nfsv3Service.AddProgram(&mountService.RPCService)
+	nfsv3Service.AddProgram(&portmapService.RPCService)
+
+	err = nfsv3Service.AddListener("tcp", fmt.Sprintf(":%d", nfsPort))
 
 	if err != nil {
 		fmt.Println("Error: ", err.Error())
@@ -54,7 +211,45 @@ func main() {
 
 	go nfsv3Service.HandleClients()
 
-	portmapService.WaitUntilDone()
-	mountService.WaitUntilDone()
-	nfsv3Service.WaitUntilDone()
+	mountServicePort := uint32(*mountPort)
+
+	if mountServicePort == 0 {
+		mountServicePort = nfsPort
+	}
+
+	portmapService.SetPort(mountv3.Program, mountv3.Version1, portmapv2.IPProtocolTCP, mountServicePort)
+	portmapService.SetPort(mountv3.Program, mountv3.Version, portmapv2.IPProtocolTCP, mountServicePort)
+
+	if *mountPort != 0 {
+		err = mountService.AddListener("tcp", fmt.Sprintf(":%d", *mountPort))
+
+		if err != nil {
+			fmt.Println("Error: ", err.Error())
+			os.Exit(1)
+		}
+
+		go mountService.HandleClients()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	fmt.Printf("Received %s, shutting down\n", <-signals)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	services := []*rpcv2.RPCService{&nfsv3Service.RPCService, &mountService.RPCService, &portmapService.RPCService}
+
+	for _, service := range services {
+		err = service.Shutdown(ctx)
+
+		if err != nil {
+			fmt.Println("Error: ", err.Error())
+		}
+	}
+
+	for _, service := range services {
+		service.WaitUntilDone()
+	}
 }
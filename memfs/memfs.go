@@ -0,0 +1,899 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package memfs implements an in-memory file system which can be served by the
+nfsv3 package. All operations are safe for concurrent use.
+*/
+package memfs
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/dlorch/base-nfs/nfsv3"
+)
+
+// NameMax is the maximum length of a file name
+const NameMax = 255
+
+// Sizes reported for objects without data
+const (
+	blockSize     uint64 = 4096 // allocation unit used to compute FAttr3.Used
+	directorySize uint64 = 4096 // size reported for directories
+)
+
+// lastFSID is used to hand out a distinct FSID to every FileSystem of the process
+var lastFSID uint64
+
+// inode is a file system object
+type inode struct {
+	fileID  uint64
+	ftype   uint32 // one of nfsv3.NF3Reg, nfsv3.NF3Dir, ...
+	mode    uint32 // permission bits
+	nlink   uint32
+	uid     uint32
+	gid     uint32
+	rdev    nfsv3.SpecData3
+	atime   nfsv3.NFSTime3
+	mtime   nfsv3.NFSTime3
+	ctime   nfsv3.NFSTime3
+	data    []byte            // contents of a regular file
+	entries map[string]uint64 // entries of a directory, excluding "." and ".."
+	cookies map[string]uint64 // positions of the entries of a directory
+	listing []nfsv3.DirEntry  // entries of a directory sorted by cookie, nil if out of date
+	parent  uint64            // parent of a directory
+	target  string            // target of a symbolic link
+}
+
+// FileSystem is an in-memory file system
+type FileSystem struct {
+	mutex      sync.RWMutex
+	fsid       uint64
+	capacity   uint64 // maximum number of bytes stored in regular files
+	used       uint64 // number of bytes stored in regular files
+	maxFiles   uint64 // maximum number of inodes
+	lastID     uint64
+	lastCookie uint64 // cookie of the latest directory entry, 1 and 2 are "." and ".."
+	rootID     uint64
+	inodes     map[uint64]*inode
+}
+
+// New returns an empty file system which can hold up to capacity bytes of file data
+// and up to maxFiles files, directories and other objects
+func New(capacity uint64, maxFiles uint64) *FileSystem {
+	fileSystem := &FileSystem{
+		fsid:       atomic.AddUint64(&lastFSID, 1),
+		capacity:   capacity,
+		maxFiles:   maxFiles,
+		lastCookie: 2,
+		inodes:     make(map[uint64]*inode),
+	}
+
+	root := fileSystem.newInode(nfsv3.NF3Dir, 0755)
+	root.parent = root.fileID
+	root.nlink = 2
+	fileSystem.rootID = root.fileID
+
+	return fileSystem
+}
+
+// now returns the current time
+func now() nfsv3.NFSTime3 {
+	t := time.Now()
+
+	return nfsv3.NFSTime3{
+		Seconds:  uint32(t.Unix()),
+		NSeconds: uint32(t.Nanosecond()),
+	}
+}
+
+// newInode allocates a new object. The caller must hold the write lock.
+func (fileSystem *FileSystem) newInode(ftype uint32, mode uint32) *inode {
+	fileSystem.lastID++
+	t := now()
+
+	node := &inode{
+		fileID: fileSystem.lastID,
+		ftype:  ftype,
+		mode:   mode & 07777,
+		nlink:  1,
+		atime:  t,
+		mtime:  t,
+		ctime:  t,
+	}
+
+	if ftype == nfsv3.NF3Dir {
+		node.entries = make(map[string]uint64)
+		node.cookies = make(map[string]uint64)
+	}
+
+	fileSystem.inodes[node.fileID] = node
+
+	return node
+}
+
+// attributes returns the attributes of an object
+func (fileSystem *FileSystem) attributes(node *inode) nfsv3.FAttr3 {
+	var size uint64
+
+	switch node.ftype {
+	case nfsv3.NF3Reg:
+		size = uint64(len(node.data))
+	case nfsv3.NF3Dir:
+		size = directorySize
+	case nfsv3.NF3Lnk:
+		size = uint64(len(node.target))
+	}
+
+	return nfsv3.FAttr3{
+		Type:   node.ftype,
+		Mode:   node.mode,
+		Nlink:  node.nlink,
+		UID:    node.uid,
+		GID:    node.gid,
+		Size:   size,
+		Used:   (size + blockSize - 1) / blockSize * blockSize,
+		RDev:   node.rdev,
+		FSID:   fileSystem.fsid,
+		FileID: node.fileID,
+		ATime:  node.atime,
+		MTime:  node.mtime,
+		CTime:  node.ctime,
+	}
+}
+
+// lookupInode returns the object with the given FileID. The caller must hold the lock.
+func (fileSystem *FileSystem) lookupInode(fileID uint64) (*inode, error) {
+	node, found := fileSystem.inodes[fileID]
+
+	if !found {
+		return nil, syscall.ENOENT
+	}
+
+	return node, nil
+}
+
+// lookupDirectory returns the directory with the given FileID. The caller must hold the lock.
+func (fileSystem *FileSystem) lookupDirectory(dirID uint64) (*inode, error) {
+	dir, err := fileSystem.lookupInode(dirID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if dir.ftype != nfsv3.NF3Dir {
+		return nil, syscall.ENOTDIR
+	}
+
+	return dir, nil
+}
+
+// checkName verifies that name is usable for a new directory entry
+func checkName(name string) error {
+	switch {
+	case name == "", name == ".", name == "..":
+		return syscall.EINVAL
+	case len(name) > NameMax:
+		return syscall.ENAMETOOLONG
+	}
+
+	for i := 0; i < len(name); i++ {
+		if name[i] == '/' || name[i] == 0 {
+			return syscall.EINVAL
+		}
+	}
+
+	return nil
+}
+
+// setAttributes applies attributes to an object. The caller must hold the write lock.
+func (fileSystem *FileSystem) setAttributes(node *inode, attributes nfsv3.SAttr3) error {
+	t := now()
+
+	if attributes.Size.SetIt == 1 {
+		switch node.ftype {
+		case nfsv3.NF3Reg:
+			err := fileSystem.resize(node, attributes.Size.Size)
+
+			if err != nil {
+				return err
+			}
+
+			node.mtime = t
+		case nfsv3.NF3Dir:
+			return syscall.EISDIR
+		default:
+			return syscall.EINVAL
+		}
+	}
+
+	if attributes.Mode.SetIt == 1 {
+		node.mode = attributes.Mode.Mode & 07777
+	}
+
+	if attributes.UID.SetIt == 1 {
+		node.uid = attributes.UID.UID
+	}
+
+	if attributes.GID.SetIt == 1 {
+		node.gid = attributes.GID.GID
+	}
+
+	switch attributes.ATime.SetIt {
+	case nfsv3.SetToServerTime:
+		node.atime = t
+	case nfsv3.SetToClientTime:
+		node.atime = attributes.ATime.ATime
+	}
+
+	switch attributes.MTime.SetIt {
+	case nfsv3.SetToServerTime:
+		node.mtime = t
+	case nfsv3.SetToClientTime:
+		node.mtime = attributes.MTime.MTime
+	}
+
+	node.ctime = t
+
+	return nil
+}
+
+// resize truncates or extends the data of a regular file. The caller must hold the write lock.
+func (fileSystem *FileSystem) resize(node *inode, size uint64) error {
+	current := uint64(len(node.data))
+
+	if size > current && size-current > fileSystem.capacity-fileSystem.used { // can't overflow, unlike used+(size-current)
+		return syscall.ENOSPC
+	}
+
+	if size > current {
+		node.data = append(node.data, make([]byte, size-current)...)
+	} else {
+		node.data = node.data[:size]
+	}
+
+	fileSystem.used = fileSystem.used - current + size
+
+	return nil
+}
+
+// release drops a link to a non-directory object and frees it once it is no longer
+// referenced. The caller must hold the write lock.
+func (fileSystem *FileSystem) release(node *inode) {
+	node.nlink--
+	node.ctime = now()
+
+	if node.nlink == 0 {
+		fileSystem.used -= uint64(len(node.data))
+		delete(fileSystem.inodes, node.fileID)
+	}
+}
+
+// link adds an entry to directory dir, which is listed after all existing entries.
+// The caller must hold the write lock.
+func (fileSystem *FileSystem) link(dir *inode, name string, fileID uint64) {
+	fileSystem.lastCookie++
+	dir.entries[name] = fileID
+	dir.cookies[name] = fileSystem.lastCookie
+	dir.listing = nil
+}
+
+// unlink removes an entry from directory dir. The caller must hold the write lock.
+func unlink(dir *inode, name string) {
+	delete(dir.entries, name)
+	delete(dir.cookies, name)
+	dir.listing = nil
+}
+
+// addEntry creates a new object called name in directory dir. The caller must hold the write lock.
+func (fileSystem *FileSystem) addEntry(dir *inode, name string, ftype uint32, attributes nfsv3.SAttr3) (*inode, error) {
+	err := checkName(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, found := dir.entries[name]; found {
+		return nil, syscall.EEXIST
+	}
+
+	if uint64(len(fileSystem.inodes)) >= fileSystem.maxFiles {
+		return nil, syscall.ENOSPC
+	}
+
+	node := fileSystem.newInode(ftype, 0644)
+
+	if ftype == nfsv3.NF3Dir {
+		node.mode = 0755
+		node.nlink = 2
+		node.parent = dir.fileID
+	}
+
+	err = fileSystem.setAttributes(node, attributes)
+
+	if err != nil {
+		delete(fileSystem.inodes, node.fileID)
+		return nil, err
+	}
+
+	if ftype == nfsv3.NF3Dir {
+		dir.nlink++
+	}
+
+	fileSystem.link(dir, name, node.fileID)
+	dir.mtime = node.ctime
+	dir.ctime = node.ctime
+
+	return node, nil
+}
+
+// Root returns the FileID of the root directory
+func (fileSystem *FileSystem) Root() uint64 {
+	return fileSystem.rootID
+}
+
+// Lookup returns the FileID of the object called name in directory dirID
+func (fileSystem *FileSystem) Lookup(dirID uint64, name string) (uint64, error) {
+	fileSystem.mutex.RLock()
+	defer fileSystem.mutex.RUnlock()
+
+	dir, err := fileSystem.lookupDirectory(dirID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	switch name {
+	case ".":
+		return dir.fileID, nil
+	case "..":
+		return dir.parent, nil
+	}
+
+	fileID, found := dir.entries[name]
+
+	if !found {
+		return 0, syscall.ENOENT
+	}
+
+	return fileID, nil
+}
+
+// GetAttr returns the attributes of an object
+func (fileSystem *FileSystem) GetAttr(fileID uint64) (nfsv3.FAttr3, error) {
+	fileSystem.mutex.RLock()
+	defer fileSystem.mutex.RUnlock()
+
+	node, err := fileSystem.lookupInode(fileID)
+
+	if err != nil {
+		return nfsv3.FAttr3{}, err
+	}
+
+	return fileSystem.attributes(node), nil
+}
+
+// SetAttr changes the attributes of an object
+func (fileSystem *FileSystem) SetAttr(fileID uint64, attributes nfsv3.SAttr3) error {
+	fileSystem.mutex.Lock()
+	defer fileSystem.mutex.Unlock()
+
+	node, err := fileSystem.lookupInode(fileID)
+
+	if err != nil {
+		return err
+	}
+
+	return fileSystem.setAttributes(node, attributes)
+}
+
+// Read reads up to count bytes at offset from a regular file
+func (fileSystem *FileSystem) Read(fileID uint64, offset uint64, count uint32) ([]byte, bool, error) {
+	data, eof, err := fileSystem.read(fileID, offset, count)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	fileSystem.touch(fileID)
+
+	return data, eof, nil
+}
+
+// read copies up to count bytes at offset from a regular file under the read lock, so
+// that reads of the same or other files don't wait for each other
+func (fileSystem *FileSystem) read(fileID uint64, offset uint64, count uint32) ([]byte, bool, error) {
+	fileSystem.mutex.RLock()
+	defer fileSystem.mutex.RUnlock()
+
+	node, err := fileSystem.lookupInode(fileID)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch node.ftype {
+	case nfsv3.NF3Reg:
+	case nfsv3.NF3Dir:
+		return nil, false, syscall.EISDIR
+	default:
+		return nil, false, syscall.EINVAL
+	}
+
+	size := uint64(len(node.data))
+
+	if offset >= size {
+		return []byte{}, true, nil
+	}
+
+	end := offset + uint64(count)
+
+	if end > size || end < offset {
+		end = size
+	}
+
+	data := make([]byte, end-offset)
+	copy(data, node.data[offset:end])
+
+	return data, end == size, nil
+}
+
+// touch updates the access time of an inode after it was read. The inode may have
+// been removed in the meantime, in which case there is nothing to update.
+func (fileSystem *FileSystem) touch(fileID uint64) {
+	fileSystem.mutex.Lock()
+	defer fileSystem.mutex.Unlock()
+
+	if node, found := fileSystem.inodes[fileID]; found {
+		node.atime = now()
+	}
+}
+
+// Write writes data at offset to a regular file
+func (fileSystem *FileSystem) Write(fileID uint64, offset uint64, data []byte) (uint32, error) {
+	fileSystem.mutex.Lock()
+	defer fileSystem.mutex.Unlock()
+
+	node, err := fileSystem.lookupInode(fileID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	switch node.ftype {
+	case nfsv3.NF3Reg:
+	case nfsv3.NF3Dir:
+		return 0, syscall.EISDIR
+	default:
+		return 0, syscall.EINVAL
+	}
+
+	if len(data) == 0 { // nothing to write, even at offsets past the end of the file
+		return 0, nil
+	}
+
+	end := offset + uint64(len(data))
+
+	if end < offset {
+		return 0, syscall.EFBIG
+	}
+
+	if end > uint64(len(node.data)) {
+		err = fileSystem.resize(node, end)
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	copy(node.data[offset:], data)
+	node.mtime = now()
+	node.ctime = node.mtime
+
+	return uint32(len(data)), nil
+}
+
+// Commit does nothing as written data is immediately visible. The data is lost
+// when the server stops, which clients detect from the changed write verifier.
+func (fileSystem *FileSystem) Commit(fileID uint64, offset uint64, count uint32) error {
+	fileSystem.mutex.RLock()
+	defer fileSystem.mutex.RUnlock()
+
+	_, err := fileSystem.lookupInode(fileID)
+
+	return err
+}
+
+// Create creates a regular file called name in directory dirID
+func (fileSystem *FileSystem) Create(dirID uint64, name string, attributes nfsv3.SAttr3) (uint64, error) {
+	fileSystem.mutex.Lock()
+	defer fileSystem.mutex.Unlock()
+
+	dir, err := fileSystem.lookupDirectory(dirID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	node, err := fileSystem.addEntry(dir, name, nfsv3.NF3Reg, attributes)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return node.fileID, nil
+}
+
+// MkDir creates a directory called name in directory dirID
+func (fileSystem *FileSystem) MkDir(dirID uint64, name string, attributes nfsv3.SAttr3) (uint64, error) {
+	fileSystem.mutex.Lock()
+	defer fileSystem.mutex.Unlock()
+
+	dir, err := fileSystem.lookupDirectory(dirID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	node, err := fileSystem.addEntry(dir, name, nfsv3.NF3Dir, attributes)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return node.fileID, nil
+}
+
+// Symlink creates a symbolic link called name in directory dirID pointing to target
+func (fileSystem *FileSystem) Symlink(dirID uint64, name string, target string, attributes nfsv3.SAttr3) (uint64, error) {
+	fileSystem.mutex.Lock()
+	defer fileSystem.mutex.Unlock()
+
+	dir, err := fileSystem.lookupDirectory(dirID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	node, err := fileSystem.addEntry(dir, name, nfsv3.NF3Lnk, attributes)
+
+	if err != nil {
+		return 0, err
+	}
+
+	node.mode = 0777
+	node.target = target
+
+	return node.fileID, nil
+}
+
+// MkNod creates a device node, socket or named pipe called name in directory dirID
+func (fileSystem *FileSystem) MkNod(dirID uint64, name string, ftype uint32, rdev nfsv3.SpecData3, attributes nfsv3.SAttr3) (uint64, error) {
+	switch ftype {
+	case nfsv3.NF3Chr, nfsv3.NF3Blk, nfsv3.NF3Sock, nfsv3.NF3FIFO:
+	default:
+		return 0, nfsv3.StatusError(nfsv3.NFS3ErrBadType)
+	}
+
+	fileSystem.mutex.Lock()
+	defer fileSystem.mutex.Unlock()
+
+	dir, err := fileSystem.lookupDirectory(dirID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	node, err := fileSystem.addEntry(dir, name, ftype, attributes)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if ftype == nfsv3.NF3Chr || ftype == nfsv3.NF3Blk {
+		node.rdev = rdev
+	}
+
+	return node.fileID, nil
+}
+
+// Readlink returns the target of a symbolic link
+func (fileSystem *FileSystem) Readlink(fileID uint64) (string, error) {
+	fileSystem.mutex.RLock()
+	defer fileSystem.mutex.RUnlock()
+
+	node, err := fileSystem.lookupInode(fileID)
+
+	if err != nil {
+		return "", err
+	}
+
+	if node.ftype != nfsv3.NF3Lnk {
+		return "", syscall.EINVAL
+	}
+
+	return node.target, nil
+}
+
+// Remove removes the non-directory object called name from directory dirID
+func (fileSystem *FileSystem) Remove(dirID uint64, name string) error {
+	fileSystem.mutex.Lock()
+	defer fileSystem.mutex.Unlock()
+
+	dir, err := fileSystem.lookupDirectory(dirID)
+
+	if err != nil {
+		return err
+	}
+
+	fileID, found := dir.entries[name]
+
+	if !found {
+		return syscall.ENOENT
+	}
+
+	node := fileSystem.inodes[fileID]
+
+	if node.ftype == nfsv3.NF3Dir {
+		return syscall.EISDIR
+	}
+
+	unlink(dir, name)
+	dir.mtime = now()
+	dir.ctime = dir.mtime
+	fileSystem.release(node)
+
+	return nil
+}
+
+// RmDir removes the empty directory called name from directory dirID
+func (fileSystem *FileSystem) RmDir(dirID uint64, name string) error {
+	fileSystem.mutex.Lock()
+	defer fileSystem.mutex.Unlock()
+
+	dir, err := fileSystem.lookupDirectory(dirID)
+
+	if err != nil {
+		return err
+	}
+
+	switch name {
+	case ".":
+		return syscall.EINVAL
+	case "..":
+		return syscall.ENOTEMPTY
+	}
+
+	fileID, found := dir.entries[name]
+
+	if !found {
+		return syscall.ENOENT
+	}
+
+	node := fileSystem.inodes[fileID]
+
+	if node.ftype != nfsv3.NF3Dir {
+		return syscall.ENOTDIR
+	}
+
+	if len(node.entries) > 0 {
+		return syscall.ENOTEMPTY
+	}
+
+	unlink(dir, name)
+	dir.nlink--
+	dir.mtime = now()
+	dir.ctime = dir.mtime
+	node.nlink = 0
+	delete(fileSystem.inodes, node.fileID)
+
+	return nil
+}
+
+// ReadDir returns all entries of directory dirID in the order they were added. The
+// listing is kept until the directory changes, so that reading a large directory
+// in many small chunks doesn't have to sort its entries every time.
+func (fileSystem *FileSystem) ReadDir(dirID uint64) ([]nfsv3.DirEntry, error) {
+	fileSystem.mutex.Lock() // updates atime
+	defer fileSystem.mutex.Unlock()
+
+	dir, err := fileSystem.lookupDirectory(dirID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if dir.listing == nil {
+		listing := make([]nfsv3.DirEntry, 0, len(dir.entries)+2)
+		listing = append(listing, nfsv3.DirEntry{FileID: dir.fileID, Name: ".", Cookie: 1})
+		listing = append(listing, nfsv3.DirEntry{FileID: dir.parent, Name: "..", Cookie: 2})
+
+		for name, fileID := range dir.entries {
+			listing = append(listing, nfsv3.DirEntry{FileID: fileID, Name: name, Cookie: dir.cookies[name]})
+		}
+
+		sort.Slice(listing, func(i, j int) bool {
+			return listing[i].Cookie < listing[j].Cookie
+		})
+
+		dir.listing = listing
+	}
+
+	dir.atime = now()
+
+	return dir.listing, nil
+}
+
+// isAncestor reports whether directory ancestorID is dirID or one of its parents.
+// The caller must hold the lock.
+func (fileSystem *FileSystem) isAncestor(ancestorID uint64, dirID uint64) bool {
+	for {
+		if dirID == ancestorID {
+			return true
+		}
+
+		if dirID == fileSystem.rootID {
+			return false
+		}
+
+		dirID = fileSystem.inodes[dirID].parent
+	}
+}
+
+// Rename atomically moves the object fromName in directory fromDirID to toName in
+// directory toDirID, replacing an existing toName where POSIX allows it
+func (fileSystem *FileSystem) Rename(fromDirID uint64, fromName string, toDirID uint64, toName string) error {
+	fileSystem.mutex.Lock()
+	defer fileSystem.mutex.Unlock()
+
+	fromDir, err := fileSystem.lookupDirectory(fromDirID)
+
+	if err != nil {
+		return err
+	}
+
+	toDir, err := fileSystem.lookupDirectory(toDirID)
+
+	if err != nil {
+		return err
+	}
+
+	if fromName == "." || fromName == ".." {
+		return syscall.EINVAL
+	}
+
+	err = checkName(toName)
+
+	if err != nil {
+		return err
+	}
+
+	fileID, found := fromDir.entries[fromName]
+
+	if !found {
+		return syscall.ENOENT
+	}
+
+	node := fileSystem.inodes[fileID]
+
+	if node.ftype == nfsv3.NF3Dir && fileSystem.isAncestor(node.fileID, toDir.fileID) {
+		return syscall.EINVAL // can't move a directory below itself
+	}
+
+	if targetID, found := toDir.entries[toName]; found {
+		if targetID == fileID {
+			return nil // both names refer to the same object
+		}
+
+		target := fileSystem.inodes[targetID]
+
+		switch {
+		case node.ftype == nfsv3.NF3Dir && target.ftype != nfsv3.NF3Dir:
+			return syscall.ENOTDIR
+		case node.ftype != nfsv3.NF3Dir && target.ftype == nfsv3.NF3Dir:
+			return syscall.EISDIR
+		case target.ftype == nfsv3.NF3Dir && len(target.entries) > 0:
+			return syscall.ENOTEMPTY
+		}
+
+		unlink(toDir, toName)
+
+		if target.ftype == nfsv3.NF3Dir {
+			toDir.nlink--
+			delete(fileSystem.inodes, target.fileID)
+		} else {
+			fileSystem.release(target)
+		}
+	}
+
+	unlink(fromDir, fromName)
+	fileSystem.link(toDir, toName, fileID)
+
+	if node.ftype == nfsv3.NF3Dir && fromDir != toDir {
+		node.parent = toDir.fileID
+		node.listing = nil // ".." changed
+		fromDir.nlink--
+		toDir.nlink++
+	}
+
+	t := now()
+	node.ctime = t
+	fromDir.mtime = t
+	fromDir.ctime = t
+	toDir.mtime = t
+	toDir.ctime = t
+
+	return nil
+}
+
+// Link creates a hard link called name in directory dirID to object fileID
+func (fileSystem *FileSystem) Link(fileID uint64, dirID uint64, name string) error {
+	fileSystem.mutex.Lock()
+	defer fileSystem.mutex.Unlock()
+
+	node, err := fileSystem.lookupInode(fileID)
+
+	if err != nil {
+		return err
+	}
+
+	if node.ftype == nfsv3.NF3Dir {
+		return syscall.EISDIR
+	}
+
+	dir, err := fileSystem.lookupDirectory(dirID)
+
+	if err != nil {
+		return err
+	}
+
+	err = checkName(name)
+
+	if err != nil {
+		return err
+	}
+
+	if _, found := dir.entries[name]; found {
+		return syscall.EEXIST
+	}
+
+	fileSystem.link(dir, name, node.fileID)
+	node.nlink++
+
+	t := now()
+	node.ctime = t
+	dir.mtime = t
+	dir.ctime = t
+
+	return nil
+}
+
+// StatFS returns the resource usage of the file system
+func (fileSystem *FileSystem) StatFS(fileID uint64) (nfsv3.FileSystemStat, error) {
+	fileSystem.mutex.RLock()
+	defer fileSystem.mutex.RUnlock()
+
+	_, err := fileSystem.lookupInode(fileID)
+
+	if err != nil {
+		return nfsv3.FileSystemStat{}, err
+	}
+
+	files := uint64(len(fileSystem.inodes))
+
+	fileSystemStat := nfsv3.FileSystemStat{
+		TotalBytes: fileSystem.capacity,
+		FreeBytes:  fileSystem.capacity - fileSystem.used,
+		AvailBytes: fileSystem.capacity - fileSystem.used,
+		TotalFiles: fileSystem.maxFiles,
+		FreeFiles:  fileSystem.maxFiles - files,
+		AvailFiles: fileSystem.maxFiles - files,
+	}
+
+	return fileSystemStat, nil
+}
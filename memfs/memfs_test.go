@@ -0,0 +1,329 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memfs_test
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/dlorch/base-nfs/memfs"
+	"github.com/dlorch/base-nfs/nfsv3"
+)
+
+func newFileSystem() *memfs.FileSystem {
+	return memfs.New(1<<20, 1024)
+}
+
+func TestWriteRead(t *testing.T) {
+	fs := newFileSystem()
+
+	fileID, err := fs.Create(fs.Root(), "hello.txt", nfsv3.SAttr3{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = fs.Write(fileID, 0, []byte("Hello, "))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = fs.Write(fileID, 7, []byte("NFS"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	got, eof, err := fs.Read(fileID, 0, 5)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(got) != "Hello" || eof {
+		t.Fatalf("Expected %q without EOF but got %q (eof=%v)", "Hello", got, eof)
+	}
+
+	got, eof, err = fs.Read(fileID, 7, 100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(got) != "NFS" || !eof {
+		t.Fatalf("Expected %q with EOF but got %q (eof=%v)", "NFS", got, eof)
+	}
+
+	attributes, err := fs.GetAttr(fileID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if attributes.Size != 10 || attributes.Type != nfsv3.NF3Reg || attributes.FileID != fileID {
+		t.Fatalf("Unexpected attributes %+v", attributes)
+	}
+}
+
+func TestCreateExisting(t *testing.T) {
+	fs := newFileSystem()
+
+	_, err := fs.Create(fs.Root(), "file", nfsv3.SAttr3{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = fs.Create(fs.Root(), "file", nfsv3.SAttr3{})
+	if err != syscall.EEXIST {
+		t.Fatalf("Expected %v but got %v", syscall.EEXIST, err)
+	}
+}
+
+func TestDirectoryLinkCount(t *testing.T) {
+	fs := newFileSystem()
+
+	dirID, err := fs.MkDir(fs.Root(), "dir", nfsv3.SAttr3{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	root, _ := fs.GetAttr(fs.Root())
+	if root.Nlink != 3 {
+		t.Fatalf("Expected nlink 3 but got %d", root.Nlink)
+	}
+
+	parentID, err := fs.Lookup(dirID, "..")
+	if err != nil || parentID != fs.Root() {
+		t.Fatalf("Expected parent %d but got %d (%v)", fs.Root(), parentID, err)
+	}
+
+	_, err = fs.Create(dirID, "file", nfsv3.SAttr3{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = fs.RmDir(fs.Root(), "dir")
+	if err != syscall.ENOTEMPTY {
+		t.Fatalf("Expected %v but got %v", syscall.ENOTEMPTY, err)
+	}
+
+	err = fs.Remove(dirID, "file")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = fs.RmDir(fs.Root(), "dir")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	root, _ = fs.GetAttr(fs.Root())
+	if root.Nlink != 2 {
+		t.Fatalf("Expected nlink 2 but got %d", root.Nlink)
+	}
+
+	_, err = fs.GetAttr(dirID)
+	if err != syscall.ENOENT {
+		t.Fatalf("Expected %v but got %v", syscall.ENOENT, err)
+	}
+}
+
+func TestHardLinks(t *testing.T) {
+	fs := newFileSystem()
+
+	fileID, _ := fs.Create(fs.Root(), "a", nfsv3.SAttr3{})
+
+	err := fs.Link(fileID, fs.Root(), "b")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	attributes, _ := fs.GetAttr(fileID)
+	if attributes.Nlink != 2 {
+		t.Fatalf("Expected nlink 2 but got %d", attributes.Nlink)
+	}
+
+	_ = fs.Remove(fs.Root(), "a")
+
+	attributes, err = fs.GetAttr(fileID)
+	if err != nil || attributes.Nlink != 1 {
+		t.Fatalf("Expected nlink 1 but got %d (%v)", attributes.Nlink, err)
+	}
+}
+
+func TestRenameReplace(t *testing.T) {
+	fs := newFileSystem()
+
+	dirID, _ := fs.MkDir(fs.Root(), "dir", nfsv3.SAttr3{})
+	sourceID, _ := fs.Create(fs.Root(), "new", nfsv3.SAttr3{})
+	targetID, _ := fs.Create(dirID, "old", nfsv3.SAttr3{})
+
+	err := fs.Rename(fs.Root(), "new", dirID, "old")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	gotID, err := fs.Lookup(dirID, "old")
+	if err != nil || gotID != sourceID {
+		t.Fatalf("Expected %d but got %d (%v)", sourceID, gotID, err)
+	}
+
+	_, err = fs.GetAttr(targetID)
+	if err != syscall.ENOENT {
+		t.Fatalf("Expected %v but got %v", syscall.ENOENT, err)
+	}
+
+	err = fs.Rename(fs.Root(), "dir", dirID, "sub")
+	if err != syscall.EINVAL {
+		t.Fatalf("Expected %v but got %v", syscall.EINVAL, err)
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	fs := newFileSystem()
+
+	fileID, _ := fs.Create(fs.Root(), "b", nfsv3.SAttr3{})
+	linkID, _ := fs.Symlink(fs.Root(), "a", "b", nfsv3.SAttr3{})
+
+	got, err := fs.ReadDir(fs.Root())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := []nfsv3.DirEntry{
+		{FileID: fs.Root(), Name: ".", Cookie: 1},
+		{FileID: fs.Root(), Name: "..", Cookie: 2},
+		{FileID: fileID, Name: "b", Cookie: 3},
+		{FileID: linkID, Name: "a", Cookie: 4},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %v but got %v", expected, got)
+	}
+
+	// renamed entries are listed after all existing entries
+	_ = fs.Rename(fs.Root(), "b", fs.Root(), "c")
+
+	got, _ = fs.ReadDir(fs.Root())
+
+	expected = []nfsv3.DirEntry{
+		{FileID: fs.Root(), Name: ".", Cookie: 1},
+		{FileID: fs.Root(), Name: "..", Cookie: 2},
+		{FileID: linkID, Name: "a", Cookie: 4},
+		{FileID: fileID, Name: "c", Cookie: 5},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %v but got %v", expected, got)
+	}
+}
+
+func TestNoSpace(t *testing.T) {
+	fs := memfs.New(8, 1024)
+
+	fileID, _ := fs.Create(fs.Root(), "file", nfsv3.SAttr3{})
+
+	_, err := fs.Write(fileID, 0, make([]byte, 9))
+	if err != syscall.ENOSPC {
+		t.Fatalf("Expected %v but got %v", syscall.ENOSPC, err)
+	}
+}
+
+func TestEmptyWritePastEnd(t *testing.T) {
+	fs := newFileSystem()
+
+	fileID, _ := fs.Create(fs.Root(), "file", nfsv3.SAttr3{})
+
+	count, err := fs.Write(fileID, 100, []byte{})
+	if err != nil || count != 0 {
+		t.Fatalf("Expected %v but got %v (%v)", 0, count, err)
+	}
+
+	got, eof, err := fs.Read(fileID, 0, 10)
+	if err != nil || len(got) != 0 || !eof {
+		t.Fatalf("Expected empty file but got %v (eof %v, %v)", got, eof, err)
+	}
+}
+
+func TestHugeSize(t *testing.T) {
+	fs := memfs.New(8, 1024)
+
+	fileID, _ := fs.Create(fs.Root(), "file", nfsv3.SAttr3{})
+	_, _ = fs.Write(fileID, 0, []byte("Hi"))
+
+	otherID, _ := fs.Create(fs.Root(), "other", nfsv3.SAttr3{})
+
+	err := fs.SetAttr(otherID, nfsv3.SAttr3{Size: nfsv3.SetSize3{SetIt: 1, Size: ^uint64(0)}})
+	if err != syscall.ENOSPC {
+		t.Fatalf("Expected %v but got %v", syscall.ENOSPC, err)
+	}
+}
+
+func TestConcurrentCreate(t *testing.T) {
+	fs := newFileSystem()
+
+	var waitGroup sync.WaitGroup
+
+	for i := 0; i < 16; i++ {
+		waitGroup.Add(1)
+
+		go func(i int) {
+			defer waitGroup.Done()
+
+			fileID, err := fs.Create(fs.Root(), fmt.Sprintf("file%d", i), nfsv3.SAttr3{})
+			if err != nil {
+				t.Error(err.Error())
+				return
+			}
+
+			_, err = fs.Write(fileID, 0, []byte{byte(i)})
+			if err != nil {
+				t.Error(err.Error())
+			}
+		}(i)
+	}
+
+	waitGroup.Wait()
+
+	entries, _ := fs.ReadDir(fs.Root())
+	if len(entries) != 18 {
+		t.Fatalf("Expected 18 entries but got %d", len(entries))
+	}
+}
+
+func TestConcurrentRead(t *testing.T) {
+	fs := newFileSystem()
+
+	fileID, err := fs.Create(fs.Root(), "file", nfsv3.SAttr3{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var waitGroup sync.WaitGroup
+
+	for i := 0; i < 16; i++ {
+		waitGroup.Add(2)
+
+		go func(i int) {
+			defer waitGroup.Done()
+
+			_, err := fs.Write(fileID, uint64(i), []byte{byte(i)})
+			if err != nil {
+				t.Error(err.Error())
+			}
+		}(i)
+
+		go func() {
+			defer waitGroup.Done()
+
+			_, _, err := fs.Read(fileID, 0, 16)
+			if err != nil {
+				t.Error(err.Error())
+			}
+
+			fs.GetAttr(fileID)
+		}()
+	}
+
+	waitGroup.Wait()
+
+	got, _, _ := fs.Read(fileID, 0, 16)
+	if len(got) != 16 {
+		t.Fatalf("Expected 16 bytes but got %d", len(got))
+	}
+}
@@ -4,6 +4,8 @@
 
 package mountv3
 
+import "github.com/dlorch/base-nfs/rpcv2"
+
 // MountProcedure3Export is the number for this RPC procedure (MOUNTPROC3_EXPORT)
 const MountProcedure3Export uint32 = 5
 
@@ -25,20 +27,27 @@ type Exports struct {
 // Export returns a list of all the exported file systems and which
 // clients are allowed to mount each one.
 // https://tools.ietf.org/html/rfc1813#page-113
-func Export(procedureArguments []byte) (interface{}, error) {
+func (mountService *MountService) Export(call *rpcv2.CallContext, procedureArguments []byte) (interface{}, error) {
 	exports := &Exports{
-		ValueFollows: 1,
-		ExDir:        "/volume1/Public",
-		ExGroups: Groups{
+		ValueFollows: 0,
+	}
+
+	nfsExports := mountService.nfsService.Exports()
+
+	// build the linked list back to front so that it is sorted by path
+	for i := len(nfsExports) - 1; i >= 0; i-- {
+		exports = &Exports{
 			ValueFollows: 1,
-			GrName:       "*",
-			GrNext: &Groups{
-				ValueFollows: 0,
+			ExDir:        nfsExports[i].Path,
+			ExGroups: Groups{
+				ValueFollows: 1,
+				GrName:       "*",
+				GrNext: &Groups{
+					ValueFollows: 0,
+				},
 			},
-		},
-		ExNext: &Exports{
-			ValueFollows: 0,
-		},
+			ExNext: exports,
+		}
 	}
 
 	return exports, nil
@@ -7,7 +7,9 @@ package mountv3
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 
+	"github.com/dlorch/base-nfs/nfsv3"
 	"github.com/dlorch/base-nfs/rpcv2"
 )
 
@@ -28,7 +30,7 @@ type MountRes3 struct {
 
 // Mnt maps a pathname on the server to a file handle.
 // https://tools.ietf.org/html/rfc1813#page-109
-func Mnt(procedureArguments []byte) (interface{}, error) {
+func (mountService *MountService) Mnt(call *rpcv2.CallContext, procedureArguments []byte) (interface{}, error) {
 	// parse request
 	requestBuffer := bytes.NewBuffer(procedureArguments)
 
@@ -40,7 +42,11 @@ func Mnt(procedureArguments []byte) (interface{}, error) {
 		return nil, err
 	}
 
-	dirPathName := make([]byte, dirPathLength) // TODO check MNTPATHLEN
+	if dirPathLength > MountPathLength {
+		return &MountRes3{FhsStatus: Mount3ErrorNameTooLong}, nil
+	}
+
+	dirPathName := make([]byte, dirPathLength)
 
 	err = binary.Read(requestBuffer, binary.BigEndian, &dirPathName)
 
@@ -48,10 +54,22 @@ func Mnt(procedureArguments []byte) (interface{}, error) {
 		return nil, err
 	}
 
+	fileHandle, status := mountService.nfsService.MountHandle(string(dirPathName))
+
+	switch status {
+	case nfsv3.NFS3OK:
+	case nfsv3.NFS3ErrNoEnt:
+		return &MountRes3{FhsStatus: Mount3ErrorNoEntry}, nil
+	default:
+		return &MountRes3{FhsStatus: Mount3ErrorServerFault}, nil
+	}
+
+	fmt.Printf("[mount] %s mounted %s\n", call.RemoteAddr, dirPathName)
+
 	mountOk := &MountRes3{
 		FhsStatus: Mount3OK,
 		MountInfo: MountRes3OK{
-			FHandle:     []byte{0, 0, 0, 42},
+			FHandle:     fileHandle.Data,
 			AuthFlavors: []uint32{rpcv2.AuthenticationUNIX},
 		},
 	}
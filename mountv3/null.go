@@ -4,9 +4,11 @@
 
 package mountv3
 
+import "github.com/dlorch/base-nfs/rpcv2"
+
 // VoidReply is an empty reply
 type VoidReply struct{}
 
-func mountProcedure3Null(procedureArguments []byte) (interface{}, error) {
+func mountProcedure3Null(call *rpcv2.CallContext, procedureArguments []byte) (interface{}, error) {
 	return &VoidReply{}, nil
 }
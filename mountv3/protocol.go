@@ -8,6 +8,8 @@ package mountv3
 const (
 	Program                    uint32 = 100005 // Mount service program number
 	Version                    uint32 = 3      // Mount service version
+	Version1                   uint32 = 1      // Mount service version 1, used by showmount (RFC1094: Appendix A)
+	MountPathLength            uint32 = 1024   // MNTPATHLEN: Maximum bytes in a path name
 	MountProcedure3Null        uint32 = 0      // MOUNTPROC3_NULL
 	MountProcedure3Dump        uint32 = 2      // MOUNTPROC3_DUMP
 	MountProcedure3Unmount     uint32 = 3      // MOUNTPROC3_UMNT
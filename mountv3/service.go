@@ -5,23 +5,31 @@
 package mountv3
 
 import (
+	"github.com/dlorch/base-nfs/nfsv3"
 	"github.com/dlorch/base-nfs/rpcv2"
 )
 
 // MountService ...
 type MountService struct {
 	rpcv2.RPCService
+	nfsService *nfsv3.NFSService
 }
 
-// NewMountService ...
-func NewMountService() *MountService {
+// NewMountService returns a mount service handing out file handles of the given NFS service
+func NewMountService(nfsService *nfsv3.NFSService) *MountService {
 	mountService := &MountService{
 		RPCService: *rpcv2.NewRPCService("mount", Program, Version),
+		nfsService: nfsService,
 	}
 
 	mountService.RegisterProcedure(MountProcedure3Null, mountProcedure3Null)
-	mountService.RegisterProcedure(MountProcedure3Export, Export)
-	mountService.RegisterProcedure(MountProcedure3Mnt, Mnt)
+	mountService.RegisterProcedure(MountProcedure3Export, mountService.Export)
+	mountService.RegisterProcedure(MountProcedure3Mnt, mountService.Mnt)
+
+	// the export list of version 1 is the same, but its file handles are those of NFSv2,
+	// which isn't served
+	mountService.RegisterVersionProcedure(Version1, MountProcedure3Null, mountProcedure3Null)
+	mountService.RegisterVersionProcedure(Version1, MountProcedure3Export, mountService.Export)
 
 	return mountService
 }
@@ -2,58 +2,64 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+// Procedure 4: ACCESS - Check Access Permission
+// https://tools.ietf.org/html/rfc1813#page-40
+
 package nfsv3
 
-// Access3ResultOK (union ACCESS3resok)
-type Access3ResultOK struct {
-	Access3Result
-	PostOpAttr
+import (
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// Access3Args (struct ACCESS3args)
+type Access3Args struct {
+	Object NFSFH3
 	Access uint32
 }
 
-// Access3Result (union ACCESS3res)
-type Access3Result struct {
-	Status uint32
+// Access3ResOK (struct ACCESS3resok)
+type Access3ResOK struct {
+	ObjAttributes PostOpAttr
+	Access        uint32
 }
 
-func nfsProcedure3Access(procedureArguments []byte) (interface{}, error) {
-	// prepare result
-	fsInfoResult := &Access3ResultOK{
-		Access3Result: Access3Result{
-			Status: NFS3OK,
-		},
-		PostOpAttr: PostOpAttr{
-			AttributesFollow: 1,
-			ObjectAttributes: FAttr3{
-				Type:  2,
-				Mode:  040777,
-				Nlink: 4,
-				UID:   0,
-				GID:   0,
-				Size:  4096,
-				Used:  8192,
-				RDev: SpecData3{
-					SpecData1: 0,
-					SpecData2: 0,
-				},
-				FSID:   0x388e4346cfc706a8,
-				FileID: 16,
-				ATime: NFSTime3{
-					Seconds:  1563137262,
-					NSeconds: 460002975,
-				},
-				MTime: NFSTime3{
-					Seconds:  1537128120,
-					NSeconds: 839607220,
-				},
-				CTime: NFSTime3{
-					Seconds:  1537128120,
-					NSeconds: 839607220,
-				},
-			},
-		},
-		Access: 0x1f,
+// Access3ResFail (struct ACCESS3resfail)
+type Access3ResFail struct {
+	ObjAttributes PostOpAttr
+}
+
+// Access3Res (union ACCESS3res)
+type Access3Res struct {
+	Status  uint32         `xdr:"switch"`
+	ResOK   Access3ResOK   `xdr:"case=0"`
+	ResFail Access3ResFail `xdr:"default"`
+}
+
+// Access3 (NFSPROC3_ACCESS) determines which of the requested rights the caller
+// has on an object, based on its mode, owner and group and the caller's AUTH_UNIX
+// credentials. The same rules are enforced by the other procedures.
+func (nfsService *NFSService) Access3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var accessArgs Access3Args
+
+	_, err := xdr.Unmarshal(arg, &accessArgs)
+
+	if err != nil {
+		return nil, err
 	}
 
-	return fsInfoResult, nil
+	obj, status := nfsService.resolveHandle(accessArgs.Object.Data)
+
+	if status != NFS3OK {
+		return &Access3Res{Status: status}, nil
+	}
+
+	res := &Access3Res{
+		Status: NFS3OK,
+		ResOK: Access3ResOK{
+			ObjAttributes: PostOpAttr{AttributesFollow: 1, ObjectAttributes: obj.attributes},
+			Access:        callerCredentials(call).access(obj.attributes, accessArgs.Access),
+		},
+	}
+	return res, nil
 }
@@ -0,0 +1,123 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nfsv3_test
+
+import (
+	"testing"
+
+	"github.com/dlorch/base-nfs/nfsv3"
+	"github.com/dlorch/base-nfs/rpcv2"
+)
+
+const allAccess = nfsv3.Access3Read | nfsv3.Access3Lookup | nfsv3.Access3Modify | nfsv3.Access3Extend | nfsv3.Access3Delete | nfsv3.Access3Execute
+
+func TestAccess(t *testing.T) {
+	nfsService, fileSystem, rootHandle := newService(t)
+
+	fileID, _ := fileSystem.Create(fileSystem.Root(), "file", nfsv3.SAttr3{
+		Mode: nfsv3.SetMode3{SetIt: 1, Mode: 0640},
+		UID:  nfsv3.SetUID3{SetIt: 1, UID: 1000},
+		GID:  nfsv3.SetGID3{SetIt: 1, GID: 100},
+	})
+	file := lookup(t, nfsService, rootHandle, "file").ResOK.Object
+
+	tests := []struct {
+		callContext *rpcv2.CallContext
+		object      nfsv3.NFSFH3
+		access      uint32
+	}{
+		{unixCall(1000, 1000), file, nfsv3.Access3Read | nfsv3.Access3Modify | nfsv3.Access3Extend},
+		{unixCall(2000, 2000, 100), file, nfsv3.Access3Read},
+		{unixCall(2000, 2000), file, 0},
+		{&rpcv2.CallContext{}, file, 0},
+		{rootCall, file, nfsv3.Access3Read | nfsv3.Access3Modify | nfsv3.Access3Extend},
+		{rootCall, rootHandle, nfsv3.Access3Read | nfsv3.Access3Lookup | nfsv3.Access3Modify | nfsv3.Access3Extend | nfsv3.Access3Delete},
+		{unixCall(1000, 1000), rootHandle, nfsv3.Access3Read | nfsv3.Access3Lookup},
+	}
+
+	for i, test := range tests {
+		res := callAs(t, test.callContext, nfsService.Access3, nfsv3.Access3Args{Object: test.object, Access: allAccess}).(*nfsv3.Access3Res)
+		if res.Status != nfsv3.NFS3OK || res.ResOK.Access != test.access {
+			t.Fatalf("Expected %#x but got %#x (test %d)", test.access, res.ResOK.Access, i)
+		}
+	}
+
+	res := callAs(t, unixCall(1000, 1000), nfsService.Access3, nfsv3.Access3Args{Object: file, Access: nfsv3.Access3Read}).(*nfsv3.Access3Res)
+	if res.ResOK.Access != nfsv3.Access3Read || res.ResOK.ObjAttributes.ObjectAttributes.FileID != fileID {
+		t.Fatalf("Unexpected result %+v", res)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	nfsService, fileSystem, rootHandle := newService(t)
+
+	fileSystem.Create(fileSystem.Root(), "file", nfsv3.SAttr3{
+		Mode: nfsv3.SetMode3{SetIt: 1, Mode: 0640},
+		UID:  nfsv3.SetUID3{SetIt: 1, UID: 1000},
+		GID:  nfsv3.SetGID3{SetIt: 1, GID: 100},
+	})
+	file := lookup(t, nfsService, rootHandle, "file").ResOK.Object
+
+	owner := unixCall(1000, 1000)
+	group := unixCall(2000, 2000, 100)
+	other := unixCall(3000, 3000)
+
+	writeArgs := nfsv3.Write3Args{File: file, Count: 5, Stable: nfsv3.FileSync, Data: []byte("hello")}
+	readArgs := nfsv3.Read3Args{File: file, Count: 5}
+	mkDirArgs := nfsv3.MkDir3Args{Where: nfsv3.DirOpArgs3{Dir: rootHandle, Name: "dir"}}
+	chmodArgs := nfsv3.SetAttr3Args{Object: file, NewAttributes: nfsv3.SAttr3{Mode: nfsv3.SetMode3{SetIt: 1, Mode: 0600}}}
+	mkNodArgs := nfsv3.MkNod3Args{Where: nfsv3.DirOpArgs3{Dir: rootHandle, Name: "null"}, What: nfsv3.MkNodData3{Type: nfsv3.NF3Chr}}
+
+	tests := []struct {
+		status uint32
+		got    uint32
+	}{
+		{nfsv3.NFS3OK, callAs(t, owner, nfsService.Write3, writeArgs).(*nfsv3.Write3Res).Status},
+		{nfsv3.NFS3ErrAcces, callAs(t, group, nfsService.Write3, writeArgs).(*nfsv3.Write3Res).Status},
+		{nfsv3.NFS3OK, callAs(t, group, nfsService.Read3, readArgs).(*nfsv3.Read3Res).Status},
+		{nfsv3.NFS3ErrAcces, callAs(t, other, nfsService.Read3, readArgs).(*nfsv3.Read3Res).Status},
+		{nfsv3.NFS3ErrAcces, callAs(t, owner, nfsService.MkDir3, mkDirArgs).(*nfsv3.MkDir3Res).Status},
+		{nfsv3.NFS3ErrPerm, callAs(t, group, nfsService.SetAttr3, chmodArgs).(*nfsv3.SetAttr3Res).Status},
+		{nfsv3.NFS3OK, callAs(t, owner, nfsService.SetAttr3, chmodArgs).(*nfsv3.SetAttr3Res).Status},
+		{nfsv3.NFS3ErrPerm, callAs(t, owner, nfsService.MkNod3, mkNodArgs).(*nfsv3.MkNod3Res).Status},
+	}
+
+	for i, test := range tests {
+		if test.got != test.status {
+			t.Fatalf("Expected %v but got %v (test %d)", test.status, test.got, i)
+		}
+	}
+}
+
+func TestPermissionsStickyDirectory(t *testing.T) {
+	nfsService, fileSystem, rootHandle := newService(t)
+
+	fileSystem.SetAttr(fileSystem.Root(), nfsv3.SAttr3{Mode: nfsv3.SetMode3{SetIt: 1, Mode: 01777}})
+
+	owner := unixCall(1000, 1000)
+	other := unixCall(2000, 2000)
+
+	res := callAs(t, owner, nfsService.MkDir3, nfsv3.MkDir3Args{Where: nfsv3.DirOpArgs3{Dir: rootHandle, Name: "dir"}}).(*nfsv3.MkDir3Res)
+	if res.Status != nfsv3.NFS3OK {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3OK, res.Status)
+	}
+
+	attributes := res.ResOK.ObjAttributes.ObjectAttributes
+	if attributes.UID != 1000 || attributes.GID != 1000 {
+		t.Fatalf("Expected owner 1000:1000 but got %d:%d", attributes.UID, attributes.GID)
+	}
+
+	rmDirArgs := nfsv3.RmDir3Args{Object: nfsv3.DirOpArgs3{Dir: rootHandle, Name: "dir"}}
+
+	rmDirRes := callAs(t, other, nfsService.RmDir3, rmDirArgs).(*nfsv3.RmDir3Res)
+	if rmDirRes.Status != nfsv3.NFS3ErrAcces {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrAcces, rmDirRes.Status)
+	}
+
+	rmDirRes = callAs(t, owner, nfsService.RmDir3, rmDirArgs).(*nfsv3.RmDir3Res)
+	if rmDirRes.Status != nfsv3.NFS3OK {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3OK, rmDirRes.Status)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Procedure 21: COMMIT - Commit cached data on a server to stable storage
+// https://tools.ietf.org/html/rfc1813#page-92
+
+package nfsv3
+
+import (
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// Commit3Args (struct COMMIT3args)
+type Commit3Args struct {
+	File   NFSFH3
+	Offset uint64
+	Count  uint32
+}
+
+// Commit3ResOK (struct COMMIT3resok)
+type Commit3ResOK struct {
+	FileWcc WccData
+	Verf    [NFS3WriteVerfSize]byte
+}
+
+// Commit3ResFail (struct COMMIT3resfail)
+type Commit3ResFail struct {
+	FileWcc WccData
+}
+
+// Commit3Res (union COMMIT3res)
+type Commit3Res struct {
+	Status  uint32         `xdr:"switch"`
+	ResOK   Commit3ResOK   `xdr:"case=0"`
+	ResFail Commit3ResFail `xdr:"default"`
+}
+
+// Commit3 (NFSPROC3_COMMIT) forces data previously written with an unstable WRITE
+// to stable storage. The write verifier in the reply tells the client whether the
+// server was restarted since, in which case the uncommitted data must be resent.
+func (nfsService *NFSService) Commit3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var commitArgs Commit3Args
+
+	_, err := xdr.Unmarshal(arg, &commitArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	file, status := nfsService.resolveHandle(commitArgs.File.Data)
+
+	if status != NFS3OK {
+		return &Commit3Res{Status: status}, nil
+	}
+
+	err = file.export.FileSystem.Commit(file.fileID, commitArgs.Offset, commitArgs.Count)
+
+	if err != nil {
+		res := &Commit3Res{
+			Status: errorStatus(err),
+			ResFail: Commit3ResFail{
+				FileWcc: file.export.wccData(file.attributes, file.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	res := &Commit3Res{
+		Status: NFS3OK,
+		ResOK: Commit3ResOK{
+			FileWcc: file.export.wccData(file.attributes, file.fileID),
+			Verf:    nfsService.writeVerifier,
+		},
+	}
+	return res, nil
+}
@@ -0,0 +1,185 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Procedure 8: CREATE - Create a file
+// https://tools.ietf.org/html/rfc1813#page-54
+
+package nfsv3
+
+import (
+	"encoding/binary"
+
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// CreateHow3 (union createhow3)
+type CreateHow3 struct {
+	Mode          uint32                   `xdr:"switch"`
+	ObjAttributes SAttr3                   `xdr:"case=0,1"`
+	Verf          [NFS3CreateVerfSize]byte `xdr:"case=2"`
+}
+
+// Create3Args (struct CREATE3args)
+type Create3Args struct {
+	Where DirOpArgs3
+	How   CreateHow3
+}
+
+// Create3ResOK (struct CREATE3resok)
+type Create3ResOK struct {
+	Obj           PostOpFH3
+	ObjAttributes PostOpAttr
+	DirWcc        WccData
+}
+
+// Create3ResFail (struct CREATE3resfail)
+type Create3ResFail struct {
+	DirWcc WccData
+}
+
+// Create3Res (union CREATE3res)
+type Create3Res struct {
+	Status  uint32         `xdr:"switch"`
+	ResOK   Create3ResOK   `xdr:"case=0"`
+	ResFail Create3ResFail `xdr:"default"`
+}
+
+// verifierAttributes returns the attributes storing the verifier of an exclusive
+// create in the access and modification time of the new file. The client replaces
+// them with a subsequent SETATTR once the file has been created.
+func verifierAttributes(verifier [NFS3CreateVerfSize]byte) SAttr3 {
+	var attributes SAttr3
+
+	attributes.ATime.SetIt = SetToClientTime
+	attributes.ATime.ATime.Seconds = binary.BigEndian.Uint32(verifier[0:4])
+	attributes.MTime.SetIt = SetToClientTime
+	attributes.MTime.MTime.Seconds = binary.BigEndian.Uint32(verifier[4:8])
+
+	return attributes
+}
+
+// matchesVerifier returns whether an existing file was created by an exclusive
+// create with the given verifier
+func matchesVerifier(attributes FAttr3, verifier [NFS3CreateVerfSize]byte) bool {
+	return attributes.Type == NF3Reg &&
+		attributes.ATime.Seconds == binary.BigEndian.Uint32(verifier[0:4]) &&
+		attributes.MTime.Seconds == binary.BigEndian.Uint32(verifier[4:8]) &&
+		attributes.ATime.NSeconds == 0 && attributes.MTime.NSeconds == 0
+}
+
+// createFile creates a regular file owned by the caller according to the mode in how
+// and returns its FileID
+func createFile(dir object, creds credentials, name string, how CreateHow3) (uint64, error) {
+	fileSystem := dir.export.FileSystem
+	dirID := dir.fileID
+	attributes := how.ObjAttributes
+
+	if how.Mode == Exclusive {
+		attributes = verifierAttributes(how.Verf)
+	}
+
+	attributes, status := creds.checkCreate(dir.attributes, attributes)
+
+	if status != NFS3OK {
+		return 0, StatusError(status)
+	}
+
+	fileID, err := fileSystem.Create(dirID, name, attributes)
+
+	if errorStatus(err) != NFS3ErrExist || how.Mode == Guarded {
+		return fileID, err
+	}
+
+	// the file already exists
+	fileID, err = fileSystem.Lookup(dirID, name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	existing, err := fileSystem.GetAttr(fileID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case how.Mode == Exclusive && matchesVerifier(existing, how.Verf): // retransmission
+		return fileID, nil
+	case how.Mode == Unchecked && existing.Type == NF3Reg:
+		// like open(2) with O_CREAT, only truncation applies to an existing file
+		if how.ObjAttributes.Size.SetIt == 1 && creds.checkWrite(existing) != NFS3OK {
+			return 0, StatusError(NFS3ErrAcces)
+		}
+
+		return fileID, fileSystem.SetAttr(fileID, SAttr3{Size: how.ObjAttributes.Size})
+	default:
+		return 0, StatusError(NFS3ErrExist)
+	}
+}
+
+// Create3 (NFSPROC3_CREATE) creates a regular file. Exclusive creates store the
+// client's verifier with the file, so that retransmitted requests succeed.
+func (nfsService *NFSService) Create3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var createArgs Create3Args
+
+	_, err := xdr.Unmarshal(arg, &createArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dir, status := nfsService.resolveHandle(createArgs.Where.Dir.Data)
+
+	if status != NFS3OK {
+		return &Create3Res{Status: status}, nil
+	}
+
+	status = dir.export.checkName(createArgs.Where.Name)
+
+	if status == NFS3OK && createArgs.How.Mode > Exclusive {
+		status = NFS3ErrInval
+	}
+
+	if status != NFS3OK {
+		res := &Create3Res{
+			Status: status,
+			ResFail: Create3ResFail{
+				DirWcc: dir.export.wccData(dir.attributes, dir.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	fileID, err := createFile(dir, callerCredentials(call), createArgs.Where.Name, createArgs.How)
+
+	if err != nil {
+		res := &Create3Res{
+			Status: errorStatus(err),
+			ResFail: Create3ResFail{
+				DirWcc: dir.export.wccData(dir.attributes, dir.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	res := &Create3Res{
+		Status: NFS3OK,
+		ResOK: Create3ResOK{
+			DirWcc: dir.export.wccData(dir.attributes, dir.fileID),
+		},
+	}
+
+	// the file was created even if its handle can't be returned, in which case
+	// the client obtains it with a subsequent LOOKUP
+	fileHandle, attributes, status := nfsService.fileHandle(dir.export, fileID)
+
+	if status == NFS3OK {
+		res.ResOK.Obj = PostOpFH3{HandleFollows: 1, Handle: fileHandle}
+		res.ResOK.ObjAttributes = PostOpAttr{AttributesFollow: 1, ObjectAttributes: attributes}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nfsv3_test
+
+import (
+	"testing"
+
+	"github.com/dlorch/base-nfs/nfsv3"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+func create(t *testing.T, nfsService *nfsv3.NFSService, dir nfsv3.NFSFH3, name string, how nfsv3.CreateHow3) *nfsv3.Create3Res {
+	args, err := xdr.Marshal(nfsv3.Create3Args{Where: nfsv3.DirOpArgs3{Dir: dir, Name: name}, How: how})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err := nfsService.Create3(rootCall, args)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return res.(*nfsv3.Create3Res)
+}
+
+func TestCreate(t *testing.T) {
+	nfsService, fileSystem, rootHandle := newService(t)
+
+	how := nfsv3.CreateHow3{Mode: nfsv3.Guarded}
+	how.ObjAttributes.Mode = nfsv3.SetMode3{SetIt: 1, Mode: 0600}
+
+	res := create(t, nfsService, rootHandle, "file", how)
+	if res.Status != nfsv3.NFS3OK || res.ResOK.Obj.HandleFollows != 1 || res.ResOK.ObjAttributes.ObjectAttributes.Mode != 0600 {
+		t.Fatalf("Unexpected result %+v", res)
+	}
+	if res.ResOK.DirWcc.Before.AttributesFollow != 1 || res.ResOK.DirWcc.After.AttributesFollow != 1 {
+		t.Fatalf("Expected directory wcc data but got %+v", res.ResOK.DirWcc)
+	}
+
+	file := res.ResOK.Obj.Handle
+	write(t, nfsService, nfsv3.Write3Args{File: file, Count: 5, Stable: nfsv3.FileSync, Data: []byte("Hello")})
+
+	res = create(t, nfsService, rootHandle, "file", how)
+	if res.Status != nfsv3.NFS3ErrExist {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrExist, res.Status)
+	}
+
+	how = nfsv3.CreateHow3{Mode: nfsv3.Unchecked}
+	how.ObjAttributes.Size = nfsv3.SetSize3{SetIt: 1, Size: 0}
+
+	res = create(t, nfsService, rootHandle, "file", how)
+	if res.Status != nfsv3.NFS3OK || res.ResOK.ObjAttributes.ObjectAttributes.Size != 0 {
+		t.Fatalf("Expected existing file to be truncated but got %+v", res)
+	}
+	if res.ResOK.ObjAttributes.ObjectAttributes.Mode != 0600 {
+		t.Fatalf("Expected mode %o but got %o", 0600, res.ResOK.ObjAttributes.ObjectAttributes.Mode)
+	}
+
+	_, _ = fileSystem.MkDir(fileSystem.Root(), "dir", nfsv3.SAttr3{})
+
+	res = create(t, nfsService, rootHandle, "dir", how)
+	if res.Status != nfsv3.NFS3ErrExist {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrExist, res.Status)
+	}
+}
+
+func TestCreateExclusive(t *testing.T) {
+	nfsService, _, rootHandle := newService(t)
+
+	how := nfsv3.CreateHow3{Mode: nfsv3.Exclusive, Verf: [nfsv3.NFS3CreateVerfSize]byte{1, 2, 3, 4, 5, 6, 7, 8}}
+
+	res := create(t, nfsService, rootHandle, "file", how)
+	if res.Status != nfsv3.NFS3OK {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3OK, res.Status)
+	}
+
+	fileID := res.ResOK.ObjAttributes.ObjectAttributes.FileID
+
+	// a retransmitted request succeeds
+	res = create(t, nfsService, rootHandle, "file", how)
+	if res.Status != nfsv3.NFS3OK || res.ResOK.ObjAttributes.ObjectAttributes.FileID != fileID {
+		t.Fatalf("Expected FileID %d but got %+v", fileID, res)
+	}
+
+	// a request by another client fails
+	how.Verf[7] = 9
+
+	res = create(t, nfsService, rootHandle, "file", how)
+	if res.Status != nfsv3.NFS3ErrExist {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrExist, res.Status)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nfsv3
+
+import "github.com/dlorch/base-nfs/rpcv2"
+
+// User and group IDs with special meaning
+const (
+	rootUID      uint32 = 0     // the superuser, which passes all permission checks
+	anonymousUID uint32 = 65534 // callers without AUTH_UNIX credentials (nobody)
+	anonymousGID uint32 = 65534 // primary group of anonymous callers (nogroup)
+)
+
+// Mode bits checked in addition to the permission bits
+const (
+	modeSetGID uint32 = 02000 // new objects inherit the group of the directory
+	modeSticky uint32 = 01000 // only owners may remove entries of the directory
+)
+
+// Permission bits of a mode, as applying to owner, group or others
+const (
+	permissionRead    uint32 = 4
+	permissionWrite   uint32 = 2
+	permissionExecute uint32 = 1
+)
+
+// credentials identify the caller of a procedure for permission checks
+type credentials struct {
+	uid  uint32
+	gid  uint32
+	gids []uint32 // supplementary groups
+}
+
+// callerCredentials returns the credentials of the caller. Callers using another
+// flavor than AUTH_UNIX are anonymous.
+func callerCredentials(call *rpcv2.CallContext) credentials {
+	if call == nil || call.Unix == nil {
+		return credentials{uid: anonymousUID, gid: anonymousGID}
+	}
+
+	return credentials{uid: call.Unix.UID, gid: call.Unix.GID, gids: call.Unix.GIDs}
+}
+
+// inGroup returns whether the caller is a member of group gid
+func (creds credentials) inGroup(gid uint32) bool {
+	if creds.gid == gid {
+		return true
+	}
+
+	for _, supplementary := range creds.gids {
+		if supplementary == gid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isOwner returns whether the caller owns an object or is the superuser
+func (creds credentials) isOwner(attributes FAttr3) bool {
+	return creds.uid == rootUID || creds.uid == attributes.UID
+}
+
+// permissions returns the permission bits of an object which apply to the caller
+func (creds credentials) permissions(attributes FAttr3) uint32 {
+	if creds.uid == rootUID {
+		permissions := permissionRead | permissionWrite
+
+		// the superuser may only execute files which are executable by someone
+		if attributes.Type == NF3Dir || attributes.Mode&0111 != 0 {
+			permissions |= permissionExecute
+		}
+
+		return permissions
+	}
+
+	switch {
+	case creds.uid == attributes.UID:
+		return attributes.Mode >> 6 & 7
+	case creds.inGroup(attributes.GID):
+		return attributes.Mode >> 3 & 7
+	default:
+		return attributes.Mode & 7
+	}
+}
+
+// access returns which of the requested ACCESS3 rights the caller has on an object.
+// LOOKUP and DELETE only apply to directories, EXECUTE only to other objects.
+func (creds credentials) access(attributes FAttr3, requested uint32) uint32 {
+	permissions := creds.permissions(attributes)
+
+	var granted uint32
+
+	if permissions&permissionRead != 0 {
+		granted |= Access3Read
+	}
+
+	if permissions&permissionWrite != 0 {
+		granted |= Access3Modify | Access3Extend
+	}
+
+	if attributes.Type == NF3Dir {
+		if permissions&permissionExecute != 0 {
+			granted |= Access3Lookup
+		}
+
+		if permissions&(permissionWrite|permissionExecute) == permissionWrite|permissionExecute {
+			granted |= Access3Delete
+		}
+	} else if permissions&permissionExecute != 0 {
+		granted |= Access3Execute
+	}
+
+	return granted & requested
+}
+
+// checkAccess returns NFS3ErrAcces unless the caller has all requested ACCESS3 rights on an object
+func (creds credentials) checkAccess(attributes FAttr3, requested uint32) uint32 {
+	if creds.access(attributes, requested) != requested {
+		return NFS3ErrAcces
+	}
+
+	return NFS3OK
+}
+
+// checkDirectory returns NFS3ErrNotDir for objects other than directories, and
+// NFS3ErrAcces unless the caller has all requested ACCESS3 rights on the directory
+func (creds credentials) checkDirectory(dir FAttr3, requested uint32) uint32 {
+	if dir.Type != NF3Dir {
+		return NFS3ErrNotDir
+	}
+
+	return creds.checkAccess(dir, requested)
+}
+
+// checkWrite checks whether the caller may change the data of a file. Like knfsd,
+// the owner may always write, so that a process can fill a file it created without
+// write permission.
+func (creds credentials) checkWrite(attributes FAttr3) uint32 {
+	if creds.isOwner(attributes) {
+		return NFS3OK
+	}
+
+	return creds.checkAccess(attributes, Access3Modify)
+}
+
+// checkCreate checks whether the caller may create an object with the given attributes
+// in directory dir, and returns the attributes completed with the owner of the object.
+// The caller owns new objects, which belong to the group of a setgid directory or the
+// caller's primary group otherwise.
+func (creds credentials) checkCreate(dir FAttr3, attributes SAttr3) (SAttr3, uint32) {
+	status := creds.checkDirectory(dir, Access3Modify|Access3Lookup)
+
+	if status != NFS3OK {
+		return attributes, status
+	}
+
+	if attributes.UID.SetIt == 0 {
+		attributes.UID = SetUID3{SetIt: 1, UID: creds.uid}
+	}
+
+	if attributes.GID.SetIt == 0 {
+		gid := creds.gid
+
+		if dir.Mode&modeSetGID != 0 {
+			gid = dir.GID
+		}
+
+		attributes.GID = SetGID3{SetIt: 1, GID: gid}
+	}
+
+	if creds.uid != rootUID && (attributes.UID.UID != creds.uid || !creds.inGroup(attributes.GID.GID) && attributes.GID.GID != dir.GID) {
+		return attributes, NFS3ErrPerm
+	}
+
+	return attributes, NFS3OK
+}
+
+// checkDelete checks whether the caller may remove or replace the entry called name
+// in directory dir. Entries of a directory with the sticky bit set may only be removed
+// by their owner or the owner of the directory.
+func (creds credentials) checkDelete(dir object, name string) uint32 {
+	status := creds.checkDirectory(dir.attributes, Access3Delete)
+
+	if status != NFS3OK || dir.attributes.Mode&modeSticky == 0 || creds.isOwner(dir.attributes) {
+		return status
+	}
+
+	fileID, err := dir.export.FileSystem.Lookup(dir.fileID, name)
+
+	if errorStatus(err) == NFS3ErrNoEnt { // nothing to protect
+		return NFS3OK
+	}
+
+	if err != nil {
+		return errorStatus(err)
+	}
+
+	attributes, err := dir.export.FileSystem.GetAttr(fileID)
+
+	if err != nil {
+		return errorStatus(err)
+	}
+
+	if !creds.isOwner(attributes) {
+		return NFS3ErrAcces
+	}
+
+	return NFS3OK
+}
+
+// checkSetAttr checks whether the caller may change the attributes of an object.
+// Only the owner may change the mode or set times of its choice, and only the
+// superuser may give an object away if chown is restricted.
+func (creds credentials) checkSetAttr(attributes FAttr3, newAttributes SAttr3, chownRestricted bool) uint32 {
+	owner := creds.isOwner(attributes)
+
+	if newAttributes.Mode.SetIt == 1 && !owner {
+		return NFS3ErrPerm
+	}
+
+	if newAttributes.UID.SetIt == 1 && newAttributes.UID.UID != attributes.UID && creds.uid != rootUID {
+		if chownRestricted || !owner {
+			return NFS3ErrPerm
+		}
+	}
+
+	if newAttributes.GID.SetIt == 1 && newAttributes.GID.GID != attributes.GID && creds.uid != rootUID {
+		if !owner || !creds.inGroup(newAttributes.GID.GID) {
+			return NFS3ErrPerm
+		}
+	}
+
+	for _, setIt := range []uint32{newAttributes.ATime.SetIt, newAttributes.MTime.SetIt} {
+		switch {
+		case setIt == SetToClientTime && !owner:
+			return NFS3ErrPerm
+		case setIt == SetToServerTime && creds.checkWrite(attributes) != NFS3OK:
+			return NFS3ErrAcces
+		}
+	}
+
+	if newAttributes.Size.SetIt == 1 {
+		return creds.checkWrite(attributes)
+	}
+
+	return NFS3OK
+}
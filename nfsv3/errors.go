@@ -0,0 +1,74 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nfsv3
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// StatusError is an error which carries an nfsstat3 value. FileSystem implementations
+// return it to control the exact status reported to the client.
+type StatusError uint32
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("nfsv3: status %d", uint32(e))
+}
+
+// errnoStatus maps system error numbers onto their nfsstat3 counterparts
+var errnoStatus = map[syscall.Errno]uint32{
+	syscall.EPERM:        NFS3ErrPerm,
+	syscall.ENOENT:       NFS3ErrNoEnt,
+	syscall.EIO:          NFS3ErrIO,
+	syscall.ENXIO:        NFS3ErrNXIO,
+	syscall.EACCES:       NFS3ErrAcces,
+	syscall.EEXIST:       NFS3ErrExist,
+	syscall.EXDEV:        NFS3ErrXDev,
+	syscall.ENODEV:       NFS3ErrNoDev,
+	syscall.ENOTDIR:      NFS3ErrNotDir,
+	syscall.EISDIR:       NFS3ErrIsDir,
+	syscall.EINVAL:       NFS3ErrInval,
+	syscall.EFBIG:        NFS3ErrFBig,
+	syscall.ENOSPC:       NFS3ErrNoSpc,
+	syscall.EROFS:        NFS3ErrROFS,
+	syscall.EMLINK:       NFS3ErrMLink,
+	syscall.ENAMETOOLONG: NFS3ErrNameTooLong,
+	syscall.ENOTEMPTY:    NFS3ErrNotEmpty,
+	syscall.EDQUOT:       NFS3ErrDQuot,
+	syscall.ESTALE:       NFS3ErrStale,
+	syscall.ENOTSUP:      NFS3ErrNotSupp,
+}
+
+// errorStatus translates an error returned by a FileSystem into an nfsstat3 value
+func errorStatus(err error) uint32 {
+	if err == nil {
+		return NFS3OK
+	}
+
+	var statusError StatusError
+	if errors.As(err, &statusError) {
+		return uint32(statusError)
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		if status, found := errnoStatus[errno]; found {
+			return status
+		}
+	}
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return NFS3ErrNoEnt
+	case errors.Is(err, os.ErrExist):
+		return NFS3ErrExist
+	case errors.Is(err, os.ErrPermission):
+		return NFS3ErrAcces
+	}
+
+	return NFS3ErrServerFault
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nfsv3
+
+import "hash/fnv"
+
+// Export is a file system made available to clients under a directory path
+type Export struct {
+	Path       string          // path clients mount, e.g. "/volume1/Public"
+	FileSystem FileSystem      // backend serving the export
+	Settings   *ExportSettings // limits and properties of the export, nil for DefaultExportSettings
+	id         uint32          // identifies the export in file handles
+}
+
+// ExportSettings are the limits and properties of an export advertised to clients
+// with FSINFO and PATHCONF
+type ExportSettings struct {
+	ReadMax         uint32   // maximum size of a READ request (rtmax)
+	WriteMax        uint32   // maximum size of a WRITE request (wtmax)
+	MaxFileSize     uint64   // maximum size of a file
+	TimeDelta       NFSTime3 // granularity of the timestamps
+	Properties      uint32   // combination of FSF3Link, FSF3Symlink, FSF3Homogeneous and FSF3CanSetTime
+	LinkMax         uint32   // maximum number of hard links to an object
+	NameMax         uint32   // maximum length of a file name
+	CaseInsensitive bool     // whether file names are compared case-insensitively
+	CasePreserving  bool     // whether the case of file names is preserved
+	ChownRestricted bool     // whether only the superuser may change the owner of an object
+}
+
+// DefaultExportSettings are the settings of exports which don't configure their own
+var DefaultExportSettings = ExportSettings{
+	ReadMax:         131072,
+	WriteMax:        131072,
+	MaxFileSize:     8796093022207,
+	TimeDelta:       NFSTime3{Seconds: 1, NSeconds: 0},
+	Properties:      FSF3Link | FSF3Symlink | FSF3Homogeneous | FSF3CanSetTime,
+	LinkMax:         32000,
+	NameMax:         255,
+	CaseInsensitive: false,
+	CasePreserving:  true,
+	ChownRestricted: true,
+}
+
+// settings returns the settings of an export
+func (export *Export) settings() *ExportSettings {
+	if export.Settings == nil {
+		return &DefaultExportSettings
+	}
+
+	return export.Settings
+}
+
+// checkName rejects file names exceeding the name_max of an export
+func (export *Export) checkName(name string) uint32 {
+	if uint32(len(name)) > export.settings().NameMax {
+		return NFS3ErrNameTooLong
+	}
+
+	return NFS3OK
+}
+
+// exportID derives the ID of an export from its path, so that file handles remain
+// valid across restarts and changes to the order of the exports
+func exportID(path string) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(path))
+
+	return hash.Sum32()
+}
+
+// generation returns the generation number of an object, or zero if the
+// file system doesn't reuse FileIDs
+func (export *Export) generation(fileID uint64) (uint32, error) {
+	generationFileSystem, ok := export.FileSystem.(GenerationFileSystem)
+
+	if !ok {
+		return 0, nil
+	}
+
+	return generationFileSystem.Generation(fileID)
+}
+
+// cookieVerifier returns the verifier of the cookies of directory dirID, or
+// bootVerifier if the cookies of the file system don't survive a restart
+func (export *Export) cookieVerifier(dirID uint64, bootVerifier [NFS3CookieVerfSize]byte) ([NFS3CookieVerfSize]byte, error) {
+	stableCookieFileSystem, ok := export.FileSystem.(StableCookieFileSystem)
+
+	if !ok {
+		return bootVerifier, nil
+	}
+
+	return stableCookieFileSystem.CookieVerifier(dirID)
+}
+
+// wccAttr returns the pre-operation attributes of an object from the attributes
+// retrieved before the operation
+func wccAttr(attributes FAttr3) PreOpAttr {
+	return PreOpAttr{
+		AttributesFollow: 1,
+		ObjectAttributes: WccAttr{
+			Size:  attributes.Size,
+			MTime: attributes.MTime,
+			CTime: attributes.CTime,
+		},
+	}
+}
+
+// wccData returns the weak cache consistency data of an object modified by an operation
+func (export *Export) wccData(before FAttr3, fileID uint64) WccData {
+	return WccData{
+		Before: wccAttr(before),
+		After:  export.postOpAttr(fileID),
+	}
+}
+
+// postOpAttr returns the attributes of an object, if they are available
+func (export *Export) postOpAttr(fileID uint64) PostOpAttr {
+	attributes, err := export.FileSystem.GetAttr(fileID)
+
+	if err != nil {
+		return PostOpAttr{AttributesFollow: 0}
+	}
+
+	return PostOpAttr{
+		AttributesFollow: 1,
+		ObjectAttributes: attributes,
+	}
+}
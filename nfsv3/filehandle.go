@@ -0,0 +1,82 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nfsv3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// File handles handed out by this server are laid out as follows, well within the
+// limit of NFS3FHSize bytes. The trailing HMAC prevents clients from forging handles
+// for objects they haven't looked up.
+//
+//	offset  size  content
+//	0       1     version of the layout (fileHandleVersion)
+//	1       3     reserved, zero
+//	4       4     export ID
+//	8       8     FSID of the object
+//	16      8     FileID of the object
+//	24      4     generation number of the object
+//	28      16    truncated HMAC-SHA256 of bytes 0 to 27
+const (
+	fileHandleVersion  byte = 1
+	fileHandleDataSize      = 28
+	fileHandleMACSize       = 16
+	fileHandleSize          = fileHandleDataSize + fileHandleMACSize
+)
+
+// fileHandleFields are the values encoded in a file handle
+type fileHandleFields struct {
+	exportID   uint32
+	fsid       uint64
+	fileID     uint64
+	generation uint32
+}
+
+// fileHandleMAC returns the authentication code for the data of a file handle
+func fileHandleMAC(key []byte, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+
+	return mac.Sum(nil)[:fileHandleMACSize]
+}
+
+// encodeFileHandle packs the fields of a file handle and signs them with key
+func encodeFileHandle(key []byte, fields fileHandleFields) NFSFH3 {
+	data := make([]byte, fileHandleSize)
+
+	data[0] = fileHandleVersion
+	binary.BigEndian.PutUint32(data[4:], fields.exportID)
+	binary.BigEndian.PutUint64(data[8:], fields.fsid)
+	binary.BigEndian.PutUint64(data[16:], fields.fileID)
+	binary.BigEndian.PutUint32(data[24:], fields.generation)
+	copy(data[fileHandleDataSize:], fileHandleMAC(key, data[:fileHandleDataSize]))
+
+	return NFSFH3{Data: data}
+}
+
+// decodeFileHandle verifies a file handle against key and unpacks its fields. Handles
+// which are not well-formed yield NFS3ErrBadHandle, while handles with an invalid
+// signature, e.g. signed with the key of a previous server instance, yield NFS3ErrStale.
+func decodeFileHandle(key []byte, data []byte) (fileHandleFields, uint32) {
+	var fields fileHandleFields
+
+	if len(data) != fileHandleSize || data[0] != fileHandleVersion || data[1] != 0 || data[2] != 0 || data[3] != 0 {
+		return fields, NFS3ErrBadHandle
+	}
+
+	if !hmac.Equal(data[fileHandleDataSize:], fileHandleMAC(key, data[:fileHandleDataSize])) {
+		return fields, NFS3ErrStale
+	}
+
+	fields.exportID = binary.BigEndian.Uint32(data[4:])
+	fields.fsid = binary.BigEndian.Uint64(data[8:])
+	fields.fileID = binary.BigEndian.Uint64(data[16:])
+	fields.generation = binary.BigEndian.Uint32(data[24:])
+
+	return fields, NFS3OK
+}
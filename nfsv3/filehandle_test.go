@@ -0,0 +1,166 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nfsv3_test
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"testing"
+
+	"github.com/dlorch/base-nfs/memfs"
+	"github.com/dlorch/base-nfs/nfsv3"
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// unixCall returns the context of a call with AUTH_UNIX credentials
+func unixCall(uid uint32, gid uint32, gids ...uint32) *rpcv2.CallContext {
+	return &rpcv2.CallContext{
+		Credentials: rpcv2.OpaqueAuth{Flavor: rpcv2.AuthenticationUNIX},
+		Unix:        &rpcv2.AuthUnixParms{MachineName: "client", UID: uid, GID: gid, GIDs: gids},
+	}
+}
+
+// rootCall is the context of calls made by the superuser
+var rootCall = unixCall(0, 0)
+
+func newService(t *testing.T) (*nfsv3.NFSService, *memfs.FileSystem, nfsv3.NFSFH3) {
+	fileSystem := memfs.New(1<<20, 1024)
+	nfsService := nfsv3.NewNFSv3Service(&nfsv3.Export{Path: "/export", FileSystem: fileSystem})
+
+	rootHandle, status := nfsService.MountHandle("/export")
+	if status != nfsv3.NFS3OK {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3OK, status)
+	}
+
+	return nfsService, fileSystem, rootHandle
+}
+
+func lookup(t *testing.T, nfsService *nfsv3.NFSService, dir nfsv3.NFSFH3, name string) *nfsv3.Lookup3Res {
+	args, err := xdr.Marshal(nfsv3.Lookup3Args{What: nfsv3.DirOpArgs3{Dir: dir, Name: name}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err := nfsService.Lookup3(rootCall, args)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return res.(*nfsv3.Lookup3Res)
+}
+
+func TestFileHandleRoundTrip(t *testing.T) {
+	nfsService, fileSystem, rootHandle := newService(t)
+
+	fileID, _ := fileSystem.Create(fileSystem.Root(), "file", nfsv3.SAttr3{})
+
+	res := lookup(t, nfsService, rootHandle, "file")
+	if res.Status != nfsv3.NFS3OK || res.ResOK.ObjAttributes.ObjectAttributes.FileID != fileID {
+		t.Fatalf("Expected FileID %d but got %+v", fileID, res)
+	}
+
+	if len(res.ResOK.Object.Data) > int(nfsv3.NFS3FHSize) {
+		t.Fatalf("Expected at most %d bytes but got %d", nfsv3.NFS3FHSize, len(res.ResOK.Object.Data))
+	}
+
+	_, status := nfsService.MountHandle("/unknown")
+	if status != nfsv3.NFS3ErrNoEnt {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrNoEnt, status)
+	}
+}
+
+func TestFileHandleInvalid(t *testing.T) {
+	nfsService, fileSystem, rootHandle := newService(t)
+
+	tampered := append([]byte(nil), rootHandle.Data...)
+	tampered[len(tampered)-1] ^= 1
+
+	res := lookup(t, nfsService, nfsv3.NFSFH3{Data: tampered}, "file")
+	if res.Status != nfsv3.NFS3ErrStale {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrStale, res.Status)
+	}
+
+	res = lookup(t, nfsService, nfsv3.NFSFH3{Data: rootHandle.Data[:8]}, "file")
+	if res.Status != nfsv3.NFS3ErrBadHandle {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrBadHandle, res.Status)
+	}
+
+	// handles signed with another key, e.g. by a previous server instance, are stale
+	nfsService.SetFileHandleKey([]byte("another key"))
+
+	res = lookup(t, nfsService, rootHandle, "file")
+	if res.Status != nfsv3.NFS3ErrStale {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrStale, res.Status)
+	}
+
+	// handles of removed objects are stale
+	nfsService, fileSystem, rootHandle = newService(t)
+
+	dirID, _ := fileSystem.MkDir(fileSystem.Root(), "dir", nfsv3.SAttr3{})
+	dir := lookup(t, nfsService, rootHandle, "dir")
+
+	_ = fileSystem.RmDir(fileSystem.Root(), "dir")
+
+	res = lookup(t, nfsService, dir.ResOK.Object, "file")
+	if res.Status != nfsv3.NFS3ErrStale {
+		t.Fatalf("Expected %v for removed directory %d but got %v", nfsv3.NFS3ErrStale, dirID, res.Status)
+	}
+}
+
+// collidingPaths returns two export paths with the same FNV-1a hash
+func collidingPaths() (string, string) {
+	paths := make(map[uint32]string)
+
+	for i := 0; ; i++ {
+		path := fmt.Sprintf("/export%d", i)
+
+		hash := fnv.New32a()
+		hash.Write([]byte(path))
+
+		if other, found := paths[hash.Sum32()]; found {
+			return other, path
+		}
+
+		paths[hash.Sum32()] = path
+	}
+}
+
+func TestExportIDCollision(t *testing.T) {
+	first, second := collidingPaths()
+
+	fileSystems := map[string]nfsv3.FileSystem{
+		first:  memfs.New(1<<20, 1024),
+		second: memfs.New(1<<20, 1024),
+	}
+
+	var handles [][]byte
+
+	// the order of the exports doesn't change their file handles
+	for _, paths := range [][]string{{first, second}, {second, first}} {
+		var exports []*nfsv3.Export
+
+		for _, path := range paths {
+			exports = append(exports, &nfsv3.Export{Path: path, FileSystem: fileSystems[path]})
+		}
+
+		nfsService := nfsv3.NewNFSv3Service(exports...)
+		nfsService.SetFileHandleKey([]byte("key"))
+
+		firstHandle, _ := nfsService.MountHandle(first)
+		secondHandle, _ := nfsService.MountHandle(second)
+
+		if bytes.Equal(firstHandle.Data, secondHandle.Data) {
+			t.Fatalf("Expected distinct handles for %v and %v", first, second)
+		}
+
+		handles = append(handles, firstHandle.Data, secondHandle.Data)
+	}
+
+	if !bytes.Equal(handles[0], handles[2]) || !bytes.Equal(handles[1], handles[3]) {
+		t.Fatalf("Expected the same handles in both orders but got %x", handles)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nfsv3
+
+// FileSystem is the storage backend served by the NFS service. File system objects
+// are identified by their FileID, which must be unique within the file system and is
+// reported back to clients as FAttr3.FileID. Errors returned by a FileSystem are
+// translated into nfsstat3 values (see StatusError).
+type FileSystem interface {
+	// Root returns the FileID of the root directory
+	Root() uint64
+
+	// Lookup returns the FileID of the object called name in directory dirID.
+	// The names "." and ".." must be supported.
+	Lookup(dirID uint64, name string) (uint64, error)
+
+	// GetAttr returns the attributes of an object
+	GetAttr(fileID uint64) (FAttr3, error)
+
+	// SetAttr changes the attributes of an object which are marked to be set
+	SetAttr(fileID uint64, attributes SAttr3) error
+
+	// Read reads up to count bytes at offset. The returned flag is true
+	// if the end of the file was reached.
+	Read(fileID uint64, offset uint64, count uint32) ([]byte, bool, error)
+
+	// Write writes data at offset and returns the number of bytes written. The data
+	// need not be on stable storage until Commit is called.
+	Write(fileID uint64, offset uint64, data []byte) (uint32, error)
+
+	// Commit flushes data previously written in the range of count bytes at offset
+	// to stable storage. A count of zero means up to the end of the file.
+	Commit(fileID uint64, offset uint64, count uint32) error
+
+	// Create creates a regular file called name in directory dirID
+	Create(dirID uint64, name string, attributes SAttr3) (uint64, error)
+
+	// MkDir creates a directory called name in directory dirID
+	MkDir(dirID uint64, name string, attributes SAttr3) (uint64, error)
+
+	// Remove removes the non-directory object called name from directory dirID
+	Remove(dirID uint64, name string) error
+
+	// RmDir removes the empty directory called name from directory dirID
+	RmDir(dirID uint64, name string) error
+
+	// ReadDir returns all entries of directory dirID, including "." and "..", sorted
+	// by cookie. The returned slice must not be modified by the caller.
+	ReadDir(dirID uint64) ([]DirEntry, error)
+
+	// Rename moves the object fromName in directory fromDirID to toName in toDirID
+	Rename(fromDirID uint64, fromName string, toDirID uint64, toName string) error
+
+	// Link creates a hard link called name in directory dirID to object fileID
+	Link(fileID uint64, dirID uint64, name string) error
+
+	// Symlink creates a symbolic link called name in directory dirID pointing to target
+	Symlink(dirID uint64, name string, target string, attributes SAttr3) (uint64, error)
+
+	// Readlink returns the target of a symbolic link
+	Readlink(fileID uint64) (string, error)
+
+	// MkNod creates a special file of type ftype, which is one of NF3Chr, NF3Blk,
+	// NF3Sock or NF3FIFO. File systems which can't store the type return
+	// StatusError(NFS3ErrNotSupp).
+	MkNod(dirID uint64, name string, ftype uint32, rdev SpecData3, attributes SAttr3) (uint64, error)
+
+	// StatFS returns the resource usage of the file system containing fileID
+	StatFS(fileID uint64) (FileSystemStat, error)
+}
+
+// GenerationFileSystem is implemented by file systems which reuse the FileIDs of deleted
+// objects. The generation number distinguishes successive objects with the same FileID,
+// so that file handles referring to a deleted object are detected as stale.
+type GenerationFileSystem interface {
+	FileSystem
+
+	// Generation returns the generation number of an object
+	Generation(fileID uint64) (uint32, error)
+}
+
+// StableCookieFileSystem is implemented by file systems whose directory cookies remain
+// valid across restarts of the server, e.g. because they are derived from the names of
+// the entries, so that clients can keep reading directories after a restart. The
+// cookies of other file systems are invalidated by every restart.
+type StableCookieFileSystem interface {
+	FileSystem
+
+	// CookieVerifier returns the verifier of the cookies of directory dirID, which must
+	// change whenever cookies handed out before become invalid
+	CookieVerifier(dirID uint64) ([NFS3CookieVerfSize]byte, error)
+}
+
+// DirEntry is a single entry of a directory listing. Clients resume reading a
+// directory after the entry with a given cookie, so cookies must be unique within
+// the directory, greater than zero, and must not change while the entry exists.
+// Entries added to the directory should preferably get greater cookies than the
+// existing ones, so that clients reading the directory at the same time see them.
+type DirEntry struct {
+	FileID uint64
+	Name   string
+	Cookie uint64
+}
+
+// FileSystemStat describes the resources of a file system
+type FileSystemStat struct {
+	TotalBytes uint64 // total size of the file system in bytes
+	FreeBytes  uint64 // free space in bytes
+	AvailBytes uint64 // free space available to unprivileged users in bytes
+	TotalFiles uint64 // total number of file slots
+	FreeFiles  uint64 // number of free file slots
+	AvailFiles uint64 // number of free file slots available to unprivileged users
+}
@@ -2,89 +2,85 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+// Procedure 19: FSINFO - Get static file system information
+// https://tools.ietf.org/html/rfc1813#page-86
+
 package nfsv3
 
 import (
-	"bytes"
-	"encoding/binary"
-	"fmt"
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
 )
 
-// FSInfo3Args (struct FSINFOargs)
+// transferMultiple is the suggested multiple of READ, WRITE and READDIR sizes
+const transferMultiple uint32 = 4096
+
+// FSInfo3Args (struct FSINFO3args)
 type FSInfo3Args struct {
-	FileHandle []byte
+	FSRoot NFSFH3
 }
 
-// FSInfo3ResultOK (struct FSINFO3resok)
-type FSInfo3ResultOK struct {
-	FSInfo3Result
-	Objattributes        uint32 // TODO
-	Rtmax                uint32
-	Rtpref               uint32
-	Rtmult               uint32
-	Wtmax                uint32
-	Wtpref               uint32
-	Wtmult               uint32
-	Dtpref               uint32
-	Maxfilesize          uint64
-	Timedeltaseconds     uint32
-	Timedeltananoseconds uint32
-	Properties           uint32
+// FSInfo3ResOK (struct FSINFO3resok)
+type FSInfo3ResOK struct {
+	ObjAttributes PostOpAttr
+	Rtmax         uint32
+	Rtpref        uint32
+	Rtmult        uint32
+	Wtmax         uint32
+	Wtpref        uint32
+	Wtmult        uint32
+	Dtpref        uint32
+	MaxFileSize   uint64
+	TimeDelta     NFSTime3
+	Properties    uint32
 }
 
-// FSInfo3ResultFail (struct FSINFO3resfail)
-type FSInfo3ResultFail struct {
-	FSInfo3Result
-	// TODO post_op_attr obj_attributes
+// FSInfo3ResFail (struct FSINFO3resfail)
+type FSInfo3ResFail struct {
+	ObjAttributes PostOpAttr
 }
 
-// FSInfo3Result (union FSINFO3res)
-type FSInfo3Result struct {
-	Status uint32
+// FSInfo3Res (union FSINFO3res)
+type FSInfo3Res struct {
+	Status  uint32         `xdr:"switch"`
+	ResOK   FSInfo3ResOK   `xdr:"case=0"`
+	ResFail FSInfo3ResFail `xdr:"default"`
 }
 
-func nfsProcedure3FSInfo(procedureArguments []byte) (interface{}, error) {
-	// parse request
-	requestBuffer := bytes.NewBuffer(procedureArguments)
+// FSInfo3 (NFSPROC3_FSINFO) returns the transfer sizes and properties of the
+// export a file handle belongs to, as configured in its ExportSettings
+func (nfsService *NFSService) FSInfo3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var fsInfoArgs FSInfo3Args
 
-	var fileHandleLength uint32
-
-	err := binary.Read(requestBuffer, binary.BigEndian, &fileHandleLength)
+	_, err := xdr.Unmarshal(arg, &fsInfoArgs)
 
 	if err != nil {
-		fmt.Println("Error: ", err.Error())
-		// TODO
-	}
-
-	fsInfoArgs := FSInfo3Args{
-		FileHandle: make([]byte, fileHandleLength), // TODO unsafe?
+		return nil, err
 	}
 
-	err = binary.Read(requestBuffer, binary.BigEndian, &fsInfoArgs.FileHandle)
+	root, status := nfsService.resolveHandle(fsInfoArgs.FSRoot.Data)
 
-	if err != nil {
-		fmt.Println("Error: ", err.Error())
-		// TODO
+	if status != NFS3OK {
+		return &FSInfo3Res{Status: status}, nil
 	}
 
-	// prepare result
-	fsInfoResult := &FSInfo3ResultOK{
-		FSInfo3Result: FSInfo3Result{
-			Status: NFS3OK,
+	settings := root.export.settings()
+
+	res := &FSInfo3Res{
+		Status: NFS3OK,
+		ResOK: FSInfo3ResOK{
+			ObjAttributes: PostOpAttr{AttributesFollow: 1, ObjectAttributes: root.attributes},
+			Rtmax:         settings.ReadMax,
+			Rtpref:        settings.ReadMax,
+			Rtmult:        transferMultiple,
+			Wtmax:         settings.WriteMax,
+			Wtpref:        settings.WriteMax,
+			Wtmult:        transferMultiple,
+			Dtpref:        transferMultiple,
+			MaxFileSize:   settings.MaxFileSize,
+			TimeDelta:     settings.TimeDelta,
+			Properties:    settings.Properties,
 		},
-		Objattributes:        0,
-		Rtmax:                131072,
-		Rtpref:               131072,
-		Rtmult:               4096,
-		Wtmax:                131072,
-		Wtpref:               131072,
-		Wtmult:               4096,
-		Dtpref:               4096,
-		Maxfilesize:          8796093022207,
-		Timedeltaseconds:     1,
-		Timedeltananoseconds: 0,
-		Properties:           0x0000001b,
 	}
-
-	return fsInfoResult, nil
+	return res, nil
 }
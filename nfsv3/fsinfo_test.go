@@ -0,0 +1,111 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nfsv3_test
+
+import (
+	"testing"
+
+	"github.com/dlorch/base-nfs/memfs"
+	"github.com/dlorch/base-nfs/nfsv3"
+)
+
+func TestFSStat(t *testing.T) {
+	nfsService, fileSystem, rootHandle := newService(t)
+
+	fileID, _ := fileSystem.Create(fileSystem.Root(), "file", nfsv3.SAttr3{})
+	fileSystem.Write(fileID, 0, make([]byte, 1000))
+
+	stat, err := fileSystem.StatFS(fileSystem.Root())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res := call(t, nfsService.FSStat3, nfsv3.FSStat3Args{FSRoot: rootHandle}).(*nfsv3.FSStat3Res)
+	if res.Status != nfsv3.NFS3OK {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3OK, res.Status)
+	}
+
+	if res.ResOK.TBytes != stat.TotalBytes || res.ResOK.FBytes != stat.FreeBytes || res.ResOK.ABytes != stat.AvailBytes {
+		t.Fatalf("Expected %+v but got %+v", stat, res.ResOK)
+	}
+
+	if res.ResOK.TFiles != stat.TotalFiles || res.ResOK.FFiles != stat.FreeFiles || res.ResOK.AFiles != stat.AvailFiles {
+		t.Fatalf("Expected %+v but got %+v", stat, res.ResOK)
+	}
+
+	if res.ResOK.ObjAttributes.AttributesFollow != 1 || res.ResOK.ObjAttributes.ObjectAttributes.FileID != fileSystem.Root() {
+		t.Fatalf("Unexpected attributes %+v", res.ResOK.ObjAttributes)
+	}
+}
+
+func TestFSInfoPathConfDefaults(t *testing.T) {
+	nfsService, fileSystem, rootHandle := newService(t)
+
+	fsInfoRes := call(t, nfsService.FSInfo3, nfsv3.FSInfo3Args{FSRoot: rootHandle}).(*nfsv3.FSInfo3Res)
+	if fsInfoRes.Status != nfsv3.NFS3OK || fsInfoRes.ResOK.Rtmax != nfsv3.DefaultExportSettings.ReadMax {
+		t.Fatalf("Expected rtmax %d but got %+v", nfsv3.DefaultExportSettings.ReadMax, fsInfoRes)
+	}
+
+	if fsInfoRes.ResOK.ObjAttributes.ObjectAttributes.FileID != fileSystem.Root() {
+		t.Fatalf("Unexpected attributes %+v", fsInfoRes.ResOK.ObjAttributes)
+	}
+
+	pathConfRes := call(t, nfsService.PathConf3, nfsv3.PathConf3Args{Object: rootHandle}).(*nfsv3.PathConf3Res)
+	if pathConfRes.Status != nfsv3.NFS3OK || pathConfRes.ResOK.NameMax != nfsv3.DefaultExportSettings.NameMax {
+		t.Fatalf("Expected name_max %d but got %+v", nfsv3.DefaultExportSettings.NameMax, pathConfRes)
+	}
+
+	if pathConfRes.ResOK.CaseInsensitive != 0 || pathConfRes.ResOK.CasePreserving != 1 || pathConfRes.ResOK.ChownRestricted != 1 {
+		t.Fatalf("Unexpected result %+v", pathConfRes.ResOK)
+	}
+}
+
+func TestExportSettings(t *testing.T) {
+	settings := nfsv3.DefaultExportSettings
+	settings.ReadMax = 4096
+	settings.WriteMax = 8192
+	settings.MaxFileSize = 10000
+	settings.NameMax = 8
+	settings.CaseInsensitive = true
+	settings.Properties = nfsv3.FSF3Homogeneous
+
+	fileSystem := memfs.New(1<<20, 1024)
+	nfsService := nfsv3.NewNFSv3Service(&nfsv3.Export{Path: "/export", FileSystem: fileSystem, Settings: &settings})
+	rootHandle, _ := nfsService.MountHandle("/export")
+
+	fsInfoRes := call(t, nfsService.FSInfo3, nfsv3.FSInfo3Args{FSRoot: rootHandle}).(*nfsv3.FSInfo3Res)
+	if fsInfoRes.ResOK.Rtmax != 4096 || fsInfoRes.ResOK.Wtmax != 8192 || fsInfoRes.ResOK.MaxFileSize != 10000 || fsInfoRes.ResOK.Properties != nfsv3.FSF3Homogeneous {
+		t.Fatalf("Unexpected result %+v", fsInfoRes.ResOK)
+	}
+
+	pathConfRes := call(t, nfsService.PathConf3, nfsv3.PathConf3Args{Object: rootHandle}).(*nfsv3.PathConf3Res)
+	if pathConfRes.ResOK.NameMax != 8 || pathConfRes.ResOK.CaseInsensitive != 1 {
+		t.Fatalf("Unexpected result %+v", pathConfRes.ResOK)
+	}
+
+	fileID, _ := fileSystem.Create(fileSystem.Root(), "file", nfsv3.SAttr3{})
+	fileSystem.Write(fileID, 0, make([]byte, 10000))
+	file := lookup(t, nfsService, rootHandle, "file").ResOK.Object
+
+	readRes := read(t, nfsService, file, 0, 10000)
+	if readRes.Status != nfsv3.NFS3OK || readRes.ResOK.Count != 4096 {
+		t.Fatalf("Expected %d bytes but got %+v", 4096, readRes.ResOK.Count)
+	}
+
+	writeRes := write(t, nfsService, nfsv3.Write3Args{File: file, Offset: 9999, Count: 2, Stable: nfsv3.FileSync, Data: []byte("xx")})
+	if writeRes.Status != nfsv3.NFS3ErrFBig {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrFBig, writeRes.Status)
+	}
+
+	mkDirRes := mkDir(t, nfsService, rootHandle, "longname")
+	if mkDirRes.Status != nfsv3.NFS3OK {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3OK, mkDirRes.Status)
+	}
+
+	mkDirRes = mkDir(t, nfsService, rootHandle, "too-long-name")
+	if mkDirRes.Status != nfsv3.NFS3ErrNameTooLong {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrNameTooLong, mkDirRes.Status)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Procedure 18: FSSTAT - Get dynamic file system information
+// https://tools.ietf.org/html/rfc1813#page-84
+
+package nfsv3
+
+import (
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// FSStat3Args (struct FSSTAT3args)
+type FSStat3Args struct {
+	FSRoot NFSFH3
+}
+
+// FSStat3ResOK (struct FSSTAT3resok)
+type FSStat3ResOK struct {
+	ObjAttributes PostOpAttr
+	TBytes        uint64
+	FBytes        uint64
+	ABytes        uint64
+	TFiles        uint64
+	FFiles        uint64
+	AFiles        uint64
+	InvarSec      uint32
+}
+
+// FSStat3ResFail (struct FSSTAT3resfail)
+type FSStat3ResFail struct {
+	ObjAttributes PostOpAttr
+}
+
+// FSStat3Res (union FSSTAT3res)
+type FSStat3Res struct {
+	Status  uint32         `xdr:"switch"`
+	ResOK   FSStat3ResOK   `xdr:"case=0"`
+	ResFail FSStat3ResFail `xdr:"default"`
+}
+
+// FSStat3 (NFSPROC3_FSSTAT) reports the size and free space of the file system
+// a file handle belongs to. The usage may change at any time, so invarsec is zero.
+func (nfsService *NFSService) FSStat3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var fsStatArgs FSStat3Args
+
+	_, err := xdr.Unmarshal(arg, &fsStatArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	root, status := nfsService.resolveHandle(fsStatArgs.FSRoot.Data)
+
+	if status != NFS3OK {
+		return &FSStat3Res{Status: status}, nil
+	}
+
+	stat, err := root.export.FileSystem.StatFS(root.fileID)
+
+	if err != nil {
+		res := &FSStat3Res{
+			Status: errorStatus(err),
+			ResFail: FSStat3ResFail{
+				ObjAttributes: root.export.postOpAttr(root.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	res := &FSStat3Res{
+		Status: NFS3OK,
+		ResOK: FSStat3ResOK{
+			ObjAttributes: root.export.postOpAttr(root.fileID),
+			TBytes:        stat.TotalBytes,
+			FBytes:        stat.FreeBytes,
+			ABytes:        stat.AvailBytes,
+			TFiles:        stat.TotalFiles,
+			FFiles:        stat.FreeFiles,
+			AFiles:        stat.AvailFiles,
+			InvarSec:      0,
+		},
+	}
+	return res, nil
+}
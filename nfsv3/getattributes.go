@@ -4,7 +4,12 @@
 
 package nfsv3
 
-// GetAttr3Args (struct FSINFOargs)
+import (
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// GetAttr3Args (struct GETATTR3args)
 type GetAttr3Args struct {
 	FileHandle []byte
 }
@@ -20,42 +25,28 @@ type GetAttr3Result struct {
 	Status uint32
 }
 
-func nfsProcedure3GetAttributes(procedureArguments []byte) (interface{}, error) {
+func (nfsService *NFSService) nfsProcedure3GetAttributes(call *rpcv2.CallContext, procedureArguments []byte) (interface{}, error) {
 	// parse request
-	// TODO
+	var getAttrArgs GetAttr3Args
+
+	_, err := xdr.Unmarshal(procedureArguments, &getAttrArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	obj, status := nfsService.resolveHandle(getAttrArgs.FileHandle)
+
+	if status != NFS3OK {
+		return &GetAttr3Result{Status: status}, nil
+	}
 
 	// prepare result
 	getAttrResult := &GetAttr3ResultOK{
 		GetAttr3Result: GetAttr3Result{
 			Status: NFS3OK,
 		},
-		ObjectAttributes: FAttr3{
-			Type:  2,
-			Mode:  040777,
-			Nlink: 4,
-			UID:   0,
-			GID:   0,
-			Size:  4096,
-			Used:  8192,
-			RDev: SpecData3{
-				SpecData1: 0,
-				SpecData2: 0,
-			},
-			FSID:   0x388e4346cfc706a8,
-			FileID: 16,
-			ATime: NFSTime3{
-				Seconds:  1563137262,
-				NSeconds: 460002975,
-			},
-			MTime: NFSTime3{
-				Seconds:  1537128120,
-				NSeconds: 839607220,
-			},
-			CTime: NFSTime3{
-				Seconds:  1537128120,
-				NSeconds: 839607220,
-			},
-		},
+		ObjectAttributes: obj.attributes,
 	}
 
 	return getAttrResult, nil
@@ -0,0 +1,107 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Procedure 15: LINK - Create Link to an object
+// https://tools.ietf.org/html/rfc1813#page-74
+
+package nfsv3
+
+import (
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// Link3Args (struct LINK3args)
+type Link3Args struct {
+	File NFSFH3
+	Link DirOpArgs3
+}
+
+// Link3ResOK (struct LINK3resok)
+type Link3ResOK struct {
+	FileAttributes PostOpAttr
+	LinkDirWcc     WccData
+}
+
+// Link3ResFail (struct LINK3resfail)
+type Link3ResFail struct {
+	FileAttributes PostOpAttr
+	LinkDirWcc     WccData
+}
+
+// Link3Res (union LINK3res)
+type Link3Res struct {
+	Status  uint32       `xdr:"switch"`
+	ResOK   Link3ResOK   `xdr:"case=0"`
+	ResFail Link3ResFail `xdr:"default"`
+}
+
+// Link3 (NFSPROC3_LINK) creates a hard link to an object. Objects with as many
+// links as the advertised linkmax are refused with NFS3ErrMLink.
+func (nfsService *NFSService) Link3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var linkArgs Link3Args
+
+	_, err := xdr.Unmarshal(arg, &linkArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	file, status := nfsService.resolveHandle(linkArgs.File.Data)
+
+	if status != NFS3OK {
+		return &Link3Res{Status: status}, nil
+	}
+
+	dir, status := nfsService.resolveHandle(linkArgs.Link.Dir.Data)
+
+	if status != NFS3OK {
+		res := &Link3Res{
+			Status: status,
+			ResFail: Link3ResFail{
+				FileAttributes: file.export.postOpAttr(file.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	status = dir.export.checkName(linkArgs.Link.Name)
+
+	if status == NFS3OK && (file.export != dir.export || file.attributes.FSID != dir.attributes.FSID) {
+		status = NFS3ErrXDev
+	}
+
+	if status == NFS3OK && file.attributes.Nlink >= file.export.settings().LinkMax {
+		status = NFS3ErrMLink
+	}
+
+	if status == NFS3OK {
+		status = callerCredentials(call).checkDirectory(dir.attributes, Access3Modify|Access3Lookup)
+	}
+
+	if status == NFS3OK {
+		err = dir.export.FileSystem.Link(file.fileID, dir.fileID, linkArgs.Link.Name)
+		status = errorStatus(err)
+	}
+
+	if status != NFS3OK {
+		res := &Link3Res{
+			Status: status,
+			ResFail: Link3ResFail{
+				FileAttributes: file.export.postOpAttr(file.fileID),
+				LinkDirWcc:     dir.export.wccData(dir.attributes, dir.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	res := &Link3Res{
+		Status: NFS3OK,
+		ResOK: Link3ResOK{
+			FileAttributes: file.export.postOpAttr(file.fileID),
+			LinkDirWcc:     dir.export.wccData(dir.attributes, dir.fileID),
+		},
+	}
+	return res, nil
+}
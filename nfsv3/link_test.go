@@ -0,0 +1,124 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nfsv3_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dlorch/base-nfs/nfsv3"
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// call marshals args and invokes a procedure with them as the superuser
+func call(t *testing.T, procedure func(*rpcv2.CallContext, []byte) (interface{}, error), args interface{}) interface{} {
+	return callAs(t, rootCall, procedure, args)
+}
+
+// callAs marshals args and invokes a procedure with them in the given call context
+func callAs(t *testing.T, callContext *rpcv2.CallContext, procedure func(*rpcv2.CallContext, []byte) (interface{}, error), args interface{}) interface{} {
+	data, err := xdr.Marshal(args)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err := procedure(callContext, data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return res
+}
+
+func TestSymlink(t *testing.T) {
+	nfsService, _, rootHandle := newService(t)
+
+	args := nfsv3.Symlink3Args{
+		Where:   nfsv3.DirOpArgs3{Dir: rootHandle, Name: "link"},
+		Symlink: nfsv3.SymlinkData3{SymlinkData: "../target"},
+	}
+
+	res := call(t, nfsService.Symlink3, args).(*nfsv3.Symlink3Res)
+	if res.Status != nfsv3.NFS3OK || res.ResOK.ObjAttributes.ObjectAttributes.Type != nfsv3.NF3Lnk {
+		t.Fatalf("Unexpected result %+v", res)
+	}
+
+	readlinkRes := call(t, nfsService.Readlink3, nfsv3.Readlink3Args{Symlink: res.ResOK.Obj.Handle}).(*nfsv3.Readlink3Res)
+	if readlinkRes.Status != nfsv3.NFS3OK || readlinkRes.ResOK.Data != "../target" {
+		t.Fatalf("Expected %q but got %+v", "../target", readlinkRes)
+	}
+
+	readlinkRes = call(t, nfsService.Readlink3, nfsv3.Readlink3Args{Symlink: rootHandle}).(*nfsv3.Readlink3Res)
+	if readlinkRes.Status != nfsv3.NFS3ErrInval {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrInval, readlinkRes.Status)
+	}
+}
+
+func TestLink(t *testing.T) {
+	nfsService, fileSystem, rootHandle := newService(t)
+
+	fileID, _ := fileSystem.Create(fileSystem.Root(), "file", nfsv3.SAttr3{})
+	file := lookup(t, nfsService, rootHandle, "file").ResOK.Object
+
+	args := nfsv3.Link3Args{File: file, Link: nfsv3.DirOpArgs3{Dir: rootHandle, Name: "link"}}
+
+	res := call(t, nfsService.Link3, args).(*nfsv3.Link3Res)
+	if res.Status != nfsv3.NFS3OK || res.ResOK.FileAttributes.ObjectAttributes.Nlink != 2 {
+		t.Fatalf("Unexpected result %+v", res)
+	}
+
+	res = call(t, nfsService.Link3, args).(*nfsv3.Link3Res)
+	if res.Status != nfsv3.NFS3ErrExist {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrExist, res.Status)
+	}
+
+	for i := 2; i < 32000; i++ {
+		err := fileSystem.Link(fileID, fileSystem.Root(), fmt.Sprintf("link%d", i))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	args.Link.Name = "one too many"
+
+	res = call(t, nfsService.Link3, args).(*nfsv3.Link3Res)
+	if res.Status != nfsv3.NFS3ErrMLink || res.ResFail.FileAttributes.ObjectAttributes.Nlink != 32000 {
+		t.Fatalf("Expected %v but got %+v", nfsv3.NFS3ErrMLink, res)
+	}
+}
+
+func TestMkNod(t *testing.T) {
+	nfsService, _, rootHandle := newService(t)
+
+	args := nfsv3.MkNod3Args{
+		Where: nfsv3.DirOpArgs3{Dir: rootHandle, Name: "tty"},
+		What: nfsv3.MkNodData3{
+			Type:   nfsv3.NF3Chr,
+			Device: nfsv3.DeviceData3{Spec: nfsv3.SpecData3{SpecData1: 4, SpecData2: 1}},
+		},
+	}
+
+	res := call(t, nfsService.MkNod3, args).(*nfsv3.MkNod3Res)
+	if res.Status != nfsv3.NFS3OK || res.ResOK.ObjAttributes.ObjectAttributes.RDev != args.What.Device.Spec {
+		t.Fatalf("Unexpected result %+v", res)
+	}
+
+	args.Where.Name = "fifo"
+	args.What = nfsv3.MkNodData3{Type: nfsv3.NF3FIFO}
+
+	res = call(t, nfsService.MkNod3, args).(*nfsv3.MkNod3Res)
+	if res.Status != nfsv3.NFS3OK || res.ResOK.ObjAttributes.ObjectAttributes.Type != nfsv3.NF3FIFO {
+		t.Fatalf("Unexpected result %+v", res)
+	}
+
+	args.Where.Name = "file"
+	args.What = nfsv3.MkNodData3{Type: nfsv3.NF3Reg}
+
+	res = call(t, nfsService.MkNod3, args).(*nfsv3.MkNod3Res)
+	if res.Status != nfsv3.NFS3ErrBadType {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrBadType, res.Status)
+	}
+}
@@ -7,24 +7,29 @@
 
 package nfsv3
 
-// Lookup3Args ...
+import (
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// Lookup3Args (struct LOOKUP3args)
 type Lookup3Args struct {
-	// what diropargs3
+	What DirOpArgs3
 }
 
-// Lookup3ResOK ...
+// Lookup3ResOK (struct LOOKUP3resok)
 type Lookup3ResOK struct {
 	Object        NFSFH3
 	ObjAttributes PostOpAttr
 	DirAttributes PostOpAttr
 }
 
-// Lookup3ResFail ...
+// Lookup3ResFail (struct LOOKUP3resfail)
 type Lookup3ResFail struct {
 	DirAttributes PostOpAttr
 }
 
-// Lookup3Res ...
+// Lookup3Res (union LOOKUP3res)
 type Lookup3Res struct {
 	Status  uint32         `xdr:"switch"`
 	ResOK   Lookup3ResOK   `xdr:"case=0"`
@@ -33,73 +38,53 @@ type Lookup3Res struct {
 
 // Lookup3 (NFSPROC3_LOOKUP) searches a directory for a specific name
 // and returns the file handle for the corresponding file system object.
-func Lookup3(arg []byte) (interface{}, error) {
-	res := &Lookup3Res{
-		Status: NFS3OK,
-		ResOK: Lookup3ResOK{
-			Object: NFSFH3{
-				Data: []byte{1, 2, 3},
-			},
-			ObjAttributes: PostOpAttr{
-				AttributesFollow: 1,
-				ObjectAttributes: FAttr3{
-					Type:  2,
-					Mode:  040777,
-					Nlink: 4,
-					UID:   0,
-					GID:   0,
-					Size:  4096,
-					Used:  8192,
-					RDev: SpecData3{
-						SpecData1: 0,
-						SpecData2: 0,
-					},
-					FSID:   0x388e4346cfc706a8,
-					FileID: 16,
-					ATime: NFSTime3{
-						Seconds:  1563137262,
-						NSeconds: 460002975,
-					},
-					MTime: NFSTime3{
-						Seconds:  1537128120,
-						NSeconds: 839607220,
-					},
-					CTime: NFSTime3{
-						Seconds:  1537128120,
-						NSeconds: 839607220,
-					},
-				},
-			},
-			DirAttributes: PostOpAttr{
-				AttributesFollow: 1,
-				ObjectAttributes: FAttr3{
-					Type:  2,
-					Mode:  040777,
-					Nlink: 4,
-					UID:   0,
-					GID:   0,
-					Size:  4096,
-					Used:  8192,
-					RDev: SpecData3{
-						SpecData1: 0,
-						SpecData2: 0,
-					},
-					FSID:   0x388e4346cfc706a8,
-					FileID: 16,
-					ATime: NFSTime3{
-						Seconds:  1563137262,
-						NSeconds: 460002975,
-					},
-					MTime: NFSTime3{
-						Seconds:  1537128120,
-						NSeconds: 839607220,
-					},
-					CTime: NFSTime3{
-						Seconds:  1537128120,
-						NSeconds: 839607220,
-					},
+func (nfsService *NFSService) Lookup3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var lookupArgs Lookup3Args
+
+	_, err := xdr.Unmarshal(arg, &lookupArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dir, status := nfsService.resolveHandle(lookupArgs.What.Dir.Data)
+
+	if status != NFS3OK {
+		return &Lookup3Res{Status: status}, nil
+	}
+
+	var fileID uint64
+
+	status = callerCredentials(call).checkDirectory(dir.attributes, Access3Lookup)
+
+	if status == NFS3OK {
+		fileID, err = dir.export.FileSystem.Lookup(dir.fileID, lookupArgs.What.Name)
+		status = errorStatus(err)
+	}
+
+	if status == NFS3OK {
+		var objectHandle NFSFH3
+		var attributes FAttr3
+
+		objectHandle, attributes, status = nfsService.fileHandle(dir.export, fileID)
+
+		if status == NFS3OK {
+			res := &Lookup3Res{
+				Status: NFS3OK,
+				ResOK: Lookup3ResOK{
+					Object:        objectHandle,
+					ObjAttributes: PostOpAttr{AttributesFollow: 1, ObjectAttributes: attributes},
+					DirAttributes: dir.export.postOpAttr(dir.fileID),
 				},
-			},
+			}
+			return res, nil
+		}
+	}
+
+	res := &Lookup3Res{
+		Status: status,
+		ResFail: Lookup3ResFail{
+			DirAttributes: dir.export.postOpAttr(dir.fileID),
 		},
 	}
 	return res, nil
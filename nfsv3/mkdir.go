@@ -0,0 +1,95 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Procedure 9: MKDIR - Create a directory
+// https://tools.ietf.org/html/rfc1813#page-58
+
+package nfsv3
+
+import (
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// MkDir3Args (struct MKDIR3args)
+type MkDir3Args struct {
+	Where      DirOpArgs3
+	Attributes SAttr3
+}
+
+// MkDir3ResOK (struct MKDIR3resok)
+type MkDir3ResOK struct {
+	Obj           PostOpFH3
+	ObjAttributes PostOpAttr
+	DirWcc        WccData
+}
+
+// MkDir3ResFail (struct MKDIR3resfail)
+type MkDir3ResFail struct {
+	DirWcc WccData
+}
+
+// MkDir3Res (union MKDIR3res)
+type MkDir3Res struct {
+	Status  uint32        `xdr:"switch"`
+	ResOK   MkDir3ResOK   `xdr:"case=0"`
+	ResFail MkDir3ResFail `xdr:"default"`
+}
+
+// MkDir3 (NFSPROC3_MKDIR) creates a new subdirectory
+func (nfsService *NFSService) MkDir3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var mkDirArgs MkDir3Args
+
+	_, err := xdr.Unmarshal(arg, &mkDirArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dir, status := nfsService.resolveHandle(mkDirArgs.Where.Dir.Data)
+
+	if status != NFS3OK {
+		return &MkDir3Res{Status: status}, nil
+	}
+
+	var fileID uint64
+	var createAttributes SAttr3
+
+	status = dir.export.checkName(mkDirArgs.Where.Name)
+
+	if status == NFS3OK {
+		createAttributes, status = callerCredentials(call).checkCreate(dir.attributes, mkDirArgs.Attributes)
+	}
+
+	if status == NFS3OK {
+		fileID, err = dir.export.FileSystem.MkDir(dir.fileID, mkDirArgs.Where.Name, createAttributes)
+		status = errorStatus(err)
+	}
+
+	if status != NFS3OK {
+		res := &MkDir3Res{
+			Status: status,
+			ResFail: MkDir3ResFail{
+				DirWcc: dir.export.wccData(dir.attributes, dir.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	res := &MkDir3Res{
+		Status: NFS3OK,
+		ResOK: MkDir3ResOK{
+			DirWcc: dir.export.wccData(dir.attributes, dir.fileID),
+		},
+	}
+
+	fileHandle, attributes, status := nfsService.fileHandle(dir.export, fileID)
+
+	if status == NFS3OK {
+		res.ResOK.Obj = PostOpFH3{HandleFollows: 1, Handle: fileHandle}
+		res.ResOK.ObjAttributes = PostOpAttr{AttributesFollow: 1, ObjectAttributes: attributes}
+	}
+
+	return res, nil
+}
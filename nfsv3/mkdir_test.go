@@ -0,0 +1,107 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nfsv3_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dlorch/base-nfs/nfsv3"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+func mkDir(t *testing.T, nfsService *nfsv3.NFSService, dir nfsv3.NFSFH3, name string) *nfsv3.MkDir3Res {
+	args, err := xdr.Marshal(nfsv3.MkDir3Args{Where: nfsv3.DirOpArgs3{Dir: dir, Name: name}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err := nfsService.MkDir3(rootCall, args)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return res.(*nfsv3.MkDir3Res)
+}
+
+func remove(t *testing.T, nfsService *nfsv3.NFSService, dir nfsv3.NFSFH3, name string) *nfsv3.Remove3Res {
+	args, err := xdr.Marshal(nfsv3.Remove3Args{Object: nfsv3.DirOpArgs3{Dir: dir, Name: name}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err := nfsService.Remove3(rootCall, args)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return res.(*nfsv3.Remove3Res)
+}
+
+func rmDir(t *testing.T, nfsService *nfsv3.NFSService, dir nfsv3.NFSFH3, name string) *nfsv3.RmDir3Res {
+	args, err := xdr.Marshal(nfsv3.RmDir3Args{Object: nfsv3.DirOpArgs3{Dir: dir, Name: name}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err := nfsService.RmDir3(rootCall, args)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return res.(*nfsv3.RmDir3Res)
+}
+
+func TestMkDirRmDir(t *testing.T) {
+	nfsService, _, rootHandle := newService(t)
+
+	res := mkDir(t, nfsService, rootHandle, "dir")
+	if res.Status != nfsv3.NFS3OK || res.ResOK.ObjAttributes.ObjectAttributes.Type != nfsv3.NF3Dir {
+		t.Fatalf("Unexpected result %+v", res)
+	}
+
+	wcc := res.ResOK.DirWcc
+	if wcc.Before.ObjectAttributes.MTime == wcc.After.ObjectAttributes.MTime || wcc.After.ObjectAttributes.MTime != wcc.After.ObjectAttributes.CTime {
+		t.Fatalf("Expected mtime and ctime of the parent to be updated but got %+v", wcc)
+	}
+	if wcc.After.ObjectAttributes.Nlink != 3 {
+		t.Fatalf("Expected nlink 3 but got %d", wcc.After.ObjectAttributes.Nlink)
+	}
+
+	dir := res.ResOK.Obj.Handle
+	create(t, nfsService, dir, "file", nfsv3.CreateHow3{Mode: nfsv3.Guarded})
+
+	tests := []struct {
+		status uint32
+		got    uint32
+	}{
+		{nfsv3.NFS3ErrExist, mkDir(t, nfsService, rootHandle, "dir").Status},
+		{nfsv3.NFS3ErrNameTooLong, mkDir(t, nfsService, rootHandle, strings.Repeat("x", 256)).Status},
+		{nfsv3.NFS3ErrNotDir, mkDir(t, nfsService, lookup(t, nfsService, dir, "file").ResOK.Object, "sub").Status},
+		{nfsv3.NFS3ErrNotEmpty, rmDir(t, nfsService, rootHandle, "dir").Status},
+		{nfsv3.NFS3ErrNotDir, rmDir(t, nfsService, dir, "file").Status},
+		{nfsv3.NFS3ErrNoEnt, rmDir(t, nfsService, rootHandle, "missing").Status},
+		{nfsv3.NFS3ErrIsDir, remove(t, nfsService, rootHandle, "dir").Status},
+		{nfsv3.NFS3ErrNameTooLong, remove(t, nfsService, rootHandle, strings.Repeat("x", 256)).Status},
+		{nfsv3.NFS3OK, remove(t, nfsService, dir, "file").Status},
+		{nfsv3.NFS3ErrNoEnt, remove(t, nfsService, dir, "file").Status},
+	}
+
+	for i, test := range tests {
+		if test.got != test.status {
+			t.Fatalf("Expected %v but got %v (test %d)", test.status, test.got, i)
+		}
+	}
+
+	rmDirRes := rmDir(t, nfsService, rootHandle, "dir")
+	if rmDirRes.Status != nfsv3.NFS3OK || rmDirRes.ResOK.DirWcc.After.ObjectAttributes.Nlink != 2 {
+		t.Fatalf("Unexpected result %+v", rmDirRes)
+	}
+
+	getRes := lookup(t, nfsService, dir, ".")
+	if getRes.Status != nfsv3.NFS3ErrStale {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrStale, getRes.Status)
+	}
+}
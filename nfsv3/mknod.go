@@ -0,0 +1,130 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Procedure 11: MKNOD - Create a special device
+// https://tools.ietf.org/html/rfc1813#page-63
+
+package nfsv3
+
+import (
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// DeviceData3 (struct devicedata3)
+type DeviceData3 struct {
+	DevAttributes SAttr3
+	Spec          SpecData3
+}
+
+// MkNodData3 (union mknoddata3)
+type MkNodData3 struct {
+	Type           uint32      `xdr:"switch"`
+	Device         DeviceData3 `xdr:"case=4,3"` // NF3Chr, NF3Blk
+	PipeAttributes SAttr3      `xdr:"case=6,7"` // NF3Sock, NF3FIFO
+}
+
+// MkNod3Args (struct MKNOD3args)
+type MkNod3Args struct {
+	Where DirOpArgs3
+	What  MkNodData3
+}
+
+// MkNod3ResOK (struct MKNOD3resok)
+type MkNod3ResOK struct {
+	Obj           PostOpFH3
+	ObjAttributes PostOpAttr
+	DirWcc        WccData
+}
+
+// MkNod3ResFail (struct MKNOD3resfail)
+type MkNod3ResFail struct {
+	DirWcc WccData
+}
+
+// MkNod3Res (union MKNOD3res)
+type MkNod3Res struct {
+	Status  uint32        `xdr:"switch"`
+	ResOK   MkNod3ResOK   `xdr:"case=0"`
+	ResFail MkNod3ResFail `xdr:"default"`
+}
+
+// MkNod3 (NFSPROC3_MKNOD) creates a device node, socket or named pipe. Other
+// types are refused with NFS3ErrBadType, while file systems which can't store
+// a supported type refuse it with NFS3ErrNotSupp.
+func (nfsService *NFSService) MkNod3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var mkNodArgs MkNod3Args
+
+	_, err := xdr.Unmarshal(arg, &mkNodArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dir, status := nfsService.resolveHandle(mkNodArgs.Where.Dir.Data)
+
+	if status != NFS3OK {
+		return &MkNod3Res{Status: status}, nil
+	}
+
+	creds := callerCredentials(call)
+
+	var rdev SpecData3
+	var attributes SAttr3
+
+	switch mkNodArgs.What.Type {
+	case NF3Chr, NF3Blk:
+		rdev = mkNodArgs.What.Device.Spec
+		attributes = mkNodArgs.What.Device.DevAttributes
+
+		if creds.uid != rootUID { // device nodes give access to the hardware of the server
+			status = NFS3ErrPerm
+		}
+	case NF3Sock, NF3FIFO:
+		attributes = mkNodArgs.What.PipeAttributes
+	default:
+		status = NFS3ErrBadType
+	}
+
+	var fileID uint64
+
+	if status == NFS3OK {
+		status = dir.export.checkName(mkNodArgs.Where.Name)
+	}
+
+	if status == NFS3OK {
+		attributes, status = creds.checkCreate(dir.attributes, attributes)
+	}
+
+	if status == NFS3OK {
+		fileID, err = dir.export.FileSystem.MkNod(dir.fileID, mkNodArgs.Where.Name, mkNodArgs.What.Type, rdev, attributes)
+		status = errorStatus(err)
+	}
+
+	if status != NFS3OK {
+		res := &MkNod3Res{
+			Status: status,
+			ResFail: MkNod3ResFail{
+				DirWcc: dir.export.wccData(dir.attributes, dir.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	res := &MkNod3Res{
+		Status: NFS3OK,
+		ResOK: MkNod3ResOK{
+			DirWcc: dir.export.wccData(dir.attributes, dir.fileID),
+		},
+	}
+
+	fileHandle, objAttributes, status := nfsService.fileHandle(dir.export, fileID)
+
+	if status == NFS3OK {
+		res.ResOK.Obj = PostOpFH3{HandleFollows: 1, Handle: fileHandle}
+		res.ResOK.ObjAttributes = PostOpAttr{AttributesFollow: 1, ObjectAttributes: objAttributes}
+	}
+
+	return res, nil
+}
@@ -4,9 +4,11 @@
 
 package nfsv3
 
+import "github.com/dlorch/base-nfs/rpcv2"
+
 // VoidReply is an empty reply
 type VoidReply struct{}
 
-func nfsProcedure3Null(procedureArguments []byte) (interface{}, error) {
+func nfsProcedure3Null(call *rpcv2.CallContext, procedureArguments []byte) (interface{}, error) {
 	return &VoidReply{}, nil
 }
@@ -2,49 +2,84 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+// Procedure 20: PATHCONF - Retrieve POSIX information
+// https://tools.ietf.org/html/rfc1813#page-90
+
 package nfsv3
 
+import (
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
 // PathConf3Args (struct PATHCONF3args)
 type PathConf3Args struct {
-	FileHandle []byte
+	Object NFSFH3
 }
 
-// PathConf3ResultOK (struct PATHCONF3resok)
-type PathConf3ResultOK struct {
-	PathConf3Result
-	Objattributes   uint32 // TODO
-	Linkmax         uint32
-	Namemax         uint32
-	Notrunc         uint32 // TODO bool
-	Chownrestricted uint32 // TODO bool
-	Caseinsensitive uint32 // TODO bool
-	Casepreserving  uint32 // TODO bool
+// PathConf3ResOK (struct PATHCONF3resok)
+type PathConf3ResOK struct {
+	ObjAttributes   PostOpAttr
+	LinkMax         uint32
+	NameMax         uint32
+	NoTrunc         uint32
+	ChownRestricted uint32
+	CaseInsensitive uint32
+	CasePreserving  uint32
 }
 
-// TODO PATHCONF3resfail
+// PathConf3ResFail (struct PATHCONF3resfail)
+type PathConf3ResFail struct {
+	ObjAttributes PostOpAttr
+}
 
-// PathConf3Result (union PATHCONF3res)
-type PathConf3Result struct {
-	Status uint32
+// PathConf3Res (union PATHCONF3res)
+type PathConf3Res struct {
+	Status  uint32           `xdr:"switch"`
+	ResOK   PathConf3ResOK   `xdr:"case=0"`
+	ResFail PathConf3ResFail `xdr:"default"`
 }
 
-func nfsProcedure3PathConf(procedureArguments []byte) (interface{}, error) {
-	// parse request
-	// TODO
+// PathConf3 (NFSPROC3_PATHCONF) returns the POSIX limits of the export a file
+// handle belongs to. Names longer than name_max are rejected rather than
+// truncated, so no_trunc is always set.
+func (nfsService *NFSService) PathConf3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var pathConfArgs PathConf3Args
+
+	_, err := xdr.Unmarshal(arg, &pathConfArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	file, status := nfsService.resolveHandle(pathConfArgs.Object.Data)
 
-	// prepare result
-	pathConfResult := &PathConf3ResultOK{
-		PathConf3Result: PathConf3Result{
-			Status: NFS3OK,
+	if status != NFS3OK {
+		return &PathConf3Res{Status: status}, nil
+	}
+
+	settings := file.export.settings()
+
+	res := &PathConf3Res{
+		Status: NFS3OK,
+		ResOK: PathConf3ResOK{
+			ObjAttributes:   PostOpAttr{AttributesFollow: 1, ObjectAttributes: file.attributes},
+			LinkMax:         settings.LinkMax,
+			NameMax:         settings.NameMax,
+			NoTrunc:         1,
+			ChownRestricted: boolValue(settings.ChownRestricted),
+			CaseInsensitive: boolValue(settings.CaseInsensitive),
+			CasePreserving:  boolValue(settings.CasePreserving),
 		},
-		Objattributes:   0,
-		Linkmax:         32000,
-		Namemax:         255,
-		Notrunc:         0,
-		Chownrestricted: 1,
-		Caseinsensitive: 0,
-		Casepreserving:  1,
+	}
+	return res, nil
+}
+
+// boolValue encodes a bool as XDR boolean
+func boolValue(value bool) uint32 {
+	if value {
+		return 1
 	}
 
-	return pathConfResult, nil
+	return 0
 }
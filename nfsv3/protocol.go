@@ -12,7 +12,10 @@ const (
 
 // Sizes, given in decimal bytes, of various XDR structures
 const (
-	NFS3CookieVerfSize uint32 = 8 // The size in bytes of the opaque cookie verifier passed by READDIR and READDIRPLUS (NFS3_COOKIEVERFSIZE)
+	NFS3FHSize         uint32 = 64 // The maximum size in bytes of the opaque file handle (NFS3_FHSIZE)
+	NFS3CookieVerfSize uint32 = 8  // The size in bytes of the opaque cookie verifier passed by READDIR and READDIRPLUS (NFS3_COOKIEVERFSIZE)
+	NFS3CreateVerfSize uint32 = 8  // The size in bytes of the opaque verifier used for exclusive CREATE (NFS3_CREATEVERFSIZE)
+	NFS3WriteVerfSize  uint32 = 8  // The size in bytes of the opaque verifier used for asynchronous WRITE (NFS3_WRITEVERFSIZE)
 )
 
 // Returned with every procedure's results except for the NULL procedure (enum nfsstat3)
@@ -59,6 +62,38 @@ const (
 	NF3FIFO uint32 = 7 // named pipe (NF3FIFO)
 )
 
+// How data written by WRITE is committed to stable storage (enum stable_how)
+const (
+	Unstable uint32 = 0 // data may be cached until a subsequent COMMIT (UNSTABLE)
+	DataSync uint32 = 1 // data, and the metadata needed to retrieve it, is committed before replying (DATA_SYNC)
+	FileSync uint32 = 2 // data and all metadata is committed before replying (FILE_SYNC)
+)
+
+// How CREATE deals with an existing file of the same name (enum createmode3)
+const (
+	Unchecked uint32 = 0 // an existing file is opened and truncated if requested (UNCHECKED)
+	Guarded   uint32 = 1 // an existing file results in NFS3ErrExist (GUARDED)
+	Exclusive uint32 = 2 // like Guarded, but retransmitted requests succeed (EXCLUSIVE)
+)
+
+// Access rights checked with ACCESS (ACCESS3_*)
+const (
+	Access3Read    uint32 = 0x0001 // read data from file or read a directory
+	Access3Lookup  uint32 = 0x0002 // look up a name in a directory
+	Access3Modify  uint32 = 0x0004 // rewrite existing file data or modify existing directory entries
+	Access3Extend  uint32 = 0x0008 // write new data or add directory entries
+	Access3Delete  uint32 = 0x0010 // delete an existing directory entry
+	Access3Execute uint32 = 0x0020 // execute file
+)
+
+// Properties of a file system reported by FSINFO
+const (
+	FSF3Link        uint32 = 0x0001 // the file system supports hard links (FSF3_LINK)
+	FSF3Symlink     uint32 = 0x0002 // the file system supports symbolic links (FSF3_SYMLINK)
+	FSF3Homogeneous uint32 = 0x0008 // PATHCONF is valid for all files of the file system (FSF3_HOMOGENEOUS)
+	FSF3CanSetTime  uint32 = 0x0010 // the server can set the time of a file with SETATTR (FSF3_CANSETTIME)
+)
+
 // SpecData3 is returned as part of the FAttr3 structure (struct specdata3)
 type SpecData3 struct {
 	SpecData1 uint32
@@ -132,7 +167,7 @@ type PostOpFH3 struct {
 const (
 	DontChange uint32 = iota
 	SetToServerTime
-	SetToClienttime
+	SetToClientTime
 )
 
 // SetMode3 allows setting the mode
@@ -168,7 +203,7 @@ type SetATime struct {
 // SetMTime allows setting the MTime
 type SetMTime struct {
 	SetIt uint32   `xdr:"switch"`
-	ATime NFSTime3 `xdr:"case=2"`
+	MTime NFSTime3 `xdr:"case=2"`
 }
 
 // SAttr3 contains the file attributes that can be set from the client (struct sattr3)
@@ -211,5 +246,5 @@ const (
 	NFSProcedure3FSStat        uint32 = 18 // NFSPROC3_FSSTAT
 	NFSProcedure3FSInfo        uint32 = 19 // NFSPROC3_FSINFO
 	NFSProcedure3PathConf      uint32 = 20 // NFSPROC3_PATHCONF
-	NFSProcedure3Commint       uint32 = 21 // NFSPROC3_COMMIT
+	NFSProcedure3Commit        uint32 = 21 // NFSPROC3_COMMIT
 )
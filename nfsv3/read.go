@@ -0,0 +1,104 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Procedure 6: READ - Read From file
+// https://tools.ietf.org/html/rfc1813#page-46
+
+package nfsv3
+
+import (
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// Read3Args (struct READ3args)
+type Read3Args struct {
+	File   NFSFH3
+	Offset uint64
+	Count  uint32
+}
+
+// Read3ResOK (struct READ3resok)
+type Read3ResOK struct {
+	FileAttributes PostOpAttr
+	Count          uint32
+	EOF            uint32
+	Data           []byte
+}
+
+// Read3ResFail (struct READ3resfail)
+type Read3ResFail struct {
+	FileAttributes PostOpAttr
+}
+
+// Read3Res (union READ3res)
+type Read3Res struct {
+	Status  uint32       `xdr:"switch"`
+	ResOK   Read3ResOK   `xdr:"case=0"`
+	ResFail Read3ResFail `xdr:"default"`
+}
+
+// Read3 (NFSPROC3_READ) reads data from a file. Requests larger than the
+// advertised rtmax are shortened, which clients handle like any other short read.
+func (nfsService *NFSService) Read3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var readArgs Read3Args
+
+	_, err := xdr.Unmarshal(arg, &readArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	file, status := nfsService.resolveHandle(readArgs.File.Data)
+
+	if status != NFS3OK {
+		return &Read3Res{Status: status}, nil
+	}
+
+	creds := callerCredentials(call)
+
+	// clients read the files they execute, and let owners read their files as for writing
+	if !creds.isOwner(file.attributes) && creds.access(file.attributes, Access3Read|Access3Execute) == 0 {
+		res := &Read3Res{
+			Status: NFS3ErrAcces,
+			ResFail: Read3ResFail{
+				FileAttributes: PostOpAttr{AttributesFollow: 1, ObjectAttributes: file.attributes},
+			},
+		}
+		return res, nil
+	}
+
+	count := readArgs.Count
+
+	if count > file.export.settings().ReadMax {
+		count = file.export.settings().ReadMax
+	}
+
+	data, eof, err := file.export.FileSystem.Read(file.fileID, readArgs.Offset, count)
+
+	if err != nil {
+		res := &Read3Res{
+			Status: errorStatus(err),
+			ResFail: Read3ResFail{
+				FileAttributes: file.export.postOpAttr(file.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	res := &Read3Res{
+		Status: NFS3OK,
+		ResOK: Read3ResOK{
+			FileAttributes: file.export.postOpAttr(file.fileID),
+			Count:          uint32(len(data)),
+			Data:           data,
+		},
+	}
+
+	if eof {
+		res.ResOK.EOF = 1
+	}
+
+	return res, nil
+}
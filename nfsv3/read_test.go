@@ -0,0 +1,78 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nfsv3_test
+
+import (
+	"testing"
+
+	"github.com/dlorch/base-nfs/nfsv3"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+func read(t *testing.T, nfsService *nfsv3.NFSService, file nfsv3.NFSFH3, offset uint64, count uint32) *nfsv3.Read3Res {
+	args, err := xdr.Marshal(nfsv3.Read3Args{File: file, Offset: offset, Count: count})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err := nfsService.Read3(rootCall, args)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return res.(*nfsv3.Read3Res)
+}
+
+func TestRead(t *testing.T) {
+	nfsService, fileSystem, rootHandle := newService(t)
+
+	fileID, _ := fileSystem.Create(fileSystem.Root(), "file", nfsv3.SAttr3{})
+	_, _ = fileSystem.Write(fileID, 0, []byte("Hello, NFS"))
+	file := lookup(t, nfsService, rootHandle, "file").ResOK.Object
+
+	tests := []struct {
+		offset uint64
+		count  uint32
+		data   string
+		eof    uint32
+	}{
+		{0, 5, "Hello", 0},
+		{7, 3, "NFS", 1},
+		{7, 100, "NFS", 1},
+		{10, 100, "", 1},
+		{20, 100, "", 1},
+	}
+
+	for _, test := range tests {
+		res := read(t, nfsService, file, test.offset, test.count)
+		if res.Status != nfsv3.NFS3OK {
+			t.Fatalf("Expected %v but got %v", nfsv3.NFS3OK, res.Status)
+		}
+		if string(res.ResOK.Data) != test.data || res.ResOK.Count != uint32(len(test.data)) || res.ResOK.EOF != test.eof {
+			t.Fatalf("Expected %q (eof=%d) but got %q (count=%d, eof=%d)", test.data, test.eof, res.ResOK.Data, res.ResOK.Count, res.ResOK.EOF)
+		}
+		if res.ResOK.FileAttributes.AttributesFollow != 1 || res.ResOK.FileAttributes.ObjectAttributes.Size != 10 {
+			t.Fatalf("Unexpected attributes %+v", res.ResOK.FileAttributes)
+		}
+	}
+
+	res := read(t, nfsService, rootHandle, 0, 100)
+	if res.Status != nfsv3.NFS3ErrIsDir {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrIsDir, res.Status)
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	nfsService, fileSystem, rootHandle := newService(t)
+
+	fileID, _ := fileSystem.Create(fileSystem.Root(), "file", nfsv3.SAttr3{})
+	_, _ = fileSystem.Write(fileID, 0, make([]byte, 200000))
+	file := lookup(t, nfsService, rootHandle, "file").ResOK.Object
+
+	res := read(t, nfsService, file, 0, 1<<20)
+	if res.Status != nfsv3.NFS3OK || res.ResOK.Count != 131072 || res.ResOK.EOF != 0 {
+		t.Fatalf("Expected a short read of %d bytes but got %d (eof=%d)", 131072, res.ResOK.Count, res.ResOK.EOF)
+	}
+}
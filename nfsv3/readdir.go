@@ -0,0 +1,198 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Procedure 16: READDIR - Read From Directory
+// https://tools.ietf.org/html/rfc1813#page-76
+
+package nfsv3
+
+import (
+	"sort"
+
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// Sizes of the XDR encoded parts of READDIR and READDIRPLUS results, which are
+// limited by the count requested by the client
+const (
+	postOpAttrSize    uint32 = 4 + 84                 // post_op_attr with attributes
+	readDirResSize    uint32 = 4 + postOpAttrSize + 8 // status, dir_attributes and cookieverf
+	dirListSize       uint32 = 4 + 4                  // end of the list of entries and eof
+	entrySize         uint32 = 4 + 8 + 8              // entry3 without the name: value follows, fileid and cookie
+	entryPlusAttrSize uint32 = postOpAttrSize + 4 + 4 // name_attributes and name_handle without the handle data
+)
+
+// ReadDir3Args (struct READDIR3args)
+type ReadDir3Args struct {
+	Dir        NFSFH3
+	Cookie     uint64
+	CookieVerf [NFS3CookieVerfSize]byte
+	Count      uint32
+}
+
+// Entry3 (struct entry3)
+type Entry3 struct {
+	ValueFollows uint32 `xdr:"switch"`
+	FileID       uint64 `xdr:"case=1"`
+	Name         string
+	Cookie       uint64
+	NextEntry    *Entry3
+}
+
+// DirList3 (struct dirlist3)
+type DirList3 struct {
+	Entries *Entry3
+	EOF     uint32 // bool
+}
+
+// ReadDir3ResOK (struct READDIR3resok)
+type ReadDir3ResOK struct {
+	DirAttributes PostOpAttr
+	CookieVerf    [NFS3CookieVerfSize]byte
+	Reply         DirList3
+}
+
+// ReadDir3ResFail (struct READDIR3resfail)
+type ReadDir3ResFail struct {
+	DirAttributes PostOpAttr
+}
+
+// ReadDir3Res (union READDIR3res)
+type ReadDir3Res struct {
+	Status  uint32          `xdr:"switch"`
+	ResOK   ReadDir3ResOK   `xdr:"case=0"`
+	ResFail ReadDir3ResFail `xdr:"default"`
+}
+
+// xdrStringSize returns the size of an XDR encoded string
+func xdrStringSize(s string) uint32 {
+	return 4 + (uint32(len(s))+3)&^3
+}
+
+// directoryEntries returns the entries of a directory which follow the entry with the
+// given cookie, and the verifier of the cookies. Reading resumes at the right position
+// even if that entry was removed in the meantime. Cookies handed out by a previous
+// instance of the server are stale, unless the file system keeps them stable.
+func (nfsService *NFSService) directoryEntries(dir object, cookie uint64, cookieVerf [NFS3CookieVerfSize]byte) ([]DirEntry, [NFS3CookieVerfSize]byte, uint32) {
+	verifier, err := dir.export.cookieVerifier(dir.fileID, nfsService.cookieVerifier)
+
+	if err != nil {
+		return nil, verifier, errorStatus(err)
+	}
+
+	if cookie != 0 && cookieVerf != verifier {
+		return nil, verifier, NFS3ErrBadCookie
+	}
+
+	dirEntries, err := dir.export.FileSystem.ReadDir(dir.fileID)
+
+	if err != nil {
+		return nil, verifier, errorStatus(err)
+	}
+
+	i := sort.Search(len(dirEntries), func(i int) bool {
+		return dirEntries[i].Cookie > cookie
+	})
+
+	return dirEntries[i:], verifier, NFS3OK
+}
+
+// ReadDir3 (NFSPROC3_READDIR) returns the names of the entries of a directory. Large
+// directories are read in chunks of at most count bytes, each continuing after the
+// cookie of the last entry returned by the previous one.
+func (nfsService *NFSService) ReadDir3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var readDirArgs ReadDir3Args
+
+	_, err := xdr.Unmarshal(arg, &readDirArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dir, status := nfsService.resolveHandle(readDirArgs.Dir.Data)
+
+	if status != NFS3OK {
+		return &ReadDir3Res{Status: status}, nil
+	}
+
+	if dir.attributes.Type != NF3Dir {
+		status = NFS3ErrNotDir
+	} else {
+		status = callerCredentials(call).checkAccess(dir.attributes, Access3Read)
+	}
+
+	var dirEntries []DirEntry
+	var cookieVerifier [NFS3CookieVerfSize]byte
+
+	if status == NFS3OK {
+		dirEntries, cookieVerifier, status = nfsService.directoryEntries(dir, readDirArgs.Cookie, readDirArgs.CookieVerf)
+	}
+
+	count := readDirArgs.Count
+
+	if count > dir.export.settings().ReadMax {
+		count = dir.export.settings().ReadMax
+	}
+
+	// take as many entries as fit into count
+	size := readDirResSize + dirListSize
+	n := 0
+
+	for status == NFS3OK && n < len(dirEntries) {
+		size += entrySize + xdrStringSize(dirEntries[n].Name)
+
+		if size > count {
+			break
+		}
+
+		n++
+	}
+
+	if status == NFS3OK && n == 0 && len(dirEntries) > 0 {
+		status = NFS3ErrTooSmall
+	}
+
+	if status != NFS3OK {
+		res := &ReadDir3Res{
+			Status: status,
+			ResFail: ReadDir3ResFail{
+				DirAttributes: dir.export.postOpAttr(dir.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	// build the linked list of entries back to front
+	entries := &Entry3{
+		ValueFollows: 0,
+	}
+
+	for i := n - 1; i >= 0; i-- {
+		entries = &Entry3{
+			ValueFollows: 1,
+			FileID:       dirEntries[i].FileID,
+			Name:         dirEntries[i].Name,
+			Cookie:       dirEntries[i].Cookie,
+			NextEntry:    entries,
+		}
+	}
+
+	res := &ReadDir3Res{
+		Status: NFS3OK,
+		ResOK: ReadDir3ResOK{
+			DirAttributes: dir.export.postOpAttr(dir.fileID),
+			CookieVerf:    cookieVerifier,
+			Reply: DirList3{
+				Entries: entries,
+			},
+		},
+	}
+
+	if n == len(dirEntries) {
+		res.ResOK.Reply.EOF = 1
+	}
+
+	return res, nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nfsv3_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dlorch/base-nfs/localfs"
+	"github.com/dlorch/base-nfs/nfsv3"
+)
+
+// TestReadDirRestart verifies that clients keep reading a directory after a restart
+// of the server if the file system's cookies are stable
+func TestReadDirRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "readdir")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 100; i++ {
+		err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d", i)), nil, 0644)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	fileSystem, err := localfs.New(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	nfsService := nfsv3.NewNFSv3Service(&nfsv3.Export{Path: "/export", FileSystem: fileSystem})
+	nfsService.SetFileHandleKey([]byte("key"))
+	rootHandle, _ := nfsService.MountHandle("/export")
+
+	res := call(t, nfsService.ReadDir3, nfsv3.ReadDir3Args{Dir: rootHandle, Count: 1024}).(*nfsv3.ReadDir3Res)
+	if res.Status != nfsv3.NFS3OK || res.ResOK.Reply.EOF == 1 {
+		t.Fatalf("Expected the first chunk of entries but got %+v", res)
+	}
+
+	args := nfsv3.ReadDir3Args{Dir: rootHandle, CookieVerf: res.ResOK.CookieVerf, Count: 1024}
+	seen := make(map[string]bool)
+
+	for entry := res.ResOK.Reply.Entries; entry.ValueFollows == 1; entry = entry.NextEntry {
+		args.Cookie = entry.Cookie
+		seen[entry.Name] = true
+	}
+
+	restarted := nfsv3.NewNFSv3Service(&nfsv3.Export{Path: "/export", FileSystem: fileSystem})
+	restarted.SetFileHandleKey([]byte("key"))
+
+	res = call(t, restarted.ReadDir3, args).(*nfsv3.ReadDir3Res)
+	if res.Status != nfsv3.NFS3OK {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3OK, res.Status)
+	}
+
+	for entry := res.ResOK.Reply.Entries; entry.ValueFollows == 1; entry = entry.NextEntry {
+		if seen[entry.Name] {
+			t.Fatalf("Expected %q to be returned once", entry.Name)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nfsv3_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dlorch/base-nfs/memfs"
+	"github.com/dlorch/base-nfs/nfsv3"
+)
+
+// memfsWithFiles returns an in-memory file system with n empty files in the root directory
+func memfsWithFiles(t *testing.T, n int) *memfs.FileSystem {
+	fileSystem := memfs.New(1<<20, uint64(n)+1)
+
+	for i := 0; i < n; i++ {
+		_, err := fileSystem.Create(fileSystem.Root(), fmt.Sprintf("file%d", i), nfsv3.SAttr3{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	return fileSystem
+}
+
+// readDirAll reads a directory in chunks of count bytes and returns the names of its entries
+func readDirAll(t *testing.T, nfsService *nfsv3.NFSService, dir nfsv3.NFSFH3, count uint32, each func(names []string)) []string {
+	var names []string
+	var cookie uint64
+	var cookieVerf [nfsv3.NFS3CookieVerfSize]byte
+
+	for {
+		args := nfsv3.ReadDir3Args{Dir: dir, Cookie: cookie, CookieVerf: cookieVerf, Count: count}
+
+		res := call(t, nfsService.ReadDir3, args).(*nfsv3.ReadDir3Res)
+		if res.Status != nfsv3.NFS3OK {
+			t.Fatalf("Expected %v but got %v", nfsv3.NFS3OK, res.Status)
+		}
+
+		var chunk []string
+
+		for entry := res.ResOK.Reply.Entries; entry.ValueFollows == 1; entry = entry.NextEntry {
+			chunk = append(chunk, entry.Name)
+			cookie = entry.Cookie
+		}
+
+		names = append(names, chunk...)
+		cookieVerf = res.ResOK.CookieVerf
+
+		if res.ResOK.Reply.EOF == 1 {
+			return names
+		}
+
+		if len(chunk) == 0 {
+			t.Fatalf("Expected entries or EOF after %d entries", len(names))
+		}
+
+		if each != nil {
+			each(chunk)
+		}
+	}
+}
+
+func TestReadDirLarge(t *testing.T) {
+	fileSystem := memfsWithFiles(t, 100000)
+	nfsService := nfsv3.NewNFSv3Service(&nfsv3.Export{Path: "/export", FileSystem: fileSystem})
+	rootHandle, _ := nfsService.MountHandle("/export")
+
+	names := readDirAll(t, nfsService, rootHandle, 8192, nil)
+	if len(names) != 100002 {
+		t.Fatalf("Expected %d entries but got %d", 100002, len(names))
+	}
+
+	for i, name := range names[2:] {
+		if name != fmt.Sprintf("file%d", i) {
+			t.Fatalf("Expected %q but got %q", fmt.Sprintf("file%d", i), name)
+		}
+	}
+}
+
+// TestReadDirConcurrentChanges verifies that entries which are neither removed nor
+// added while reading the directory are returned exactly once
+func TestReadDirConcurrentChanges(t *testing.T) {
+	fileSystem := memfsWithFiles(t, 1000)
+	nfsService := nfsv3.NewNFSv3Service(&nfsv3.Export{Path: "/export", FileSystem: fileSystem})
+	rootHandle, _ := nfsService.MountHandle("/export")
+
+	removed := make(map[string]bool)
+
+	names := readDirAll(t, nfsService, rootHandle, 1024, func(chunk []string) {
+		// remove the last entry returned and the entry following it
+		for _, name := range []string{chunk[len(chunk)-1], fmt.Sprintf("file%d", len(removed)*50+1)} {
+			if fileSystem.Remove(fileSystem.Root(), name) == nil {
+				removed[name] = true
+			}
+		}
+	})
+
+	seen := make(map[string]bool)
+
+	for _, name := range names {
+		if seen[name] {
+			t.Fatalf("Expected %q to be returned once", name)
+		}
+		seen[name] = true
+	}
+
+	for i := 0; i < 1000; i++ {
+		name := fmt.Sprintf("file%d", i)
+		if !seen[name] && !removed[name] {
+			t.Fatalf("Expected %q to be returned", name)
+		}
+	}
+}
+
+func TestReadDirCookies(t *testing.T) {
+	nfsService, fileSystem, rootHandle := newService(t)
+
+	_, _ = fileSystem.Create(fileSystem.Root(), "file", nfsv3.SAttr3{})
+
+	args := nfsv3.ReadDir3Args{Dir: rootHandle, Count: 4096}
+
+	res := call(t, nfsService.ReadDir3, args).(*nfsv3.ReadDir3Res)
+	if res.Status != nfsv3.NFS3OK {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3OK, res.Status)
+	}
+
+	// a cookie verifier of another instance of the server
+	args.Cookie = res.ResOK.Reply.Entries.Cookie
+	args.CookieVerf = res.ResOK.CookieVerf
+	args.CookieVerf[0]++
+
+	res = call(t, nfsService.ReadDir3, args).(*nfsv3.ReadDir3Res)
+	if res.Status != nfsv3.NFS3ErrBadCookie {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrBadCookie, res.Status)
+	}
+
+	args = nfsv3.ReadDir3Args{Dir: rootHandle, Count: 64}
+
+	res = call(t, nfsService.ReadDir3, args).(*nfsv3.ReadDir3Res)
+	if res.Status != nfsv3.NFS3ErrTooSmall {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrTooSmall, res.Status)
+	}
+
+	file := lookup(t, nfsService, rootHandle, "file").ResOK.Object
+	args = nfsv3.ReadDir3Args{Dir: file, Count: 4096}
+
+	res = call(t, nfsService.ReadDir3, args).(*nfsv3.ReadDir3Res)
+	if res.Status != nfsv3.NFS3ErrNotDir {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrNotDir, res.Status)
+	}
+}
+
+func TestReadDirPlus(t *testing.T) {
+	fileSystem := memfsWithFiles(t, 1000)
+	nfsService := nfsv3.NewNFSv3Service(&nfsv3.Export{Path: "/export", FileSystem: fileSystem})
+	rootHandle, _ := nfsService.MountHandle("/export")
+
+	var cookie uint64
+	var cookieVerf [nfsv3.NFS3CookieVerfSize]byte
+	count := 0
+
+	for {
+		args := nfsv3.ReadDirPlus3Args{Dir: rootHandle, Cookie: cookie, CookieVerifier: cookieVerf, DirCount: 512, MaxCount: 8192}
+
+		res := call(t, nfsService.ReadDirPlus3, args).(*nfsv3.ReadDirPlus3Res)
+		if res.Status != nfsv3.NFS3OK {
+			t.Fatalf("Expected %v but got %v", nfsv3.NFS3OK, res.Status)
+		}
+
+		for entry := res.ResOK.Reply.Entries; entry.ValueFollows == 1; entry = entry.NextEntry {
+			if entry.NameHandle.HandleFollows != 1 || entry.NameAttributes.ObjectAttributes.FileID != entry.FileID {
+				t.Fatalf("Expected handle and attributes of %q but got %+v", entry.FileName3, entry)
+			}
+
+			count++
+			cookie = entry.Cookie
+		}
+
+		cookieVerf = res.ResOK.CookieVerifier
+
+		if res.ResOK.Reply.EOF == 1 {
+			break
+		}
+	}
+
+	if count != 1002 {
+		t.Fatalf("Expected %d entries but got %d", 1002, count)
+	}
+}
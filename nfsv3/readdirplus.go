@@ -2,8 +2,25 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+// Procedure 17: READDIRPLUS - Extended read from directory
+// https://tools.ietf.org/html/rfc1813#page-80
+
 package nfsv3
 
+import (
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// ReadDirPlus3Args (struct READDIRPLUS3args)
+type ReadDirPlus3Args struct {
+	Dir            NFSFH3
+	Cookie         uint64
+	CookieVerifier [NFS3CookieVerfSize]byte
+	DirCount       uint32
+	MaxCount       uint32
+}
+
 // EntryPlus3 (struct entryplus3)
 type EntryPlus3 struct {
 	ValueFollows   uint32 `xdr:"switch"`
@@ -21,192 +38,137 @@ type DirListPlus3 struct {
 	EOF     uint32 // bool
 }
 
-// ReadDirPlus3ResultOK (struct READDIRPLUS3resok)
-type ReadDirPlus3ResultOK struct {
-	ReadDirPlus3Result
+// ReadDirPlus3ResOK (struct READDIRPLUS3resok)
+type ReadDirPlus3ResOK struct {
 	DirectoryAttributes PostOpAttr
 	CookieVerifier      [NFS3CookieVerfSize]byte
 	Reply               DirListPlus3
 }
 
-// ReadDirPlus3Result (union READDIRPLUS3res)
-type ReadDirPlus3Result struct {
-	Status uint32
+// ReadDirPlus3ResFail (struct READDIRPLUS3resfail)
+type ReadDirPlus3ResFail struct {
+	DirectoryAttributes PostOpAttr
 }
 
-func nfsProcedure3ReadDirPlus(procedureArguments []byte) (interface{}, error) {
-	// parse request
-	// TODO
+// ReadDirPlus3Res (union READDIRPLUS3res)
+type ReadDirPlus3Res struct {
+	Status  uint32              `xdr:"switch"`
+	ResOK   ReadDirPlus3ResOK   `xdr:"case=0"`
+	ResFail ReadDirPlus3ResFail `xdr:"default"`
+}
 
-	// prepare result
-	readDirPlusResult := &ReadDirPlus3ResultOK{
-		ReadDirPlus3Result: ReadDirPlus3Result{
-			Status: NFS3OK,
-		},
-		DirectoryAttributes: PostOpAttr{
-			AttributesFollow: 1,
-			ObjectAttributes: FAttr3{
-				Type:  2,
-				Mode:  040777,
-				Nlink: 4,
-				UID:   0,
-				GID:   0,
-				Size:  4096,
-				Used:  8192,
-				RDev: SpecData3{
-					SpecData1: 0,
-					SpecData2: 0,
-				},
-				FSID:   0x388e4346cfc706a8,
-				FileID: 16,
-				ATime: NFSTime3{
-					Seconds:  1563137262,
-					NSeconds: 460002975,
-				},
-				MTime: NFSTime3{
-					Seconds:  1537128120,
-					NSeconds: 839607220,
-				},
-				CTime: NFSTime3{
-					Seconds:  1537128120,
-					NSeconds: 839607220,
-				},
+// ReadDirPlus3 (NFSPROC3_READDIRPLUS) returns the entries of a directory together with
+// their file handles and attributes. Large directories are read in chunks limited by
+// dircount, the size of the names, and maxcount, the size of the whole result.
+func (nfsService *NFSService) ReadDirPlus3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var readDirPlusArgs ReadDirPlus3Args
+
+	_, err := xdr.Unmarshal(arg, &readDirPlusArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dir, status := nfsService.resolveHandle(readDirPlusArgs.Dir.Data)
+
+	if status != NFS3OK {
+		return &ReadDirPlus3Res{Status: status}, nil
+	}
+
+	if dir.attributes.Type != NF3Dir {
+		status = NFS3ErrNotDir
+	} else {
+		status = callerCredentials(call).checkAccess(dir.attributes, Access3Read)
+	}
+
+	var dirEntries []DirEntry
+	var cookieVerifier [NFS3CookieVerfSize]byte
+
+	if status == NFS3OK {
+		dirEntries, cookieVerifier, status = nfsService.directoryEntries(dir, readDirPlusArgs.Cookie, readDirPlusArgs.CookieVerifier)
+	}
+
+	if status != NFS3OK {
+		res := &ReadDirPlus3Res{
+			Status: status,
+			ResFail: ReadDirPlus3ResFail{
+				DirectoryAttributes: dir.export.postOpAttr(dir.fileID),
 			},
-		},
-		CookieVerifier: [NFS3CookieVerfSize]byte{},
-		Reply: DirListPlus3{
-			Entries: &EntryPlus3{
-				ValueFollows: 1,
-				FileID:       2,
-				FileName3:    "..",
-				Cookie:       6457138716124813847,
-				NameAttributes: PostOpAttr{
-					AttributesFollow: 1,
-					ObjectAttributes: FAttr3{
-						Type:  2,
-						Mode:  040777,
-						Nlink: 15,
-						UID:   0,
-						GID:   0,
-						Size:  4096,
-						Used:  4096,
-						RDev: SpecData3{
-							SpecData1: 0,
-							SpecData2: 0,
-						},
-						FSID:   0x388e4346cfc706a8,
-						FileID: 2,
-						ATime: NFSTime3{
-							Seconds:  1562969613,
-							NSeconds: 760001904,
-						},
-						MTime: NFSTime3{
-							Seconds:  1562969597,
-							NSeconds: 560001387,
-						},
-						CTime: NFSTime3{
-							Seconds:  1562969597,
-							NSeconds: 560001387,
-						},
-					},
-				},
-				NameHandle: PostOpFH3{
-					HandleFollows: 1,
-					Handle: NFSFH3{
-						Data: []byte{0x01, 0x00, 0x07, 0x01, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xa8, 0x06, 0xc7, 0xcf, 0x46, 0x43, 0x8e, 0x38, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
-					},
-				},
-				NextEntry: &EntryPlus3{
-					ValueFollows: 1,
-					FileID:       16,
-					FileName3:    ".",
-					Cookie:       6684891493313481230,
-					NameAttributes: PostOpAttr{
-						AttributesFollow: 1,
-						ObjectAttributes: FAttr3{
-							Type:  2,
-							Mode:  040755,
-							Nlink: 15,
-							UID:   0,
-							GID:   0,
-							Size:  4096,
-							Used:  4096,
-							RDev: SpecData3{
-								SpecData1: 0,
-								SpecData2: 0,
-							},
-							FSID:   0x388e4346cfc706a8,
-							FileID: 2,
-							ATime: NFSTime3{
-								Seconds:  1562969613,
-								NSeconds: 760001904,
-							},
-							MTime: NFSTime3{
-								Seconds:  1562969597,
-								NSeconds: 560001387,
-							},
-							CTime: NFSTime3{
-								Seconds:  1562969597,
-								NSeconds: 560001387,
-							},
-						},
-					},
-					NameHandle: PostOpFH3{
-						HandleFollows: 1,
-						Handle: NFSFH3{
-							Data: []byte{0x01, 0x00, 0x07, 0x01, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xa8, 0x06, 0xc7, 0xcf, 0x46, 0x43, 0x8e, 0x38, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
-						},
-					},
-					NextEntry: &EntryPlus3{
-						ValueFollows: 1,
-						FileID:       40243830,
-						FileName3:    "gopher.go",
-						Cookie:       3621999153351014942,
-						NameAttributes: PostOpAttr{
-							AttributesFollow: 1,
-							ObjectAttributes: FAttr3{
-								Type:  1,
-								Mode:  0100666,
-								Nlink: 1,
-								UID:   1027,
-								GID:   100,
-								Size:  292,
-								Used:  8192,
-								RDev: SpecData3{
-									SpecData1: 0,
-									SpecData2: 0,
-								},
-								FSID:   0x388e4346cfc706a8,
-								FileID: 40243830,
-								ATime: NFSTime3{
-									Seconds:  1456162928,
-									NSeconds: 85375909,
-								},
-								MTime: NFSTime3{
-									Seconds:  1389825403,
-									NSeconds: 480233665,
-								},
-								CTime: NFSTime3{
-									Seconds:  1419273932,
-									NSeconds: 807093921,
-								},
-							},
-						},
-						NameHandle: PostOpFH3{
-							HandleFollows: 1,
-							Handle: NFSFH3{
-								Data: []byte{0x01, 0x00, 0x07, 0x02, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xa8, 0x06, 0xc7, 0xcf, 0x46, 0x43, 0x8e, 0x38, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x76, 0x12, 0x66, 0x02, 0x6d, 0x85, 0xd2, 0x28, 0x10, 0x00, 0x00, 0x00, 0xd9, 0x3c, 0x6d, 0x78},
-							},
-						},
-						NextEntry: &EntryPlus3{
-							ValueFollows: 0,
-						},
-					},
-				},
+		}
+		return res, nil
+	}
+
+	maxCount := readDirPlusArgs.MaxCount
+
+	if maxCount > dir.export.settings().ReadMax {
+		maxCount = dir.export.settings().ReadMax
+	}
+
+	// take as many entries as fit into dircount and maxcount
+	entries := make([]EntryPlus3, 0)
+	dirSize := uint32(0)
+	size := readDirResSize + dirListSize
+
+	for _, dirEntry := range dirEntries {
+		entry := EntryPlus3{
+			ValueFollows: 1,
+			FileID:       dirEntry.FileID,
+			FileName3:    dirEntry.Name,
+			Cookie:       dirEntry.Cookie,
+		}
+
+		// attributes and handle are optional, e.g. if the entry was removed in the meantime
+		nameHandle, attributes, status := nfsService.fileHandle(dir.export, dirEntry.FileID)
+
+		if status == NFS3OK {
+			entry.NameAttributes = PostOpAttr{AttributesFollow: 1, ObjectAttributes: attributes}
+			entry.NameHandle = PostOpFH3{HandleFollows: 1, Handle: nameHandle}
+		}
+
+		dirSize += entrySize + xdrStringSize(dirEntry.Name)
+		size += entrySize + xdrStringSize(dirEntry.Name) + entryPlusAttrSize + uint32(len(nameHandle.Data)+3)&^3
+
+		if dirSize > readDirPlusArgs.DirCount || size > maxCount {
+			break
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if len(entries) == 0 && len(dirEntries) > 0 {
+		res := &ReadDirPlus3Res{
+			Status: NFS3ErrTooSmall,
+			ResFail: ReadDirPlus3ResFail{
+				DirectoryAttributes: dir.export.postOpAttr(dir.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	// build the linked list of entries back to front
+	list := &EntryPlus3{
+		ValueFollows: 0,
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		entries[i].NextEntry = list
+		list = &entries[i]
+	}
+
+	res := &ReadDirPlus3Res{
+		Status: NFS3OK,
+		ResOK: ReadDirPlus3ResOK{
+			DirectoryAttributes: dir.export.postOpAttr(dir.fileID),
+			CookieVerifier:      cookieVerifier,
+			Reply: DirListPlus3{
+				Entries: list,
 			},
-			EOF: 1,
 		},
 	}
 
-	return readDirPlusResult, nil
+	if len(entries) == len(dirEntries) {
+		res.ResOK.Reply.EOF = 1
+	}
+
+	return res, nil
 }
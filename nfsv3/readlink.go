@@ -0,0 +1,74 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Procedure 5: READLINK - Read from symbolic link
+// https://tools.ietf.org/html/rfc1813#page-44
+
+package nfsv3
+
+import (
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// Readlink3Args (struct READLINK3args)
+type Readlink3Args struct {
+	Symlink NFSFH3
+}
+
+// Readlink3ResOK (struct READLINK3resok)
+type Readlink3ResOK struct {
+	SymlinkAttributes PostOpAttr
+	Data              string
+}
+
+// Readlink3ResFail (struct READLINK3resfail)
+type Readlink3ResFail struct {
+	SymlinkAttributes PostOpAttr
+}
+
+// Readlink3Res (union READLINK3res)
+type Readlink3Res struct {
+	Status  uint32           `xdr:"switch"`
+	ResOK   Readlink3ResOK   `xdr:"case=0"`
+	ResFail Readlink3ResFail `xdr:"default"`
+}
+
+// Readlink3 (NFSPROC3_READLINK) reads the target of a symbolic link
+func (nfsService *NFSService) Readlink3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var readlinkArgs Readlink3Args
+
+	_, err := xdr.Unmarshal(arg, &readlinkArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	symlink, status := nfsService.resolveHandle(readlinkArgs.Symlink.Data)
+
+	if status != NFS3OK {
+		return &Readlink3Res{Status: status}, nil
+	}
+
+	target, err := symlink.export.FileSystem.Readlink(symlink.fileID)
+
+	if err != nil {
+		res := &Readlink3Res{
+			Status: errorStatus(err),
+			ResFail: Readlink3ResFail{
+				SymlinkAttributes: symlink.export.postOpAttr(symlink.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	res := &Readlink3Res{
+		Status: NFS3OK,
+		ResOK: Readlink3ResOK{
+			SymlinkAttributes: symlink.export.postOpAttr(symlink.fileID),
+			Data:              target,
+		},
+	}
+	return res, nil
+}
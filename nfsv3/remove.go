@@ -0,0 +1,82 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Procedure 12: REMOVE - Remove a File
+// https://tools.ietf.org/html/rfc1813#page-67
+
+package nfsv3
+
+import (
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// Remove3Args (struct REMOVE3args)
+type Remove3Args struct {
+	Object DirOpArgs3
+}
+
+// Remove3ResOK (struct REMOVE3resok)
+type Remove3ResOK struct {
+	DirWcc WccData
+}
+
+// Remove3ResFail (struct REMOVE3resfail)
+type Remove3ResFail struct {
+	DirWcc WccData
+}
+
+// Remove3Res (union REMOVE3res)
+type Remove3Res struct {
+	Status  uint32         `xdr:"switch"`
+	ResOK   Remove3ResOK   `xdr:"case=0"`
+	ResFail Remove3ResFail `xdr:"default"`
+}
+
+// Remove3 (NFSPROC3_REMOVE) removes a non-directory entry from a directory.
+// Directories are removed with RMDIR instead.
+func (nfsService *NFSService) Remove3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var removeArgs Remove3Args
+
+	_, err := xdr.Unmarshal(arg, &removeArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dir, status := nfsService.resolveHandle(removeArgs.Object.Dir.Data)
+
+	if status != NFS3OK {
+		return &Remove3Res{Status: status}, nil
+	}
+
+	status = dir.export.checkName(removeArgs.Object.Name)
+
+	if status == NFS3OK {
+		status = callerCredentials(call).checkDelete(dir, removeArgs.Object.Name)
+	}
+
+	if status == NFS3OK {
+		err = dir.export.FileSystem.Remove(dir.fileID, removeArgs.Object.Name)
+		status = errorStatus(err)
+	}
+
+	if status != NFS3OK {
+		res := &Remove3Res{
+			Status: status,
+			ResFail: Remove3ResFail{
+				DirWcc: dir.export.wccData(dir.attributes, dir.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	res := &Remove3Res{
+		Status: NFS3OK,
+		ResOK: Remove3ResOK{
+			DirWcc: dir.export.wccData(dir.attributes, dir.fileID),
+		},
+	}
+	return res, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Procedure 14: RENAME - Rename a File or Directory
+// https://tools.ietf.org/html/rfc1813#page-71
+
+package nfsv3
+
+import (
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// Rename3Args (struct RENAME3args)
+type Rename3Args struct {
+	From DirOpArgs3
+	To   DirOpArgs3
+}
+
+// Rename3ResOK (struct RENAME3resok)
+type Rename3ResOK struct {
+	FromDirWcc WccData
+	ToDirWcc   WccData
+}
+
+// Rename3ResFail (struct RENAME3resfail)
+type Rename3ResFail struct {
+	FromDirWcc WccData
+	ToDirWcc   WccData
+}
+
+// Rename3Res (union RENAME3res)
+type Rename3Res struct {
+	Status  uint32         `xdr:"switch"`
+	ResOK   Rename3ResOK   `xdr:"case=0"`
+	ResFail Rename3ResFail `xdr:"default"`
+}
+
+// Rename3 (NFSPROC3_RENAME) atomically renames an object, replacing an existing
+// object of the same type at the target. Objects can't be moved to another export.
+func (nfsService *NFSService) Rename3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var renameArgs Rename3Args
+
+	_, err := xdr.Unmarshal(arg, &renameArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fromDir, status := nfsService.resolveHandle(renameArgs.From.Dir.Data)
+
+	if status != NFS3OK {
+		return &Rename3Res{Status: status}, nil
+	}
+
+	toDir, status := nfsService.resolveHandle(renameArgs.To.Dir.Data)
+
+	if status != NFS3OK {
+		res := &Rename3Res{
+			Status: status,
+			ResFail: Rename3ResFail{
+				FromDirWcc: fromDir.export.wccData(fromDir.attributes, fromDir.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	status = fromDir.export.checkName(renameArgs.From.Name)
+
+	if status == NFS3OK {
+		status = toDir.export.checkName(renameArgs.To.Name)
+	}
+
+	if status == NFS3OK && (fromDir.export != toDir.export || fromDir.attributes.FSID != toDir.attributes.FSID) {
+		status = NFS3ErrXDev
+	}
+
+	creds := callerCredentials(call)
+
+	if status == NFS3OK {
+		status = creds.checkDelete(fromDir, renameArgs.From.Name)
+	}
+
+	if status == NFS3OK { // an existing object at the target is replaced
+		status = creds.checkDelete(toDir, renameArgs.To.Name)
+	}
+
+	if status == NFS3OK {
+		err = fromDir.export.FileSystem.Rename(fromDir.fileID, renameArgs.From.Name, toDir.fileID, renameArgs.To.Name)
+		status = errorStatus(err)
+	}
+
+	if status != NFS3OK {
+		res := &Rename3Res{
+			Status: status,
+			ResFail: Rename3ResFail{
+				FromDirWcc: fromDir.export.wccData(fromDir.attributes, fromDir.fileID),
+				ToDirWcc:   toDir.export.wccData(toDir.attributes, toDir.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	res := &Rename3Res{
+		Status: NFS3OK,
+		ResOK: Rename3ResOK{
+			FromDirWcc: fromDir.export.wccData(fromDir.attributes, fromDir.fileID),
+			ToDirWcc:   toDir.export.wccData(toDir.attributes, toDir.fileID),
+		},
+	}
+	return res, nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nfsv3_test
+
+import (
+	"testing"
+
+	"github.com/dlorch/base-nfs/memfs"
+	"github.com/dlorch/base-nfs/nfsv3"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+func rename(t *testing.T, nfsService *nfsv3.NFSService, fromDir nfsv3.NFSFH3, fromName string, toDir nfsv3.NFSFH3, toName string) *nfsv3.Rename3Res {
+	args, err := xdr.Marshal(nfsv3.Rename3Args{
+		From: nfsv3.DirOpArgs3{Dir: fromDir, Name: fromName},
+		To:   nfsv3.DirOpArgs3{Dir: toDir, Name: toName},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err := nfsService.Rename3(rootCall, args)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return res.(*nfsv3.Rename3Res)
+}
+
+// TestRenameIntoPlace verifies the atomic update of a file by writing a temporary
+// file and renaming it over the original
+func TestRenameIntoPlace(t *testing.T) {
+	nfsService, fileSystem, rootHandle := newService(t)
+
+	dirID, _ := fileSystem.MkDir(fileSystem.Root(), "dir", nfsv3.SAttr3{})
+	fileID, _ := fileSystem.Create(dirID, "file", nfsv3.SAttr3{})
+	tempID, _ := fileSystem.Create(fileSystem.Root(), "file.tmp", nfsv3.SAttr3{})
+	_, _ = fileSystem.Write(tempID, 0, []byte("new"))
+
+	dir := lookup(t, nfsService, rootHandle, "dir").ResOK.Object
+
+	res := rename(t, nfsService, rootHandle, "file.tmp", dir, "file")
+	if res.Status != nfsv3.NFS3OK {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3OK, res.Status)
+	}
+	if res.ResOK.FromDirWcc.After.AttributesFollow != 1 || res.ResOK.ToDirWcc.After.AttributesFollow != 1 {
+		t.Fatalf("Expected wcc data for both directories but got %+v", res.ResOK)
+	}
+
+	gotID, _ := fileSystem.Lookup(dirID, "file")
+	if gotID != tempID {
+		t.Fatalf("Expected %d but got %d", tempID, gotID)
+	}
+
+	_, err := fileSystem.GetAttr(fileID)
+	if err == nil {
+		t.Fatalf("Expected replaced file %d to be removed", fileID)
+	}
+
+	_, _ = fileSystem.MkDir(dirID, "sub", nfsv3.SAttr3{})
+
+	tests := []struct {
+		status uint32
+		got    uint32
+	}{
+		{nfsv3.NFS3ErrNoEnt, rename(t, nfsService, rootHandle, "missing", dir, "x").Status},
+		{nfsv3.NFS3ErrNotEmpty, rename(t, nfsService, dir, "sub", rootHandle, "dir").Status},
+		{nfsv3.NFS3ErrInval, rename(t, nfsService, rootHandle, "dir", dir, "x").Status},
+		{nfsv3.NFS3ErrIsDir, rename(t, nfsService, dir, "file", dir, "sub").Status},
+	}
+
+	for i, test := range tests {
+		if test.got != test.status {
+			t.Fatalf("Expected %v but got %v (test %d)", test.status, test.got, i)
+		}
+	}
+}
+
+func TestRenameAcrossExports(t *testing.T) {
+	first := memfs.New(1<<20, 1024)
+	second := memfs.New(1<<20, 1024)
+
+	nfsService := nfsv3.NewNFSv3Service(
+		&nfsv3.Export{Path: "/first", FileSystem: first},
+		&nfsv3.Export{Path: "/second", FileSystem: second},
+	)
+
+	_, _ = first.Create(first.Root(), "file", nfsv3.SAttr3{})
+
+	firstHandle, _ := nfsService.MountHandle("/first")
+	secondHandle, _ := nfsService.MountHandle("/second")
+
+	res := rename(t, nfsService, firstHandle, "file", secondHandle, "file")
+	if res.Status != nfsv3.NFS3ErrXDev {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrXDev, res.Status)
+	}
+
+	_, err := first.Lookup(first.Root(), "file")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Procedure 13: RMDIR - Remove a Directory
+// https://tools.ietf.org/html/rfc1813#page-69
+
+package nfsv3
+
+import (
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// RmDir3Args (struct RMDIR3args)
+type RmDir3Args struct {
+	Object DirOpArgs3
+}
+
+// RmDir3ResOK (struct RMDIR3resok)
+type RmDir3ResOK struct {
+	DirWcc WccData
+}
+
+// RmDir3ResFail (struct RMDIR3resfail)
+type RmDir3ResFail struct {
+	DirWcc WccData
+}
+
+// RmDir3Res (union RMDIR3res)
+type RmDir3Res struct {
+	Status  uint32        `xdr:"switch"`
+	ResOK   RmDir3ResOK   `xdr:"case=0"`
+	ResFail RmDir3ResFail `xdr:"default"`
+}
+
+// RmDir3 (NFSPROC3_RMDIR) removes an empty subdirectory from a directory.
+// Other objects are removed with REMOVE instead.
+func (nfsService *NFSService) RmDir3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var rmDirArgs RmDir3Args
+
+	_, err := xdr.Unmarshal(arg, &rmDirArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dir, status := nfsService.resolveHandle(rmDirArgs.Object.Dir.Data)
+
+	if status != NFS3OK {
+		return &RmDir3Res{Status: status}, nil
+	}
+
+	status = dir.export.checkName(rmDirArgs.Object.Name)
+
+	if status == NFS3OK {
+		status = callerCredentials(call).checkDelete(dir, rmDirArgs.Object.Name)
+	}
+
+	if status == NFS3OK {
+		err = dir.export.FileSystem.RmDir(dir.fileID, rmDirArgs.Object.Name)
+		status = errorStatus(err)
+	}
+
+	if status != NFS3OK {
+		res := &RmDir3Res{
+			Status: status,
+			ResFail: RmDir3ResFail{
+				DirWcc: dir.export.wccData(dir.attributes, dir.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	res := &RmDir3Res{
+		Status: NFS3OK,
+		ResOK: RmDir3ResOK{
+			DirWcc: dir.export.wccData(dir.attributes, dir.fileID),
+		},
+	}
+	return res, nil
+}
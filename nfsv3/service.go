@@ -4,26 +4,211 @@
 
 package nfsv3
 
-import "github.com/dlorch/base-nfs/rpcv2"
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"github.com/dlorch/base-nfs/rpcv2"
+)
+
+// fileHandleKeySize is the size of the randomly generated key signing file handles
+const fileHandleKeySize = 32
 
 // NFSService ...
 type NFSService struct {
 	rpcv2.RPCService
+	exports        map[uint32]*Export       // exports by ID
+	fileHandleKey  []byte                   // key signing the file handles
+	writeVerifier  [NFS3WriteVerfSize]byte  // changes with every start of the server
+	cookieVerifier [NFS3CookieVerfSize]byte // invalidates directory cookies of previous starts of the server, unless they are stable
+}
+
+// object is a file system object a file handle refers to
+type object struct {
+	export     *Export
+	fileID     uint64
+	attributes FAttr3
 }
 
-// NewNFSv3Service ...
-func NewNFSv3Service() *NFSService {
+// NewNFSv3Service returns an NFS service which serves the given exports. File handles
+// are signed with a random key, so they don't survive a restart unless a persistent key
+// is configured with SetFileHandleKey.
+func NewNFSv3Service(exports ...*Export) *NFSService {
 	nfsService := &NFSService{
-		RPCService: *rpcv2.NewRPCService("nfsv3", Program, Version),
+		RPCService:    *rpcv2.NewRPCService("nfsv3", Program, Version),
+		exports:       make(map[uint32]*Export),
+		fileHandleKey: make([]byte, fileHandleKeySize),
+	}
+
+	_, err := rand.Read(nfsService.fileHandleKey)
+
+	if err != nil {
+		panic(err) // the system's random number generator is broken
+	}
+
+	// clients resend uncommitted data and restart reading directories when the
+	// verifiers change after a restart
+	bootTime := uint64(time.Now().UnixNano())
+	binary.BigEndian.PutUint64(nfsService.writeVerifier[:], bootTime)
+	binary.BigEndian.PutUint64(nfsService.cookieVerifier[:], bootTime)
+
+	// colliding IDs are moved up in the order of the paths rather than of the arguments,
+	// so that reordering the exports doesn't change their IDs
+	sorted := append([]*Export(nil), exports...)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Path < sorted[j].Path
+	})
+
+	for _, export := range sorted {
+		export.id = exportID(export.Path)
+
+		for nfsService.exports[export.id] != nil {
+			export.id++
+		}
+
+		nfsService.exports[export.id] = export
 	}
 
 	nfsService.RegisterProcedure(NFSProcedure3Null, nfsProcedure3Null)
-	nfsService.RegisterProcedure(NFSProcedure3GetAttributes, nfsProcedure3GetAttributes)
-	nfsService.RegisterProcedure(NFSProcedure3Lookup, Lookup3)
-	nfsService.RegisterProcedure(NFSProcedure3Access, nfsProcedure3Access)
-	nfsService.RegisterProcedure(NFSProcedure3FSInfo, nfsProcedure3FSInfo)
-	nfsService.RegisterProcedure(NFSProcedure3PathConf, nfsProcedure3PathConf)
-	nfsService.RegisterProcedure(NFSProcedure3ReadDirPlus, nfsProcedure3ReadDirPlus)
+	nfsService.RegisterProcedure(NFSProcedure3GetAttributes, nfsService.nfsProcedure3GetAttributes)
+	nfsService.RegisterProcedure(NFSProcedure3SetAttributes, nfsService.SetAttr3)
+	nfsService.RegisterProcedure(NFSProcedure3Lookup, nfsService.Lookup3)
+	nfsService.RegisterProcedure(NFSProcedure3Access, nfsService.Access3)
+	nfsService.RegisterProcedure(NFSProcedure3Readlink, nfsService.Readlink3)
+	nfsService.RegisterProcedure(NFSProcedure3Read, nfsService.Read3)
+	nfsService.RegisterProcedure(NFSProcedure3Write, nfsService.Write3)
+	nfsService.RegisterProcedure(NFSProcedure3Create, nfsService.Create3)
+	nfsService.RegisterProcedure(NFSProcedure3MkDir, nfsService.MkDir3)
+	nfsService.RegisterProcedure(NFSProcedure3Symlink, nfsService.Symlink3)
+	nfsService.RegisterProcedure(NFSProcedure3MkNod, nfsService.MkNod3)
+	nfsService.RegisterProcedure(NFSProcedure3Remove, nfsService.Remove3)
+	nfsService.RegisterProcedure(NFSProcedure3RmDir, nfsService.RmDir3)
+	nfsService.RegisterProcedure(NFSProcedure3Rename, nfsService.Rename3)
+	nfsService.RegisterProcedure(NFSProcedure3Link, nfsService.Link3)
+	nfsService.RegisterProcedure(NFSProcedure3FSStat, nfsService.FSStat3)
+	nfsService.RegisterProcedure(NFSProcedure3FSInfo, nfsService.FSInfo3)
+	nfsService.RegisterProcedure(NFSProcedure3PathConf, nfsService.PathConf3)
+	nfsService.RegisterProcedure(NFSProcedure3ReadDir, nfsService.ReadDir3)
+	nfsService.RegisterProcedure(NFSProcedure3ReadDirPlus, nfsService.ReadDirPlus3)
+	nfsService.RegisterProcedure(NFSProcedure3Commit, nfsService.Commit3)
+
+	// retransmits of procedures which aren't idempotent would fail with spurious errors
+	// such as NFS3ErrExist or NFS3ErrNoEnt if executed twice
+	nfsService.SetDuplicateRequestCache(
+		rpcv2.NewDuplicateRequestCache(rpcv2.DefaultDuplicateRequestCacheSize, rpcv2.DefaultDuplicateRequestCacheTTL),
+		NFSProcedure3SetAttributes, NFSProcedure3Write, NFSProcedure3Create, NFSProcedure3MkDir, NFSProcedure3Symlink,
+		NFSProcedure3MkNod, NFSProcedure3Remove, NFSProcedure3RmDir, NFSProcedure3Rename, NFSProcedure3Link,
+	)
 
 	return nfsService
 }
+
+// SetFileHandleKey sets the secret key signing file handles. Configuring the same key
+// across restarts of the server keeps previously handed out file handles valid.
+func (nfsService *NFSService) SetFileHandleKey(key []byte) {
+	nfsService.fileHandleKey = append([]byte(nil), key...)
+}
+
+// Exports returns all exports sorted by path
+func (nfsService *NFSService) Exports() []*Export {
+	exports := make([]*Export, 0, len(nfsService.exports))
+
+	for _, export := range nfsService.exports {
+		exports = append(exports, export)
+	}
+
+	sort.Slice(exports, func(i, j int) bool {
+		return exports[i].Path < exports[j].Path
+	})
+
+	return exports
+}
+
+// MountHandle returns the file handle of the root directory of the export with the given path
+func (nfsService *NFSService) MountHandle(path string) (NFSFH3, uint32) {
+	for _, export := range nfsService.exports {
+		if export.Path == path {
+			fileHandle, _, status := nfsService.fileHandle(export, export.FileSystem.Root())
+			return fileHandle, status
+		}
+	}
+
+	return NFSFH3{}, NFS3ErrNoEnt
+}
+
+// fileHandle returns the file handle and the attributes of an object
+func (nfsService *NFSService) fileHandle(export *Export, fileID uint64) (NFSFH3, FAttr3, uint32) {
+	attributes, err := export.FileSystem.GetAttr(fileID)
+
+	if err != nil {
+		return NFSFH3{}, FAttr3{}, errorStatus(err)
+	}
+
+	fileHandle, status := nfsService.attributesFileHandle(export, attributes)
+
+	return fileHandle, attributes, status
+}
+
+// attributesFileHandle returns the file handle of an object whose attributes are known
+func (nfsService *NFSService) attributesFileHandle(export *Export, attributes FAttr3) (NFSFH3, uint32) {
+	generation, err := export.generation(attributes.FileID)
+
+	if err != nil {
+		return NFSFH3{}, errorStatus(err)
+	}
+
+	fields := fileHandleFields{
+		exportID:   export.id,
+		fsid:       attributes.FSID,
+		fileID:     attributes.FileID,
+		generation: generation,
+	}
+
+	return encodeFileHandle(nfsService.fileHandleKey, fields), NFS3OK
+}
+
+// resolveHandle returns the object a file handle refers to together with its current attributes
+func (nfsService *NFSService) resolveHandle(data []byte) (object, uint32) {
+	fields, status := decodeFileHandle(nfsService.fileHandleKey, data)
+
+	if status != NFS3OK {
+		return object{}, status
+	}
+
+	export, found := nfsService.exports[fields.exportID]
+
+	if !found { // the export was removed
+		return object{}, NFS3ErrStale
+	}
+
+	attributes, err := export.FileSystem.GetAttr(fields.fileID)
+
+	if err != nil {
+		status = errorStatus(err)
+
+		if status == NFS3ErrNoEnt { // the object the handle referred to is gone
+			status = NFS3ErrStale
+		}
+
+		return object{}, status
+	}
+
+	if attributes.FSID != fields.fsid {
+		return object{}, NFS3ErrStale
+	}
+
+	generation, err := export.generation(fields.fileID)
+
+	if err != nil {
+		return object{}, errorStatus(err)
+	}
+
+	if generation != fields.generation { // the FileID was reused by another object
+		return object{}, NFS3ErrStale
+	}
+
+	return object{export: export, fileID: fields.fileID, attributes: attributes}, NFS3OK
+}
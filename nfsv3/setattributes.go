@@ -0,0 +1,95 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Procedure 2: SETATTR - Set file attributes
+// https://tools.ietf.org/html/rfc1813#page-33
+
+package nfsv3
+
+import (
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// SAttrGuard3 (union sattrguard3)
+type SAttrGuard3 struct {
+	Check    uint32   `xdr:"switch"`
+	ObjCTime NFSTime3 `xdr:"case=1"`
+}
+
+// SetAttr3Args (struct SETATTR3args)
+type SetAttr3Args struct {
+	Object        NFSFH3
+	NewAttributes SAttr3
+	Guard         SAttrGuard3
+}
+
+// SetAttr3ResOK (struct SETATTR3resok)
+type SetAttr3ResOK struct {
+	ObjWcc WccData
+}
+
+// SetAttr3ResFail (struct SETATTR3resfail)
+type SetAttr3ResFail struct {
+	ObjWcc WccData
+}
+
+// SetAttr3Res (union SETATTR3res)
+type SetAttr3Res struct {
+	Status  uint32          `xdr:"switch"`
+	ResOK   SetAttr3ResOK   `xdr:"case=0"`
+	ResFail SetAttr3ResFail `xdr:"default"`
+}
+
+// SetAttr3 (NFSPROC3_SETATTR) changes the attributes of an object. If the client
+// sets the guard, the attributes are only changed if the ctime of the object
+// matches, i.e. the object wasn't changed since the client last saw it.
+func (nfsService *NFSService) SetAttr3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var setAttrArgs SetAttr3Args
+
+	_, err := xdr.Unmarshal(arg, &setAttrArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	obj, status := nfsService.resolveHandle(setAttrArgs.Object.Data)
+
+	if status != NFS3OK {
+		return &SetAttr3Res{Status: status}, nil
+	}
+
+	if setAttrArgs.Guard.Check == 1 && setAttrArgs.Guard.ObjCTime != obj.attributes.CTime {
+		status = NFS3ErrNotSync
+	} else {
+		status = callerCredentials(call).checkSetAttr(obj.attributes, setAttrArgs.NewAttributes, obj.export.settings().ChownRestricted)
+	}
+
+	if status == NFS3OK && setAttrArgs.NewAttributes.Size.SetIt == 1 && setAttrArgs.NewAttributes.Size.Size > obj.export.settings().MaxFileSize {
+		status = NFS3ErrFBig
+	}
+
+	if status == NFS3OK {
+		err = obj.export.FileSystem.SetAttr(obj.fileID, setAttrArgs.NewAttributes)
+		status = errorStatus(err)
+	}
+
+	if status != NFS3OK {
+		res := &SetAttr3Res{
+			Status: status,
+			ResFail: SetAttr3ResFail{
+				ObjWcc: obj.export.wccData(obj.attributes, obj.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	res := &SetAttr3Res{
+		Status: NFS3OK,
+		ResOK: SetAttr3ResOK{
+			ObjWcc: obj.export.wccData(obj.attributes, obj.fileID),
+		},
+	}
+	return res, nil
+}
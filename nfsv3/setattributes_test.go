@@ -0,0 +1,86 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nfsv3_test
+
+import (
+	"testing"
+
+	"github.com/dlorch/base-nfs/nfsv3"
+)
+
+func TestSetAttr(t *testing.T) {
+	nfsService, fileSystem, rootHandle := newService(t)
+
+	fileID, _ := fileSystem.Create(fileSystem.Root(), "file", nfsv3.SAttr3{})
+	_, _ = fileSystem.Write(fileID, 0, []byte("Hello, NFS"))
+	file := lookup(t, nfsService, rootHandle, "file").ResOK.Object
+
+	args := nfsv3.SetAttr3Args{Object: file}
+	args.NewAttributes.Mode = nfsv3.SetMode3{SetIt: 1, Mode: 0640}
+	args.NewAttributes.UID = nfsv3.SetUID3{SetIt: 1, UID: 1000}
+	args.NewAttributes.GID = nfsv3.SetGID3{SetIt: 1, GID: 100}
+	args.NewAttributes.Size = nfsv3.SetSize3{SetIt: 1, Size: 5}
+	args.NewAttributes.ATime = nfsv3.SetATime{SetIt: nfsv3.SetToClientTime, ATime: nfsv3.NFSTime3{Seconds: 1000}}
+	args.NewAttributes.MTime = nfsv3.SetMTime{SetIt: nfsv3.SetToClientTime, MTime: nfsv3.NFSTime3{Seconds: 2000}}
+
+	res := call(t, nfsService.SetAttr3, args).(*nfsv3.SetAttr3Res)
+	if res.Status != nfsv3.NFS3OK {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3OK, res.Status)
+	}
+
+	before := res.ResOK.ObjWcc.Before.ObjectAttributes
+	after := res.ResOK.ObjWcc.After.ObjectAttributes
+	if before.Size != 10 || after.Size != 5 || after.Mode != 0640 || after.UID != 1000 || after.GID != 100 {
+		t.Fatalf("Unexpected attributes %+v", after)
+	}
+	if after.ATime.Seconds != 1000 || after.MTime.Seconds != 2000 {
+		t.Fatalf("Expected atime 1000 and mtime 2000 but got %+v and %+v", after.ATime, after.MTime)
+	}
+
+	// extend the file, guarded by the current ctime
+	args = nfsv3.SetAttr3Args{Object: file}
+	args.NewAttributes.Size = nfsv3.SetSize3{SetIt: 1, Size: 8}
+	args.NewAttributes.MTime = nfsv3.SetMTime{SetIt: nfsv3.SetToServerTime}
+	args.Guard = nfsv3.SAttrGuard3{Check: 1, ObjCTime: after.CTime}
+
+	res = call(t, nfsService.SetAttr3, args).(*nfsv3.SetAttr3Res)
+	if res.Status != nfsv3.NFS3OK || res.ResOK.ObjWcc.After.ObjectAttributes.Size != 8 || res.ResOK.ObjWcc.After.ObjectAttributes.MTime.Seconds == 2000 {
+		t.Fatalf("Unexpected result %+v", res)
+	}
+
+	got := read(t, nfsService, file, 0, 100)
+	if string(got.ResOK.Data) != "Hello\x00\x00\x00" {
+		t.Fatalf("Expected %q but got %q", "Hello\x00\x00\x00", got.ResOK.Data)
+	}
+
+	// the guard no longer matches
+	res = call(t, nfsService.SetAttr3, args).(*nfsv3.SetAttr3Res)
+	if res.Status != nfsv3.NFS3ErrNotSync || res.ResFail.ObjWcc.After.ObjectAttributes.Size != 8 {
+		t.Fatalf("Expected %v but got %+v", nfsv3.NFS3ErrNotSync, res)
+	}
+
+	args = nfsv3.SetAttr3Args{Object: rootHandle}
+	args.NewAttributes.Size = nfsv3.SetSize3{SetIt: 1, Size: 0}
+
+	res = call(t, nfsService.SetAttr3, args).(*nfsv3.SetAttr3Res)
+	if res.Status != nfsv3.NFS3ErrIsDir {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrIsDir, res.Status)
+	}
+}
+
+func TestSetAttrFileTooBig(t *testing.T) {
+	nfsService, fileSystem, rootHandle := newService(t)
+
+	_, _ = fileSystem.Create(fileSystem.Root(), "file", nfsv3.SAttr3{})
+	file := lookup(t, nfsService, rootHandle, "file").ResOK.Object
+
+	args := nfsv3.SetAttr3Args{Object: file}
+	args.NewAttributes.Size = nfsv3.SetSize3{SetIt: 1, Size: ^uint64(0)}
+
+	res := call(t, nfsService.SetAttr3, args).(*nfsv3.SetAttr3Res)
+	if res.Status != nfsv3.NFS3ErrFBig || res.ResFail.ObjWcc.After.ObjectAttributes.Size != 0 {
+		t.Fatalf("Expected %v but got %+v", nfsv3.NFS3ErrFBig, res)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Procedure 10: SYMLINK - Create a symbolic link
+// https://tools.ietf.org/html/rfc1813#page-61
+
+package nfsv3
+
+import (
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// SymlinkData3 (struct symlinkdata3)
+type SymlinkData3 struct {
+	SymlinkAttributes SAttr3
+	SymlinkData       string
+}
+
+// Symlink3Args (struct SYMLINK3args)
+type Symlink3Args struct {
+	Where   DirOpArgs3
+	Symlink SymlinkData3
+}
+
+// Symlink3ResOK (struct SYMLINK3resok)
+type Symlink3ResOK struct {
+	Obj           PostOpFH3
+	ObjAttributes PostOpAttr
+	DirWcc        WccData
+}
+
+// Symlink3ResFail (struct SYMLINK3resfail)
+type Symlink3ResFail struct {
+	DirWcc WccData
+}
+
+// Symlink3Res (union SYMLINK3res)
+type Symlink3Res struct {
+	Status  uint32          `xdr:"switch"`
+	ResOK   Symlink3ResOK   `xdr:"case=0"`
+	ResFail Symlink3ResFail `xdr:"default"`
+}
+
+// Symlink3 (NFSPROC3_SYMLINK) creates a new symbolic link
+func (nfsService *NFSService) Symlink3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var symlinkArgs Symlink3Args
+
+	_, err := xdr.Unmarshal(arg, &symlinkArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dir, status := nfsService.resolveHandle(symlinkArgs.Where.Dir.Data)
+
+	if status != NFS3OK {
+		return &Symlink3Res{Status: status}, nil
+	}
+
+	var fileID uint64
+	var createAttributes SAttr3
+
+	status = dir.export.checkName(symlinkArgs.Where.Name)
+
+	if status == NFS3OK {
+		createAttributes, status = callerCredentials(call).checkCreate(dir.attributes, symlinkArgs.Symlink.SymlinkAttributes)
+	}
+
+	if status == NFS3OK {
+		fileID, err = dir.export.FileSystem.Symlink(dir.fileID, symlinkArgs.Where.Name, symlinkArgs.Symlink.SymlinkData, createAttributes)
+		status = errorStatus(err)
+	}
+
+	if status != NFS3OK {
+		res := &Symlink3Res{
+			Status: status,
+			ResFail: Symlink3ResFail{
+				DirWcc: dir.export.wccData(dir.attributes, dir.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	res := &Symlink3Res{
+		Status: NFS3OK,
+		ResOK: Symlink3ResOK{
+			DirWcc: dir.export.wccData(dir.attributes, dir.fileID),
+		},
+	}
+
+	fileHandle, attributes, status := nfsService.fileHandle(dir.export, fileID)
+
+	if status == NFS3OK {
+		res.ResOK.Obj = PostOpFH3{HandleFollows: 1, Handle: fileHandle}
+		res.ResOK.ObjAttributes = PostOpAttr{AttributesFollow: 1, ObjectAttributes: attributes}
+	}
+
+	return res, nil
+}
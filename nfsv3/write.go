@@ -0,0 +1,123 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Procedure 7: WRITE - Write to file
+// https://tools.ietf.org/html/rfc1813#page-49
+
+package nfsv3
+
+import (
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// Write3Args (struct WRITE3args)
+type Write3Args struct {
+	File   NFSFH3
+	Offset uint64
+	Count  uint32
+	Stable uint32
+	Data   []byte
+}
+
+// Write3ResOK (struct WRITE3resok)
+type Write3ResOK struct {
+	FileWcc   WccData
+	Count     uint32
+	Committed uint32
+	Verf      [NFS3WriteVerfSize]byte
+}
+
+// Write3ResFail (struct WRITE3resfail)
+type Write3ResFail struct {
+	FileWcc WccData
+}
+
+// Write3Res (union WRITE3res)
+type Write3Res struct {
+	Status  uint32        `xdr:"switch"`
+	ResOK   Write3ResOK   `xdr:"case=0"`
+	ResFail Write3ResFail `xdr:"default"`
+}
+
+// Write3 (NFSPROC3_WRITE) writes data to a file. Unstable writes are committed
+// to stable storage by a subsequent COMMIT, while DATA_SYNC and FILE_SYNC writes
+// are committed before replying. Requests larger than the advertised wtmax are
+// shortened, which clients handle like any other short write.
+func (nfsService *NFSService) Write3(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+	var writeArgs Write3Args
+
+	_, err := xdr.Unmarshal(arg, &writeArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	file, status := nfsService.resolveHandle(writeArgs.File.Data)
+
+	if status != NFS3OK {
+		return &Write3Res{Status: status}, nil
+	}
+
+	status = callerCredentials(call).checkWrite(file.attributes)
+
+	if status == NFS3OK && (writeArgs.Stable > FileSync || uint32(len(writeArgs.Data)) < writeArgs.Count) {
+		status = NFS3ErrInval
+	}
+
+	if status != NFS3OK {
+		res := &Write3Res{
+			Status: status,
+			ResFail: Write3ResFail{
+				FileWcc: file.export.wccData(file.attributes, file.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	data := writeArgs.Data[:writeArgs.Count]
+
+	if uint32(len(data)) > file.export.settings().WriteMax {
+		data = data[:file.export.settings().WriteMax]
+	}
+
+	maxFileSize := file.export.settings().MaxFileSize
+
+	if uint64(len(data)) > maxFileSize || writeArgs.Offset > maxFileSize-uint64(len(data)) { // Offset+len(data) could overflow
+		res := &Write3Res{
+			Status: NFS3ErrFBig,
+			ResFail: Write3ResFail{
+				FileWcc: file.export.wccData(file.attributes, file.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	count, err := file.export.FileSystem.Write(file.fileID, writeArgs.Offset, data)
+
+	if err == nil && writeArgs.Stable != Unstable {
+		err = file.export.FileSystem.Commit(file.fileID, writeArgs.Offset, count)
+	}
+
+	if err != nil {
+		res := &Write3Res{
+			Status: errorStatus(err),
+			ResFail: Write3ResFail{
+				FileWcc: file.export.wccData(file.attributes, file.fileID),
+			},
+		}
+		return res, nil
+	}
+
+	res := &Write3Res{
+		Status: NFS3OK,
+		ResOK: Write3ResOK{
+			FileWcc:   file.export.wccData(file.attributes, file.fileID),
+			Count:     count,
+			Committed: writeArgs.Stable,
+			Verf:      nfsService.writeVerifier,
+		},
+	}
+	return res, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nfsv3_test
+
+import (
+	"testing"
+
+	"github.com/dlorch/base-nfs/nfsv3"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+func write(t *testing.T, nfsService *nfsv3.NFSService, args nfsv3.Write3Args) *nfsv3.Write3Res {
+	data, err := xdr.Marshal(args)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err := nfsService.Write3(rootCall, data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return res.(*nfsv3.Write3Res)
+}
+
+func commit(t *testing.T, nfsService *nfsv3.NFSService, file nfsv3.NFSFH3) *nfsv3.Commit3Res {
+	data, err := xdr.Marshal(nfsv3.Commit3Args{File: file})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err := nfsService.Commit3(rootCall, data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return res.(*nfsv3.Commit3Res)
+}
+
+func TestWrite(t *testing.T) {
+	nfsService, fileSystem, rootHandle := newService(t)
+
+	_, _ = fileSystem.Create(fileSystem.Root(), "file", nfsv3.SAttr3{})
+	file := lookup(t, nfsService, rootHandle, "file").ResOK.Object
+
+	res := write(t, nfsService, nfsv3.Write3Args{File: file, Offset: 0, Count: 5, Stable: nfsv3.Unstable, Data: []byte("Hello")})
+	if res.Status != nfsv3.NFS3OK || res.ResOK.Count != 5 || res.ResOK.Committed != nfsv3.Unstable {
+		t.Fatalf("Unexpected result %+v", res)
+	}
+	if res.ResOK.FileWcc.Before.ObjectAttributes.Size != 0 || res.ResOK.FileWcc.After.ObjectAttributes.Size != 5 {
+		t.Fatalf("Expected size to change from 0 to 5 but got %+v", res.ResOK.FileWcc)
+	}
+
+	verifier := res.ResOK.Verf
+
+	res = write(t, nfsService, nfsv3.Write3Args{File: file, Offset: 5, Count: 5, Stable: nfsv3.FileSync, Data: []byte(", NFS")})
+	if res.Status != nfsv3.NFS3OK || res.ResOK.Committed != nfsv3.FileSync || res.ResOK.Verf != verifier {
+		t.Fatalf("Unexpected result %+v", res)
+	}
+
+	commitRes := commit(t, nfsService, file)
+	if commitRes.Status != nfsv3.NFS3OK || commitRes.ResOK.Verf != verifier {
+		t.Fatalf("Expected verifier %v but got %+v", verifier, commitRes)
+	}
+
+	got := read(t, nfsService, file, 0, 100)
+	if string(got.ResOK.Data) != "Hello, NFS" {
+		t.Fatalf("Expected %q but got %q", "Hello, NFS", got.ResOK.Data)
+	}
+
+	res = write(t, nfsService, nfsv3.Write3Args{File: file, Count: 10, Stable: nfsv3.Unstable, Data: []byte("short")})
+	if res.Status != nfsv3.NFS3ErrInval {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrInval, res.Status)
+	}
+
+	res = write(t, nfsService, nfsv3.Write3Args{File: rootHandle, Count: 1, Stable: nfsv3.Unstable, Data: []byte("x")})
+	if res.Status != nfsv3.NFS3ErrIsDir {
+		t.Fatalf("Expected %v but got %v", nfsv3.NFS3ErrIsDir, res.Status)
+	}
+}
+
+func TestWriteFileTooBig(t *testing.T) {
+	nfsService, fileSystem, rootHandle := newService(t)
+
+	_, _ = fileSystem.Create(fileSystem.Root(), "file", nfsv3.SAttr3{})
+	file := lookup(t, nfsService, rootHandle, "file").ResOK.Object
+
+	// the end of the write doesn't fit into 64 bits
+	res := write(t, nfsService, nfsv3.Write3Args{File: file, Offset: ^uint64(0), Count: 5, Stable: nfsv3.Unstable, Data: []byte("Hello")})
+	if res.Status != nfsv3.NFS3ErrFBig || res.ResFail.FileWcc.After.ObjectAttributes.Size != 0 {
+		t.Fatalf("Expected %v but got %+v", nfsv3.NFS3ErrFBig, res)
+	}
+}
+
+// TestWriteVerifier verifies that a restarted server hands out a different write
+// verifier, so that clients resend their uncommitted data
+func TestWriteVerifier(t *testing.T) {
+	nfsService, _, rootHandle := newService(t)
+	restarted, _, restartedRootHandle := newService(t)
+
+	verifier := commit(t, nfsService, rootHandle).ResOK.Verf
+	restartedVerifier := commit(t, restarted, restartedRootHandle).ResOK.Verf
+
+	if verifier == restartedVerifier {
+		t.Fatalf("Expected verifiers to differ but got %v", verifier)
+	}
+}
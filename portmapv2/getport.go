@@ -7,6 +7,8 @@ package portmapv2
 import (
 	"bytes"
 	"encoding/binary"
+
+	"github.com/dlorch/base-nfs/rpcv2"
 )
 
 // GetPortResult represents the requested port number
@@ -22,7 +24,7 @@ type Mapping struct {
 	Port     uint32
 }
 
-func procedureGetPort(procedureArguments []byte) (interface{}, error) {
+func (portmapService *PortmapService) procedureGetPort(call *rpcv2.CallContext, procedureArguments []byte) (interface{}, error) {
 	var requestBody = bytes.NewBuffer(procedureArguments)
 	var mapping Mapping
 
@@ -32,9 +34,7 @@ func procedureGetPort(procedureArguments []byte) (interface{}, error) {
 		return &GetPortResult{Port: ProgramNotAvailable}, err
 	}
 
-	// TODO check callBody.Version == portmapv2.Version
-
-	port := getPort(mapping)
+	port := portmapService.getPort(mapping)
 
 	return &GetPortResult{Port: port}, nil
 }
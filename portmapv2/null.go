@@ -4,9 +4,11 @@
 
 package portmapv2
 
+import "github.com/dlorch/base-nfs/rpcv2"
+
 // VoidReply is an empty reply
 type VoidReply struct{}
 
-func procedureNull(procedureArguments []byte) (interface{}, error) {
+func procedureNull(call *rpcv2.CallContext, procedureArguments []byte) (interface{}, error) {
 	return &VoidReply{}, nil
 }
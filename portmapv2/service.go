@@ -5,7 +5,7 @@
 package portmapv2
 
 import (
-	"fmt"
+	"sync"
 
 	"github.com/dlorch/base-nfs/mountv3"
 	"github.com/dlorch/base-nfs/nfsv3"
@@ -15,34 +15,50 @@ import (
 // PortmapService ...
 type PortmapService struct {
 	rpcv2.RPCService
+	mutex    sync.RWMutex       // protects mappings
+	mappings map[Mapping]uint32 // ports by mapping without port
 }
 
-// TODO register service with portmapper
-
-// NewPortmapService ...
+// NewPortmapService returns a port mapper reporting the default ports of the NFS
+// and mount services, which can be changed with SetPort
 func NewPortmapService() *PortmapService {
 	portmapService := &PortmapService{
 		RPCService: *rpcv2.NewRPCService("portmap", Program, Version),
+		mappings:   make(map[Mapping]uint32),
 	}
 
+	portmapService.SetPort(mountv3.Program, mountv3.Version1, IPProtocolTCP, 892)
+	portmapService.SetPort(mountv3.Program, mountv3.Version, IPProtocolTCP, 892)
+	portmapService.SetPort(nfsv3.Program, nfsv3.Version, IPProtocolTCP, 2049)
+
 	portmapService.RegisterProcedure(PortmapProcedureNull, procedureNull)
-	portmapService.RegisterProcedure(PortmapProcedureGetPort, procedureGetPort)
+	portmapService.RegisterProcedure(PortmapProcedureGetPort, portmapService.procedureGetPort)
 
 	return portmapService
 }
 
-func getPort(mapping Mapping) uint32 {
-	fmt.Println("getPort")
-	fmt.Println(mapping.Program)
-	fmt.Println(mapping.Protocol)
-	fmt.Println(mapping.Version)
-
-	// TODO check mapping.Version (1) == mountv3.Version (3)
-	if mapping.Program == mountv3.Program && mapping.Protocol == IPProtocolTCP {
-		return 892
-	} else if mapping.Program == nfsv3.Program && mapping.Protocol == IPProtocolTCP && mapping.Version == nfsv3.Version {
-		return 2049
+// SetPort sets the port on which a version of a program is served over protocol
+// (IPProtocolTCP or IPProtocolUDP). A port of ProgramNotAvailable removes the mapping.
+func (portmapService *PortmapService) SetPort(program uint32, version uint32, protocol uint32, port uint32) {
+	portmapService.mutex.Lock()
+	defer portmapService.mutex.Unlock()
+
+	mapping := Mapping{Program: program, Version: version, Protocol: protocol}
+
+	if port == ProgramNotAvailable {
+		delete(portmapService.mappings, mapping)
+		return
 	}
 
-	return ProgramNotAvailable
+	portmapService.mappings[mapping] = port
+}
+
+// getPort returns the port of a mapping, or ProgramNotAvailable if there is none
+func (portmapService *PortmapService) getPort(mapping Mapping) uint32 {
+	portmapService.mutex.RLock()
+	defer portmapService.mutex.RUnlock()
+
+	mapping.Port = 0
+
+	return portmapService.mappings[mapping]
 }
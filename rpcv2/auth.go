@@ -0,0 +1,203 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpcv2
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// authShortHandleSize is the size of the AUTH_SHORT handles issued by UnixAuthenticator
+const authShortHandleSize = 8
+
+// Authenticator verifies the credentials of calls of an authentication flavor. It is
+// registered with RPCService.RegisterAuthenticator for the flavors it accepts.
+type Authenticator interface {
+	// Authenticate verifies the credentials and verifier of a call, and may complete
+	// the call with the identity of the caller. It returns the verifier of the reply,
+	// or why the call is rejected (enum auth_stat).
+	Authenticate(call *CallContext) (OpaqueAuth, uint32)
+}
+
+// authenticationDiscard is returned by authenticators instead of an auth_stat for calls
+// which are to be discarded without reply, e.g. replays
+const authenticationDiscard uint32 = 1<<32 - 1
+
+// callProtection is implemented by authenticators which handle control calls of their
+// flavor themselves, or protect the arguments and results of calls
+type callProtection interface {
+	// control handles a call which isn't passed on to the program, and returns its
+	// results and the verifier of the reply. handled is false for other calls.
+	control(call *CallContext, arguments []byte) (results interface{}, verifier OpaqueAuth, handled bool, err error)
+
+	// unwrapArguments returns the arguments of a call without protection
+	unwrapArguments(call *CallContext, arguments []byte) ([]byte, error)
+
+	// wrapResults returns the results of a call with protection
+	wrapResults(call *CallContext, results interface{}) (interface{}, error)
+}
+
+// nullVerifier is the verifier of replies to callers which aren't authenticated by the server
+var nullVerifier = OpaqueAuth{Flavor: AuthenticationNull, Body: []byte{}}
+
+// NullAuthenticator accepts calls without credentials (AUTH_NULL)
+type NullAuthenticator struct{}
+
+// Authenticate accepts any call with an AUTH_NULL verifier
+func (NullAuthenticator) Authenticate(call *CallContext) (OpaqueAuth, uint32) {
+	if call.Verifier.Flavor != AuthenticationNull {
+		return OpaqueAuth{}, AuthenticationBadVerf
+	}
+
+	return nullVerifier, AuthenticationOK
+}
+
+// UnixAuthenticator accepts AUTH_UNIX credentials and sets CallContext.Unix. It can
+// issue AUTH_SHORT handles in the reply verifier, which clients send instead of their
+// full credentials in subsequent calls. To accept them, the UnixAuthenticator must be
+// registered for AuthenticationShort as well.
+type UnixAuthenticator struct {
+	maxHandles int                       // number of AUTH_SHORT handles kept, zero to issue none
+	mutex      sync.Mutex                // protects handles, byBody and order
+	handles    map[string]*AuthUnixParms // credentials by AUTH_SHORT handle
+	byBody     map[string]string         // AUTH_SHORT handle by body of the AUTH_UNIX credentials
+	order      []string                  // bodies in the order their handles were issued
+}
+
+// NewUnixAuthenticator returns an authenticator for AUTH_UNIX credentials, which issues
+// AUTH_SHORT handles for up to maxHandles different credentials. Once exhausted, the
+// oldest handle is replaced, and clients still using it are asked for their full
+// credentials with AUTH_REJECTEDCRED.
+func NewUnixAuthenticator(maxHandles int) *UnixAuthenticator {
+	return &UnixAuthenticator{
+		maxHandles: maxHandles,
+		handles:    make(map[string]*AuthUnixParms),
+		byBody:     make(map[string]string),
+	}
+}
+
+// Authenticate accepts valid AUTH_UNIX credentials and AUTH_SHORT handles issued for them
+func (unixAuthenticator *UnixAuthenticator) Authenticate(call *CallContext) (OpaqueAuth, uint32) {
+	if call.Verifier.Flavor != AuthenticationNull {
+		return OpaqueAuth{}, AuthenticationBadVerf
+	}
+
+	if call.Credentials.Flavor == AuthenticationShort {
+		unixAuthenticator.mutex.Lock()
+		parms, found := unixAuthenticator.handles[string(call.Credentials.Body)]
+		unixAuthenticator.mutex.Unlock()
+
+		if !found { // the client must send its full credentials again
+			return OpaqueAuth{}, AuthenticationRejectedCred
+		}
+
+		call.Unix = parms
+
+		return nullVerifier, AuthenticationOK
+	}
+
+	parms, err := ParseAuthUnix(call.Credentials.Body)
+
+	if err != nil {
+		fmt.Println("Error: ", err.Error())
+		return OpaqueAuth{}, AuthenticationBadCred
+	}
+
+	call.Unix = parms
+
+	if unixAuthenticator.maxHandles <= 0 {
+		return nullVerifier, AuthenticationOK
+	}
+
+	return OpaqueAuth{Flavor: AuthenticationShort, Body: unixAuthenticator.shortHandle(call.Credentials.Body, parms)}, AuthenticationOK
+}
+
+// shortHandle returns the AUTH_SHORT handle for AUTH_UNIX credentials, issuing a new
+// one if necessary
+func (unixAuthenticator *UnixAuthenticator) shortHandle(body []byte, parms *AuthUnixParms) []byte {
+	unixAuthenticator.mutex.Lock()
+	defer unixAuthenticator.mutex.Unlock()
+
+	handle, found := unixAuthenticator.byBody[string(body)]
+
+	if found {
+		return []byte(handle)
+	}
+
+	if len(unixAuthenticator.order) >= unixAuthenticator.maxHandles { // replace the oldest handle
+		oldest := unixAuthenticator.order[0]
+		unixAuthenticator.order = unixAuthenticator.order[1:]
+		delete(unixAuthenticator.handles, unixAuthenticator.byBody[oldest])
+		delete(unixAuthenticator.byBody, oldest)
+	}
+
+	handleBytes := make([]byte, authShortHandleSize)
+
+	_, err := rand.Read(handleBytes)
+
+	if err != nil {
+		panic(err) // the system's random number generator is broken
+	}
+
+	handle = string(handleBytes)
+	unixAuthenticator.handles[handle] = parms
+	unixAuthenticator.byBody[string(body)] = handle
+	unixAuthenticator.order = append(unixAuthenticator.order, string(body))
+
+	return handleBytes
+}
+
+// errBadCredentials is wrapped by errors about call headers with oversized credentials
+var errBadCredentials = errors.New("bad credentials")
+
+// errBadVerifier is wrapped by errors about call headers with an oversized verifier
+var errBadVerifier = errors.New("bad verifier")
+
+// ParseAuthUnix decodes the body of AUTH_UNIX credentials. Credentials with trailing
+// data or exceeding the limits of authsys_parms are rejected.
+func ParseAuthUnix(body []byte) (*AuthUnixParms, error) {
+	var parms AuthUnixParms
+
+	bytesRead, err := xdr.Unmarshal(body, &parms)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if bytesRead != len(body) {
+		return nil, fmt.Errorf("Invalid length '%d' for AUTH_UNIX credentials, expected '%d'", len(body), bytesRead)
+	}
+
+	if uint32(len(parms.MachineName)) > AuthUnixMachineNameMaxLength {
+		return nil, fmt.Errorf("Invalid length '%d' for machine name in AUTH_UNIX credentials. Maximum value of '%d' allowed", len(parms.MachineName), AuthUnixMachineNameMaxLength)
+	}
+
+	if uint32(len(parms.GIDs)) > AuthUnixGIDsMaxLength {
+		return nil, fmt.Errorf("Invalid number '%d' of groups in AUTH_UNIX credentials. Maximum value of '%d' allowed", len(parms.GIDs), AuthUnixGIDsMaxLength)
+	}
+
+	return &parms, nil
+}
+
+// authenticationError returns a reply rejecting a call for the reason stat (enum auth_stat)
+func authenticationError(xid uint32, stat uint32) ([]byte, error) {
+	authError := &RPCMessage{
+		XID:         xid,
+		MessageType: Reply,
+		RBody: ReplyBody{
+			ReplyStatus: MessageDenied,
+			RReply: RejectedReply{
+				RejectState: AuthenticationError,
+				Stat:        stat,
+			},
+		},
+	}
+
+	return xdr.Marshal(authError)
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpcv2_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+func TestParseAuthUnix(t *testing.T) {
+	expected := &rpcv2.AuthUnixParms{
+		Stamp:       0x5d5a1b2c,
+		MachineName: "client",
+		UID:         1000,
+		GID:         100,
+		GIDs:        []uint32{4, 24, 27},
+	}
+
+	body, err := xdr.Marshal(expected)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	parms, err := rpcv2.ParseAuthUnix(body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !reflect.DeepEqual(parms, expected) {
+		t.Fatalf("Expected %+v but got %+v", expected, parms)
+	}
+}
+
+func TestParseAuthUnixInvalid(t *testing.T) {
+	valid, _ := xdr.Marshal(&rpcv2.AuthUnixParms{MachineName: "client", GIDs: []uint32{}})
+	longName, _ := xdr.Marshal(&rpcv2.AuthUnixParms{MachineName: strings.Repeat("x", 256), GIDs: []uint32{}})
+	manyGroups, _ := xdr.Marshal(&rpcv2.AuthUnixParms{MachineName: "client", GIDs: make([]uint32, 17)})
+
+	tests := [][]byte{
+		valid[:len(valid)-4],              // truncated
+		append(valid, 0, 0, 0, 0),         // trailing data
+		longName,                          // machine name too long
+		manyGroups,                        // too many groups
+		{0, 0, 0, 1, 0xff, 0xff, 0xff, 0}, // machine name exceeds body
+	}
+
+	for i, body := range tests {
+		_, err := rpcv2.ParseAuthUnix(body)
+		if err == nil {
+			t.Fatalf("Expected error but got none (test %d)", i)
+		}
+	}
+}
+
+// unixCall returns a call with the given AUTH_UNIX credentials
+func unixCall(t *testing.T, parms *rpcv2.AuthUnixParms) *rpcv2.CallContext {
+	body, err := xdr.Marshal(parms)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return &rpcv2.CallContext{
+		Credentials: rpcv2.OpaqueAuth{Flavor: rpcv2.AuthenticationUNIX, Body: body},
+		Verifier:    rpcv2.OpaqueAuth{Flavor: rpcv2.AuthenticationNull},
+	}
+}
+
+func TestUnixAuthenticator(t *testing.T) {
+	authenticator := rpcv2.NewUnixAuthenticator(0)
+
+	call := unixCall(t, &rpcv2.AuthUnixParms{MachineName: "client", UID: 1000, GID: 100, GIDs: []uint32{}})
+
+	verifier, stat := authenticator.Authenticate(call)
+	if stat != rpcv2.AuthenticationOK || verifier.Flavor != rpcv2.AuthenticationNull {
+		t.Fatalf("Expected %v but got %v (verifier %+v)", rpcv2.AuthenticationOK, stat, verifier)
+	}
+	if call.Unix == nil || call.Unix.UID != 1000 {
+		t.Fatalf("Expected UID 1000 but got %+v", call.Unix)
+	}
+
+	call.Verifier.Flavor = rpcv2.AuthenticationUNIX
+	_, stat = authenticator.Authenticate(call)
+	if stat != rpcv2.AuthenticationBadVerf {
+		t.Fatalf("Expected %v but got %v", rpcv2.AuthenticationBadVerf, stat)
+	}
+
+	call = unixCall(t, &rpcv2.AuthUnixParms{MachineName: "client", GIDs: []uint32{}})
+	call.Credentials.Body = call.Credentials.Body[:8]
+	_, stat = authenticator.Authenticate(call)
+	if stat != rpcv2.AuthenticationBadCred {
+		t.Fatalf("Expected %v but got %v", rpcv2.AuthenticationBadCred, stat)
+	}
+}
+
+func TestUnixAuthenticatorShortHandles(t *testing.T) {
+	authenticator := rpcv2.NewUnixAuthenticator(2)
+
+	handles := make([]rpcv2.OpaqueAuth, 3)
+
+	for uid := range handles {
+		call := unixCall(t, &rpcv2.AuthUnixParms{MachineName: "client", UID: uint32(uid), GIDs: []uint32{}})
+
+		verifier, stat := authenticator.Authenticate(call)
+		if stat != rpcv2.AuthenticationOK || verifier.Flavor != rpcv2.AuthenticationShort {
+			t.Fatalf("Expected AUTH_SHORT verifier but got %+v (stat %v)", verifier, stat)
+		}
+
+		repeated, _ := authenticator.Authenticate(call)
+		if string(repeated.Body) != string(verifier.Body) {
+			t.Fatalf("Expected the same handle %v but got %v", verifier.Body, repeated.Body)
+		}
+
+		handles[uid] = verifier
+	}
+
+	for uid, handle := range handles {
+		call := &rpcv2.CallContext{Credentials: handle}
+
+		_, stat := authenticator.Authenticate(call)
+
+		switch {
+		case uid == 0 && stat != rpcv2.AuthenticationRejectedCred: // replaced by the handle for UID 2
+			t.Fatalf("Expected %v but got %v", rpcv2.AuthenticationRejectedCred, stat)
+		case uid > 0 && (stat != rpcv2.AuthenticationOK || call.Unix.UID != uint32(uid)):
+			t.Fatalf("Expected UID %d but got %+v (stat %v)", uid, call.Unix, stat)
+		}
+	}
+}
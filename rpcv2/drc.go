@@ -0,0 +1,169 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpcv2
+
+import (
+	"container/list"
+	"hash/crc32"
+	"sync"
+	"time"
+)
+
+// Defaults of the duplicate request cache
+const (
+	DefaultDuplicateRequestCacheSize = 1024              // number of calls remembered
+	DefaultDuplicateRequestCacheTTL  = 120 * time.Second // time calls are remembered
+)
+
+// DuplicateRequestCache remembers the results of calls, so that retransmitted calls
+// of non-idempotent procedures aren't executed twice. Retransmits of completed calls
+// get a reply with the cached results, and retransmits of calls still in progress are
+// dropped.
+type DuplicateRequestCache struct {
+	size    int
+	ttl     time.Duration
+	mutex   sync.Mutex // protects all fields below
+	entries map[duplicateRequestKey]*list.Element
+	order   *list.List // entries, least recently updated first
+	stats   DuplicateRequestCacheStats
+}
+
+// DuplicateRequestCacheStats counts the lookups in a duplicate request cache
+type DuplicateRequestCacheStats struct {
+	Hits       uint64 // retransmits answered with the cached results
+	InProgress uint64 // retransmits dropped because the call was still in progress
+	Misses     uint64 // calls seen for the first time
+}
+
+// duplicateRequestKey identifies a call and its retransmits
+type duplicateRequestKey struct {
+	xid            uint32
+	clientAddress  string
+	program        uint32
+	programVersion uint32
+	procedure      uint32
+	checksum       uint32 // of the arguments, tells apart calls reusing an XID
+}
+
+// duplicateRequest is a call remembered by the cache
+type duplicateRequest struct {
+	key     duplicateRequestKey
+	results interface{} // nil while the call is in progress
+	updated time.Time
+}
+
+// NewDuplicateRequestCache returns a cache remembering the results of the last size
+// calls for ttl
+func NewDuplicateRequestCache(size int, ttl time.Duration) *DuplicateRequestCache {
+	return &DuplicateRequestCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[duplicateRequestKey]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Stats returns the number of hits and misses so far
+func (cache *DuplicateRequestCache) Stats() DuplicateRequestCacheStats {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	return cache.stats
+}
+
+// start looks up a call. It returns whether the call was seen before, and if so,
+// whether it completed and its cached results. Calls seen for the first time are
+// remembered as in progress until finish is called.
+func (cache *DuplicateRequestCache) start(key duplicateRequestKey) (results interface{}, completed bool, seen bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	now := time.Now()
+	cache.expire(now)
+
+	element, found := cache.entries[key]
+
+	if found {
+		results := element.Value.(*duplicateRequest).results
+
+		if results == nil {
+			cache.stats.InProgress++
+		} else {
+			cache.stats.Hits++
+		}
+
+		return results, results != nil, true
+	}
+
+	cache.stats.Misses++
+
+	for cache.order.Len() >= cache.size && cache.order.Len() > 0 { // forget the oldest calls
+		cache.remove(cache.order.Front())
+	}
+
+	if cache.size > 0 {
+		cache.entries[key] = cache.order.PushBack(&duplicateRequest{key: key, updated: now})
+	}
+
+	return nil, false, false
+}
+
+// finish remembers the results of a call. Nil results forget the call, so that it is
+// executed again when retransmitted.
+func (cache *DuplicateRequestCache) finish(key duplicateRequestKey, results interface{}) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	element, found := cache.entries[key]
+
+	if !found { // evicted in the meantime
+		return
+	}
+
+	if results == nil {
+		cache.remove(element)
+		return
+	}
+
+	request := element.Value.(*duplicateRequest)
+	request.results = results
+	request.updated = time.Now()
+	cache.order.MoveToBack(element)
+}
+
+// expire forgets calls not updated within the TTL
+func (cache *DuplicateRequestCache) expire(now time.Time) {
+	for element := cache.order.Front(); element != nil; element = cache.order.Front() {
+		if now.Sub(element.Value.(*duplicateRequest).updated) < cache.ttl {
+			return
+		}
+
+		cache.remove(element)
+	}
+}
+
+// remove forgets a call
+func (cache *DuplicateRequestCache) remove(element *list.Element) {
+	delete(cache.entries, element.Value.(*duplicateRequest).key)
+	cache.order.Remove(element)
+}
+
+// newDuplicateRequestKey returns the key identifying a call in the duplicate request cache
+func newDuplicateRequestKey(call *CallContext, arguments []byte) duplicateRequestKey {
+	var clientAddress string
+
+	if call.RemoteAddr != nil {
+		clientAddress = call.RemoteAddr.String()
+	}
+
+	return duplicateRequestKey{
+		xid:            call.XID,
+		clientAddress:  clientAddress,
+		program:        call.Program,
+		programVersion: call.ProgramVersion,
+		procedure:      call.Procedure,
+		checksum:       crc32.ChecksumIEEE(arguments),
+	}
+}
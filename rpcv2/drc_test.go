@@ -0,0 +1,161 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpcv2_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// newCachingService returns a connection to a service caching the replies of its test
+// procedure, which returns the number of times it was executed. Calls with XID 5 block
+// until release is closed.
+func newCachingService(cache *rpcv2.DuplicateRequestCache) (net.Conn, chan struct{}) {
+	var executed uint32
+
+	release := make(chan struct{})
+
+	rpcService := rpcv2.NewRPCService("test", testProgram, testVersion)
+	rpcService.SetDuplicateRequestCache(cache, testProcedure)
+	rpcService.RegisterProcedure(testProcedure, func(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+		if call.XID == 5 {
+			<-release
+		}
+
+		executed++
+
+		return executed, nil
+	})
+
+	serverConn, clientConn := net.Pipe()
+
+	go rpcService.ServeConn(serverConn)
+
+	return clientConn, release
+}
+
+// executions returns the result of a call to the test procedure of a caching service
+func executions(t *testing.T, conn net.Conn, xid uint32, arguments []byte) uint32 {
+	writeCall(t, conn, nullCall(xid, testProcedure), arguments)
+
+	_, results := readReply(t, conn, xid)
+
+	return binary.BigEndian.Uint32(results)
+}
+
+func TestDuplicateRequestCache(t *testing.T) {
+	cache := rpcv2.NewDuplicateRequestCache(rpcv2.DefaultDuplicateRequestCacheSize, rpcv2.DefaultDuplicateRequestCacheTTL)
+	conn, release := newCachingService(cache)
+	defer conn.Close()
+
+	tests := []struct {
+		xid       uint32
+		arguments []byte
+		executed  uint32
+	}{
+		{1, []byte{0, 0, 0, 1}, 1},
+		{1, []byte{0, 0, 0, 1}, 1}, // retransmit
+		{2, []byte{0, 0, 0, 1}, 2},
+		{1, []byte{0, 0, 0, 2}, 3}, // XID reused with other arguments
+	}
+
+	for i, test := range tests {
+		if executed := executions(t, conn, test.xid, test.arguments); executed != test.executed {
+			t.Fatalf("Expected %v but got %v (test %d)", test.executed, executed, i)
+		}
+	}
+
+	// retransmits of calls in progress are dropped
+	writeCall(t, conn, nullCall(5, testProcedure), nil)
+	writeCall(t, conn, nullCall(5, testProcedure), nil)
+	time.Sleep(50 * time.Millisecond) // let the retransmit be dropped before the call completes
+	close(release)
+
+	if xid := readXID(t, conn); xid != 5 {
+		t.Fatalf("Expected %v but got %v", 5, xid)
+	}
+
+	expected := rpcv2.DuplicateRequestCacheStats{Hits: 1, InProgress: 1, Misses: 4}
+	if stats := cache.Stats(); stats != expected {
+		t.Fatalf("Expected %+v but got %+v", expected, stats)
+	}
+}
+
+func TestDuplicateRequestCacheTTL(t *testing.T) {
+	conn, _ := newCachingService(rpcv2.NewDuplicateRequestCache(rpcv2.DefaultDuplicateRequestCacheSize, time.Millisecond))
+	defer conn.Close()
+
+	executions(t, conn, 1, nil)
+	time.Sleep(10 * time.Millisecond)
+
+	if executed := executions(t, conn, 1, nil); executed != 2 {
+		t.Fatalf("Expected %v but got %v", 2, executed)
+	}
+}
+
+func TestDuplicateRequestCacheGSS(t *testing.T) {
+	var executed uint32
+
+	cache := rpcv2.NewDuplicateRequestCache(rpcv2.DefaultDuplicateRequestCacheSize, rpcv2.DefaultDuplicateRequestCacheTTL)
+
+	rpcService := rpcv2.NewRPCService("test", testProgram, testVersion)
+	rpcService.RegisterAuthenticator(rpcv2.AuthenticationGSS, rpcv2.NewGSSAuthenticator(fakeMechanism{}, 4))
+	rpcService.SetDuplicateRequestCache(cache, testProcedure)
+	rpcService.RegisterProcedure(0, func(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+		return rpcv2.Void{}, nil
+	})
+	rpcService.RegisterProcedure(testProcedure, func(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+		executed++
+
+		return executed, nil
+	})
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	go rpcService.ServeConn(serverConn)
+
+	client := &gssClient{t: t, conn: clientConn, context: &fakeContext{key: []byte("session key")}}
+	client.establish()
+
+	// a retransmit carries a new sequence number, and gets the cached results protected
+	// for it
+	for _, seqNum := range []uint32{1, 2} {
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint32(data, seqNum)
+		mic, _ := client.context.GetMIC(data)
+		arguments, _ := xdr.Marshal(&rpcv2.GSSIntegData{Body: data, Checksum: mic})
+
+		if seqNum == 2 {
+			client.xid-- // same XID as the original call
+		}
+
+		reply, results := client.call(testProcedure, client.data(seqNum, rpcv2.GSSServiceIntegrity), true, arguments)
+		if reply.ReplyStatus != rpcv2.MessageAccepted || reply.Accepted.AcceptState != rpcv2.Success {
+			t.Fatalf("Expected %v but got %+v", rpcv2.Success, reply)
+		}
+
+		var integData rpcv2.GSSIntegData
+		xdr.Unmarshal(results, &integData)
+
+		if client.context.VerifyMIC(integData.Body, integData.Checksum) != nil || binary.BigEndian.Uint32(integData.Body) != seqNum {
+			t.Fatalf("Expected results protected for sequence number %v but got %+v", seqNum, integData)
+		}
+
+		if got := binary.BigEndian.Uint32(integData.Body[4:]); got != 1 {
+			t.Fatalf("Expected %v but got %v", 1, got)
+		}
+	}
+
+	expected := rpcv2.DuplicateRequestCacheStats{Hits: 1, Misses: 1}
+	if stats := cache.Stats(); stats != expected {
+		t.Fatalf("Expected %+v but got %+v", expected, stats)
+	}
+}
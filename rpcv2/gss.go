@@ -0,0 +1,573 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpcv2
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// gssHandleSize is the size of the context handles issued by GSSAuthenticator
+const gssHandleSize = 16
+
+// defaultGSSSeqWindow is the size of the sequence window if none is configured
+const defaultGSSSeqWindow uint32 = 128
+
+// Limits of the contexts being established, which anyone can create
+const (
+	gssMaxPendingContexts = 256              // number of contexts being established at once
+	gssPendingTimeout     = 30 * time.Second // time a context may take to be established
+)
+
+// Defaults of the limits of established contexts, which clients often don't destroy
+const (
+	DefaultGSSMaxContexts = 4096      // number of established contexts kept at once
+	DefaultGSSIdleTimeout = time.Hour // time an established context is kept without being used
+)
+
+// GSSMechanism establishes security contexts with clients for RPCSEC_GSS, e.g.
+// Kerberos V5. It corresponds to GSS_Accept_sec_context of the GSS-API (RFC2743).
+type GSSMechanism interface {
+	// AcceptSecContext processes a token sent by the client. secContext is nil for the
+	// first token of a context and the context returned for the previous token otherwise.
+	// It returns the context, the token to be sent back (possibly empty), and the GSS
+	// major and minor status. The context is established once major is GSSComplete.
+	AcceptSecContext(secContext GSSContext, token []byte) (GSSContext, []byte, uint32, uint32)
+}
+
+// GSSContext is a security context established by a GSSMechanism. Its methods are
+// called concurrently for the calls of a client.
+type GSSContext interface {
+	// Principal returns the name of the client, e.g. "alice@EXAMPLE.COM"
+	Principal() string
+
+	// GetMIC returns a checksum of message (GSS_GetMIC)
+	GetMIC(message []byte) ([]byte, error)
+
+	// VerifyMIC returns an error unless mic is a checksum of message (GSS_VerifyMIC)
+	VerifyMIC(message []byte, mic []byte) error
+
+	// Wrap encrypts and protects the integrity of message (GSS_Wrap)
+	Wrap(message []byte) ([]byte, error)
+
+	// Unwrap decrypts a message produced by the Wrap of the client (GSS_Unwrap)
+	Unwrap(message []byte) ([]byte, error)
+}
+
+// GSSAuthenticator accepts RPCSEC_GSS credentials (RFC2203). It handles the control
+// procedures creating and destroying contexts, discards replayed calls, and protects
+// arguments and results of calls using the integrity or privacy service. Callers are
+// identified by CallContext.Principal.
+type GSSAuthenticator struct {
+	mechanism   GSSMechanism
+	seqWindow   uint32                 // number of calls which may be in flight
+	maxContexts int                    // number of established contexts kept at once
+	idleTimeout time.Duration          // time an established context is kept without being used
+	mutex       sync.Mutex             // protects contexts, pending and established
+	contexts    map[string]*gssContext // contexts by handle
+	pending     int                    // number of contexts not established yet
+	established *list.List             // established contexts, least recently used first
+}
+
+// gssContext is the state of a context between server and client
+type gssContext struct {
+	handle      []byte
+	created     time.Time
+	element     *list.Element // in GSSAuthenticator.established once established
+	lastUsed    time.Time     // protected by the mutex of the GSSAuthenticator, like element
+	mutex       sync.Mutex    // protects secContext, established and window
+	secContext  GSSContext
+	established bool
+	window      sequenceWindow
+}
+
+// gssCall is the state of a call authenticated with RPCSEC_GSS
+type gssCall struct {
+	credentials GSSCredentials
+	context     *gssContext // nil for RPCSEC_GSS_INIT
+	verifier    OpaqueAuth  // verifier of the reply
+}
+
+// sequenceWindow detects replayed sequence numbers (RFC2203: 5.3.3.1 Context Management)
+type sequenceWindow struct {
+	size    uint32
+	started bool
+	highest uint32 // highest sequence number seen
+	seen    []bool // sequence numbers seen, indexed modulo size
+}
+
+// errGSSProtection is wrapped by errors about arguments failing the checks of their service
+var errGSSProtection = errors.New("arguments not protected by RPCSEC_GSS service")
+
+// NewGSSAuthenticator returns an authenticator for RPCSEC_GSS, whose contexts are
+// established by mechanism. Calls whose sequence number lags more than seqWindow
+// behind the highest one seen are discarded. A seqWindow of zero selects a default.
+func NewGSSAuthenticator(mechanism GSSMechanism, seqWindow uint32) *GSSAuthenticator {
+	if seqWindow == 0 {
+		seqWindow = defaultGSSSeqWindow
+	}
+
+	return &GSSAuthenticator{
+		mechanism:   mechanism,
+		seqWindow:   seqWindow,
+		maxContexts: DefaultGSSMaxContexts,
+		idleTimeout: DefaultGSSIdleTimeout,
+		contexts:    make(map[string]*gssContext),
+		established: list.New(),
+	}
+}
+
+// SetMaxContexts limits the number of established contexts. Once the limit is reached,
+// the least recently used context is removed for every new one, and its client has to
+// establish a new context. It must be set before clients are handled.
+func (gssAuthenticator *GSSAuthenticator) SetMaxContexts(contexts int) {
+	gssAuthenticator.maxContexts = contexts
+}
+
+// SetIdleTimeout sets the time after which an established context is removed if it
+// isn't used. It must be set before clients are handled.
+func (gssAuthenticator *GSSAuthenticator) SetIdleTimeout(timeout time.Duration) {
+	gssAuthenticator.idleTimeout = timeout
+}
+
+// Authenticate accepts calls creating a context, and calls of established contexts
+// whose header checksum is valid and whose sequence number is within the window.
+// Replayed calls are discarded without reply.
+func (gssAuthenticator *GSSAuthenticator) Authenticate(call *CallContext) (OpaqueAuth, uint32) {
+	var credentials GSSCredentials
+
+	bytesRead, err := xdr.Unmarshal(call.Credentials.Body, &credentials)
+
+	if err != nil || bytesRead != len(call.Credentials.Body) || credentials.Version != GSSVersion {
+		return OpaqueAuth{}, AuthenticationBadCred
+	}
+
+	switch credentials.Procedure {
+	case GSSProcedureInit, GSSProcedureContinueInit:
+		if call.Procedure != 0 {
+			return OpaqueAuth{}, AuthenticationBadCred
+		}
+
+		if call.Verifier.Flavor != AuthenticationNull {
+			return OpaqueAuth{}, AuthenticationBadVerf
+		}
+
+		var context *gssContext
+
+		if credentials.Procedure == GSSProcedureContinueInit {
+			context = gssAuthenticator.context(credentials.Handle)
+
+			if context == nil || context.isEstablished() || time.Since(context.created) >= gssPendingTimeout {
+				return OpaqueAuth{}, GSSCredentialsProblem
+			}
+		}
+
+		call.gss = &gssCall{credentials: credentials, context: context, verifier: nullVerifier}
+
+		return nullVerifier, AuthenticationOK
+	case GSSProcedureData, GSSProcedureDestroy:
+		context := gssAuthenticator.context(credentials.Handle)
+
+		if context == nil || !context.isEstablished() {
+			return OpaqueAuth{}, GSSCredentialsProblem
+		}
+
+		if call.Verifier.Flavor != AuthenticationGSS || context.secContext.VerifyMIC(call.header, call.Verifier.Body) != nil {
+			return OpaqueAuth{}, GSSCredentialsProblem
+		}
+
+		if credentials.SeqNum >= GSSMaxSeq {
+			return OpaqueAuth{}, GSSContextProblem
+		}
+
+		if credentials.Service < GSSServiceNone || credentials.Service > GSSServicePrivacy {
+			return OpaqueAuth{}, AuthenticationBadCred
+		}
+
+		if !context.accept(credentials.SeqNum) {
+			return OpaqueAuth{}, authenticationDiscard
+		}
+
+		checksum, err := context.secContext.GetMIC(uint32Bytes(credentials.SeqNum))
+
+		if err != nil {
+			return OpaqueAuth{}, GSSContextProblem
+		}
+
+		verifier := OpaqueAuth{Flavor: AuthenticationGSS, Body: checksum}
+
+		call.Principal = context.secContext.Principal()
+		call.gss = &gssCall{credentials: credentials, context: context, verifier: verifier}
+
+		return verifier, AuthenticationOK
+	default:
+		return OpaqueAuth{}, AuthenticationBadCred
+	}
+}
+
+// control handles the control procedures RPCSEC_GSS_INIT, RPCSEC_GSS_CONTINUE_INIT and
+// RPCSEC_GSS_DESTROY, which aren't passed on to the program
+func (gssAuthenticator *GSSAuthenticator) control(call *CallContext, arguments []byte) (interface{}, OpaqueAuth, bool, error) {
+	if call.gss == nil {
+		return nil, OpaqueAuth{}, false, nil
+	}
+
+	switch call.gss.credentials.Procedure {
+	case GSSProcedureInit, GSSProcedureContinueInit:
+		var initArgs GSSInitArgs
+
+		_, err := xdr.Unmarshal(arguments, &initArgs)
+
+		if err != nil {
+			return nil, OpaqueAuth{}, false, err
+		}
+
+		results, verifier := gssAuthenticator.initContext(call.gss.context, initArgs.Token)
+
+		return results, verifier, true, nil
+	case GSSProcedureDestroy:
+		gssAuthenticator.remove(call.gss.context)
+
+		return Void{}, call.gss.verifier, true, nil
+	default:
+		return nil, OpaqueAuth{}, false, nil
+	}
+}
+
+// initContext passes a token of the client to the mechanism, creating a new context
+// if context is nil, and returns the results and the verifier of the reply
+func (gssAuthenticator *GSSAuthenticator) initContext(context *gssContext, token []byte) (*GSSInitRes, OpaqueAuth) {
+	var secContext GSSContext
+
+	if context != nil {
+		context.mutex.Lock()
+		secContext = context.secContext
+		context.mutex.Unlock()
+	}
+
+	secContext, outputToken, major, minor := gssAuthenticator.mechanism.AcceptSecContext(secContext, token)
+
+	if outputToken == nil {
+		outputToken = []byte{}
+	}
+
+	if major != GSSComplete && major != GSSContinueNeeded { // the context can't be established
+		if context != nil {
+			gssAuthenticator.remove(context)
+		}
+
+		return &GSSInitRes{Handle: []byte{}, Major: major, Minor: minor, Token: outputToken}, nullVerifier
+	}
+
+	if context == nil {
+		context = gssAuthenticator.newContext()
+
+		if context == nil { // too many contexts being established
+			return &GSSInitRes{Handle: []byte{}, Major: GSSFailure, Token: []byte{}}, nullVerifier
+		}
+	}
+
+	context.mutex.Lock()
+	established := context.established
+	if !established { // the context must not change once data is protected with it
+		context.secContext = secContext
+	}
+	context.mutex.Unlock()
+
+	if established { // by a concurrent RPCSEC_GSS_CONTINUE_INIT
+		return &GSSInitRes{Handle: []byte{}, Major: GSSFailure, Token: []byte{}}, nullVerifier
+	}
+
+	results := &GSSInitRes{Handle: context.handle, Major: major, Minor: minor, SeqWindow: gssAuthenticator.seqWindow, Token: outputToken}
+
+	if major == GSSContinueNeeded {
+		return results, nullVerifier
+	}
+
+	// the client verifies the checksum of the sequence window to authenticate the server
+	checksum, err := secContext.GetMIC(uint32Bytes(gssAuthenticator.seqWindow))
+
+	if err != nil {
+		gssAuthenticator.remove(context)
+
+		return &GSSInitRes{Handle: []byte{}, Major: GSSFailure, Token: []byte{}}, nullVerifier
+	}
+
+	gssAuthenticator.mutex.Lock()
+	context.mutex.Lock()
+	if !context.established && gssAuthenticator.contexts[string(context.handle)] == context {
+		context.established = true
+		gssAuthenticator.pending--
+		gssAuthenticator.use(context, time.Now())
+	}
+	context.mutex.Unlock()
+	gssAuthenticator.mutex.Unlock()
+
+	return results, OpaqueAuth{Flavor: AuthenticationGSS, Body: checksum}
+}
+
+// unwrapArguments verifies the arguments of a call protected by the integrity or privacy
+// service, and returns them without protection
+func (gssAuthenticator *GSSAuthenticator) unwrapArguments(call *CallContext, arguments []byte) ([]byte, error) {
+	if call.gss == nil {
+		return arguments, nil
+	}
+
+	var data []byte
+
+	switch call.gss.credentials.Service {
+	case GSSServiceIntegrity:
+		var integData GSSIntegData
+
+		_, err := xdr.Unmarshal(arguments, &integData)
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = call.gss.context.secContext.VerifyMIC(integData.Body, integData.Checksum)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid checksum of arguments: %w", errGSSProtection)
+		}
+
+		data = integData.Body
+	case GSSServicePrivacy:
+		var privData GSSPrivData
+
+		_, err := xdr.Unmarshal(arguments, &privData)
+
+		if err != nil {
+			return nil, err
+		}
+
+		data, err = call.gss.context.secContext.Unwrap(privData.Body)
+
+		if err != nil {
+			return nil, fmt.Errorf("Unable to unwrap arguments: %w", errGSSProtection)
+		}
+	default:
+		return arguments, nil
+	}
+
+	// the sequence number of the arguments must match the one of the credentials
+	if len(data) < 4 || binary.BigEndian.Uint32(data) != call.gss.credentials.SeqNum {
+		return nil, fmt.Errorf("Invalid sequence number of arguments: %w", errGSSProtection)
+	}
+
+	return data[4:], nil
+}
+
+// wrapResults protects the results of a call with the service of the call
+func (gssAuthenticator *GSSAuthenticator) wrapResults(call *CallContext, results interface{}) (interface{}, error) {
+	if call.gss == nil || call.gss.credentials.Service == GSSServiceNone {
+		return results, nil
+	}
+
+	resultBytes, err := xdr.Marshal(results)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data := append(uint32Bytes(call.gss.credentials.SeqNum), resultBytes...)
+
+	if call.gss.credentials.Service == GSSServiceIntegrity {
+		checksum, err := call.gss.context.secContext.GetMIC(data)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &GSSIntegData{Body: data, Checksum: checksum}, nil
+	}
+
+	body, err := call.gss.context.secContext.Wrap(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &GSSPrivData{Body: body}, nil
+}
+
+// context returns the context with the given handle, or nil if there is none. An
+// established context is marked as used, unless it was idle for too long, in which
+// case it is removed.
+func (gssAuthenticator *GSSAuthenticator) context(handle []byte) *gssContext {
+	gssAuthenticator.mutex.Lock()
+	defer gssAuthenticator.mutex.Unlock()
+
+	context := gssAuthenticator.contexts[string(handle)]
+
+	if context == nil || context.element == nil {
+		return context
+	}
+
+	now := time.Now()
+
+	if now.Sub(context.lastUsed) >= gssAuthenticator.idleTimeout {
+		gssAuthenticator.forget(context)
+		return nil
+	}
+
+	gssAuthenticator.use(context, now)
+
+	return context
+}
+
+// use marks an established context as the most recently used one, removing the
+// contexts idle for too long and the least recently used ones beyond maxContexts.
+// The caller must hold the mutex.
+func (gssAuthenticator *GSSAuthenticator) use(context *gssContext, now time.Time) {
+	context.lastUsed = now
+
+	if context.element == nil {
+		context.element = gssAuthenticator.established.PushBack(context)
+	} else {
+		gssAuthenticator.established.MoveToBack(context.element)
+	}
+
+	for element := gssAuthenticator.established.Front(); element != nil; element = gssAuthenticator.established.Front() {
+		oldest := element.Value.(*gssContext)
+
+		if gssAuthenticator.established.Len() <= gssAuthenticator.maxContexts && now.Sub(oldest.lastUsed) < gssAuthenticator.idleTimeout {
+			return
+		}
+
+		gssAuthenticator.forget(oldest)
+	}
+}
+
+// newContext creates a context with a new random handle. It returns nil if too many
+// contexts are being established.
+func (gssAuthenticator *GSSAuthenticator) newContext() *gssContext {
+	handle := make([]byte, gssHandleSize)
+
+	_, err := rand.Read(handle)
+
+	if err != nil {
+		panic(err) // the system's random number generator is broken
+	}
+
+	context := &gssContext{
+		handle:  handle,
+		created: time.Now(),
+		window:  sequenceWindow{size: gssAuthenticator.seqWindow, seen: make([]bool, gssAuthenticator.seqWindow)},
+	}
+
+	gssAuthenticator.mutex.Lock()
+	defer gssAuthenticator.mutex.Unlock()
+
+	if gssAuthenticator.pending >= gssMaxPendingContexts {
+		gssAuthenticator.expire(context.created)
+	}
+
+	if gssAuthenticator.pending >= gssMaxPendingContexts {
+		return nil
+	}
+
+	gssAuthenticator.contexts[string(handle)] = context
+	gssAuthenticator.pending++
+
+	return context
+}
+
+// expire removes the contexts which weren't established within gssPendingTimeout.
+// The caller must hold the mutex.
+func (gssAuthenticator *GSSAuthenticator) expire(now time.Time) {
+	for _, context := range gssAuthenticator.contexts {
+		if now.Sub(context.created) < gssPendingTimeout || context.element != nil {
+			continue
+		}
+
+		gssAuthenticator.forget(context)
+	}
+}
+
+// remove removes a context, unless it was removed before
+func (gssAuthenticator *GSSAuthenticator) remove(context *gssContext) {
+	gssAuthenticator.mutex.Lock()
+	defer gssAuthenticator.mutex.Unlock()
+
+	if gssAuthenticator.contexts[string(context.handle)] != context {
+		return
+	}
+
+	gssAuthenticator.forget(context)
+}
+
+// forget removes a context known to be present. The caller must hold the mutex.
+func (gssAuthenticator *GSSAuthenticator) forget(context *gssContext) {
+	delete(gssAuthenticator.contexts, string(context.handle))
+
+	if context.element != nil {
+		gssAuthenticator.established.Remove(context.element)
+		context.element = nil
+	} else {
+		gssAuthenticator.pending--
+	}
+}
+
+// isEstablished returns whether the client may send data on the context
+func (context *gssContext) isEstablished() bool {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	return context.established
+}
+
+// accept returns whether a call with sequence number seqNum is to be processed
+func (context *gssContext) accept(seqNum uint32) bool {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	return context.window.accept(seqNum)
+}
+
+// accept records sequence number seqNum and returns false if it was seen before or
+// is too old to tell
+func (window *sequenceWindow) accept(seqNum uint32) bool {
+	switch {
+	case !window.started:
+		window.started = true
+		window.highest = seqNum
+	case seqNum > window.highest: // advance the window, forgetting the oldest numbers
+		if seqNum-window.highest >= window.size {
+			for i := range window.seen {
+				window.seen[i] = false
+			}
+		} else {
+			for skipped := window.highest + 1; skipped < seqNum; skipped++ {
+				window.seen[skipped%window.size] = false
+			}
+		}
+
+		window.highest = seqNum
+	case window.highest-seqNum >= window.size: // below the window
+		return false
+	case window.seen[seqNum%window.size]:
+		return false
+	}
+
+	window.seen[seqNum%window.size] = true
+
+	return true
+}
+
+// uint32Bytes returns the XDR encoding of an unsigned integer
+func uint32Bytes(value uint32) []byte {
+	bytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(bytes, value)
+
+	return bytes
+}
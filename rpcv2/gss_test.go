@@ -0,0 +1,499 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpcv2_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+const (
+	testProgram   uint32 = 400000
+	testVersion   uint32 = 1
+	testProcedure uint32 = 1
+)
+
+// fakeMechanism establishes contexts in two legs: the client sends "hello", is
+// challenged, and answers with "secret"
+type fakeMechanism struct{}
+
+// fakeContext protects messages with HMAC-SHA256, and "encrypts" them by inverting all bits
+type fakeContext struct {
+	key []byte
+}
+
+func (fakeMechanism) AcceptSecContext(secContext rpcv2.GSSContext, token []byte) (rpcv2.GSSContext, []byte, uint32, uint32) {
+	switch {
+	case secContext == nil && string(token) == "hello":
+		return &fakeContext{key: []byte("session key")}, []byte("challenge"), rpcv2.GSSContinueNeeded, 0
+	case secContext != nil && string(token) == "secret":
+		return secContext, nil, rpcv2.GSSComplete, 0
+	default:
+		return nil, nil, rpcv2.GSSFailure, 1
+	}
+}
+
+func (context *fakeContext) Principal() string {
+	return "alice@EXAMPLE.COM"
+}
+
+func (context *fakeContext) GetMIC(message []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, context.key)
+	mac.Write(message)
+
+	return mac.Sum(nil), nil
+}
+
+func (context *fakeContext) VerifyMIC(message []byte, mic []byte) error {
+	expected, _ := context.GetMIC(message)
+
+	if !hmac.Equal(expected, mic) {
+		return errors.New("bad MIC")
+	}
+
+	return nil
+}
+
+func (context *fakeContext) Wrap(message []byte) ([]byte, error) {
+	mic, _ := context.GetMIC(message)
+	wrapped := append([]byte(nil), message...)
+
+	for i := range wrapped {
+		wrapped[i] = ^wrapped[i]
+	}
+
+	return append(mic, wrapped...), nil
+}
+
+func (context *fakeContext) Unwrap(message []byte) ([]byte, error) {
+	if len(message) < sha256.Size {
+		return nil, errors.New("message too short")
+	}
+
+	unwrapped := append([]byte(nil), message[sha256.Size:]...)
+
+	for i := range unwrapped {
+		unwrapped[i] = ^unwrapped[i]
+	}
+
+	return unwrapped, context.VerifyMIC(unwrapped, message[:sha256.Size])
+}
+
+// testReply is the header of a reply up to the results
+type testReply struct {
+	XID         uint32
+	MessageType uint32
+	ReplyStatus uint32 `xdr:"switch"`
+	Accepted    struct {
+		Verf        rpcv2.OpaqueAuth
+		AcceptState uint32
+	} `xdr:"case=0"`
+	Rejected struct {
+		RejectState uint32
+		Stat        uint32
+	} `xdr:"case=1"`
+}
+
+// gssClient calls a service over a connection using RPCSEC_GSS
+type gssClient struct {
+	t       *testing.T
+	conn    net.Conn
+	xid     uint32
+	handle  []byte
+	context *fakeContext
+}
+
+// newGSSService returns a connection to a service accepting RPCSEC_GSS, whose test
+// procedure returns its argument incremented by one
+func newGSSService(t *testing.T) net.Conn {
+	return newGSSAuthenticatorService(t, rpcv2.NewGSSAuthenticator(fakeMechanism{}, 4))
+}
+
+// newGSSAuthenticatorService returns a connection to a service like newGSSService,
+// which accepts RPCSEC_GSS with the given authenticator
+func newGSSAuthenticatorService(t *testing.T, gssAuthenticator *rpcv2.GSSAuthenticator) net.Conn {
+	rpcService := rpcv2.NewRPCService("test", testProgram, testVersion)
+	rpcService.RegisterAuthenticator(rpcv2.AuthenticationGSS, gssAuthenticator)
+	rpcService.RegisterProcedure(0, func(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+		return rpcv2.Void{}, nil
+	})
+	rpcService.RegisterProcedure(testProcedure, func(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+		if call.Principal != "alice@EXAMPLE.COM" {
+			return nil, errors.New("unexpected principal " + call.Principal)
+		}
+
+		if len(arg) < 4 {
+			return nil, errors.New("missing argument")
+		}
+
+		return binary.BigEndian.Uint32(arg) + 1, nil
+	})
+
+	serverConn, clientConn := net.Pipe()
+
+	go rpcService.ServeConn(serverConn)
+
+	return clientConn
+}
+
+// call sends a call with the given credentials and arguments and returns the reply.
+// If sign is set, the verifier is the MIC of the header.
+func (client *gssClient) call(procedure uint32, credentials rpcv2.GSSCredentials, sign bool, arguments []byte) (*testReply, []byte) {
+	client.send(procedure, credentials, sign, arguments)
+
+	return client.readReply()
+}
+
+// send sends a call without waiting for the reply
+func (client *gssClient) send(procedure uint32, credentials rpcv2.GSSCredentials, sign bool, arguments []byte) {
+	client.xid++
+
+	credentialsBody, err := xdr.Marshal(&credentials)
+	if err != nil {
+		client.t.Fatal(err.Error())
+	}
+
+	message := &rpcv2.RPCMessage{
+		XID:         client.xid,
+		MessageType: rpcv2.Call,
+		CBody: rpcv2.CallBody{
+			RPCVersion:     rpcv2.RPCVersion,
+			Program:        testProgram,
+			ProgramVersion: testVersion,
+			Procedure:      procedure,
+			Credentials:    rpcv2.OpaqueAuth{Flavor: rpcv2.AuthenticationGSS, Body: credentialsBody},
+			Verifier:       rpcv2.OpaqueAuth{Flavor: rpcv2.AuthenticationNull, Body: []byte{}},
+		},
+	}
+
+	if sign {
+		unsigned, _ := xdr.Marshal(message)
+		mic, _ := client.context.GetMIC(unsigned[:len(unsigned)-8])
+		message.CBody.Verifier = rpcv2.OpaqueAuth{Flavor: rpcv2.AuthenticationGSS, Body: mic}
+	}
+
+	writeCall(client.t, client.conn, message, arguments)
+}
+
+// readReply returns the reply to the last call, skipping replies to earlier calls
+func (client *gssClient) readReply() (*testReply, []byte) {
+	return readReply(client.t, client.conn, client.xid)
+}
+
+// writeCall sends a call as a single record
+func writeCall(t *testing.T, conn net.Conn, message *rpcv2.RPCMessage, arguments []byte) {
+	callBytes, err := xdr.Marshal(message)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	record := make([]byte, 4)
+	binary.BigEndian.PutUint32(record, rpcv2.LastFragment|uint32(len(callBytes)+len(arguments)))
+	record = append(append(record, callBytes...), arguments...)
+
+	_, err = conn.Write(record)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+// readRecord returns the next record and the number of fragments it was split into
+func readRecord(t *testing.T, conn net.Conn) ([]byte, int) {
+	var record []byte
+
+	header := make([]byte, 4)
+
+	for fragments := 1; ; fragments++ {
+		_, err := io.ReadFull(conn, header)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		fragment := make([]byte, binary.BigEndian.Uint32(header)&^rpcv2.LastFragment)
+
+		_, err = io.ReadFull(conn, fragment)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		record = append(record, fragment...)
+
+		if binary.BigEndian.Uint32(header)&rpcv2.LastFragment != 0 {
+			return record, fragments
+		}
+	}
+}
+
+// readReply returns the reply to the call with the given XID and its results,
+// skipping replies to other calls
+func readReply(t *testing.T, conn net.Conn, xid uint32) (*testReply, []byte) {
+	for {
+		replyBytes, _ := readRecord(t, conn)
+
+		var reply testReply
+
+		bytesRead, err := xdr.Unmarshal(replyBytes, &reply)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if reply.XID == xid {
+			return &reply, replyBytes[bytesRead:]
+		}
+	}
+}
+
+// establish creates a context with the fake mechanism
+func (client *gssClient) establish() {
+	credentials := rpcv2.GSSCredentials{Version: rpcv2.GSSVersion, Procedure: rpcv2.GSSProcedureInit, Service: rpcv2.GSSServiceNone}
+
+	for _, token := range []string{"hello", "secret"} {
+		arguments, _ := xdr.Marshal(&rpcv2.GSSInitArgs{Token: []byte(token)})
+
+		reply, results := client.call(0, credentials, false, arguments)
+		if reply.ReplyStatus != rpcv2.MessageAccepted || reply.Accepted.AcceptState != rpcv2.Success {
+			client.t.Fatalf("Expected %v but got %+v", rpcv2.Success, reply)
+		}
+
+		var initRes rpcv2.GSSInitRes
+
+		_, err := xdr.Unmarshal(results, &initRes)
+		if err != nil {
+			client.t.Fatal(err.Error())
+		}
+
+		switch token {
+		case "hello":
+			if initRes.Major != rpcv2.GSSContinueNeeded || string(initRes.Token) != "challenge" {
+				client.t.Fatalf("Expected %v but got %+v", rpcv2.GSSContinueNeeded, initRes)
+			}
+		case "secret":
+			if initRes.Major != rpcv2.GSSComplete || initRes.SeqWindow != 4 {
+				client.t.Fatalf("Expected %v but got %+v", rpcv2.GSSComplete, initRes)
+			}
+
+			// the server proves its identity with the checksum of the sequence window
+			seqWindow := make([]byte, 4)
+			binary.BigEndian.PutUint32(seqWindow, initRes.SeqWindow)
+
+			err = client.context.VerifyMIC(seqWindow, reply.Accepted.Verf.Body)
+			if err != nil || reply.Accepted.Verf.Flavor != rpcv2.AuthenticationGSS {
+				client.t.Fatalf("Expected checksum of sequence window but got %+v", reply.Accepted.Verf)
+			}
+		}
+
+		client.handle = initRes.Handle
+		credentials.Procedure = rpcv2.GSSProcedureContinueInit
+		credentials.Handle = initRes.Handle
+	}
+}
+
+// data returns credentials for a call on the established context
+func (client *gssClient) data(seqNum uint32, service uint32) rpcv2.GSSCredentials {
+	return rpcv2.GSSCredentials{Version: rpcv2.GSSVersion, Procedure: rpcv2.GSSProcedureData, SeqNum: seqNum, Service: service, Handle: client.handle}
+}
+
+func TestGSSServices(t *testing.T) {
+	client := &gssClient{t: t, conn: newGSSService(t), context: &fakeContext{key: []byte("session key")}}
+	defer client.conn.Close()
+
+	client.establish()
+
+	for seqNum, service := range []uint32{rpcv2.GSSServiceNone, rpcv2.GSSServiceIntegrity, rpcv2.GSSServicePrivacy} {
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint32(data, uint32(seqNum))
+		binary.BigEndian.PutUint32(data[4:], 41)
+
+		var arguments []byte
+
+		switch service {
+		case rpcv2.GSSServiceNone:
+			arguments = data[4:]
+		case rpcv2.GSSServiceIntegrity:
+			mic, _ := client.context.GetMIC(data)
+			arguments, _ = xdr.Marshal(&rpcv2.GSSIntegData{Body: data, Checksum: mic})
+		case rpcv2.GSSServicePrivacy:
+			wrapped, _ := client.context.Wrap(data)
+			arguments, _ = xdr.Marshal(&rpcv2.GSSPrivData{Body: wrapped})
+		}
+
+		reply, results := client.call(testProcedure, client.data(uint32(seqNum), service), true, arguments)
+		if reply.ReplyStatus != rpcv2.MessageAccepted || reply.Accepted.AcceptState != rpcv2.Success {
+			t.Fatalf("Expected %v but got %+v (service %d)", rpcv2.Success, reply, service)
+		}
+
+		seqNumBytes := make([]byte, 4)
+		binary.BigEndian.PutUint32(seqNumBytes, uint32(seqNum))
+
+		err := client.context.VerifyMIC(seqNumBytes, reply.Accepted.Verf.Body)
+		if err != nil {
+			t.Fatalf("Expected checksum of sequence number but got %+v", reply.Accepted.Verf)
+		}
+
+		switch service {
+		case rpcv2.GSSServiceIntegrity:
+			var integData rpcv2.GSSIntegData
+			xdr.Unmarshal(results, &integData)
+
+			if client.context.VerifyMIC(integData.Body, integData.Checksum) != nil {
+				t.Fatalf("Expected valid checksum of results but got %+v", integData)
+			}
+
+			results = integData.Body
+		case rpcv2.GSSServicePrivacy:
+			var privData rpcv2.GSSPrivData
+			xdr.Unmarshal(results, &privData)
+
+			results, err = client.context.Unwrap(privData.Body)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+
+		if service != rpcv2.GSSServiceNone {
+			if !bytes.Equal(results[:4], seqNumBytes) {
+				t.Fatalf("Expected sequence number %v but got %v", seqNumBytes, results[:4])
+			}
+
+			results = results[4:]
+		}
+
+		if binary.BigEndian.Uint32(results) != 42 {
+			t.Fatalf("Expected %v but got %v (service %d)", 42, binary.BigEndian.Uint32(results), service)
+		}
+	}
+}
+
+func TestGSSReplayAndDestroy(t *testing.T) {
+	client := &gssClient{t: t, conn: newGSSService(t), context: &fakeContext{key: []byte("session key")}}
+	defer client.conn.Close()
+
+	client.establish()
+
+	argument := []byte{0, 0, 0, 1}
+
+	reply, _ := client.call(testProcedure, client.data(10, rpcv2.GSSServiceNone), true, argument)
+	if reply.Accepted.AcceptState != rpcv2.Success {
+		t.Fatalf("Expected %v but got %+v", rpcv2.Success, reply)
+	}
+
+	// replays and calls below the window are discarded, so the server answers the next call first
+	for _, seqNum := range []uint32{10, 6} {
+		client.send(testProcedure, client.data(seqNum, rpcv2.GSSServiceNone), true, argument)
+	}
+
+	reply, _ = client.call(testProcedure, client.data(7, rpcv2.GSSServiceNone), true, argument)
+	if reply.Accepted.AcceptState != rpcv2.Success || reply.XID != client.xid {
+		t.Fatalf("Expected %v but got %+v", rpcv2.Success, reply)
+	}
+
+	// garbled verifier
+	client.context.key = []byte("wrong key")
+	reply, _ = client.call(testProcedure, client.data(11, rpcv2.GSSServiceNone), true, argument)
+	if reply.ReplyStatus != rpcv2.MessageDenied || reply.Rejected.Stat != rpcv2.GSSCredentialsProblem {
+		t.Fatalf("Expected %v but got %+v", rpcv2.GSSCredentialsProblem, reply)
+	}
+	client.context.key = []byte("session key")
+
+	destroy := client.data(12, rpcv2.GSSServiceNone)
+	destroy.Procedure = rpcv2.GSSProcedureDestroy
+
+	reply, _ = client.call(0, destroy, true, nil)
+	if reply.Accepted.AcceptState != rpcv2.Success {
+		t.Fatalf("Expected %v but got %+v", rpcv2.Success, reply)
+	}
+
+	reply, _ = client.call(testProcedure, client.data(13, rpcv2.GSSServiceNone), true, argument)
+	if reply.ReplyStatus != rpcv2.MessageDenied || reply.Rejected.Stat != rpcv2.GSSCredentialsProblem {
+		t.Fatalf("Expected %v but got %+v", rpcv2.GSSCredentialsProblem, reply)
+	}
+}
+
+func TestGSSPendingContexts(t *testing.T) {
+	client := &gssClient{t: t, conn: newGSSService(t), context: &fakeContext{key: []byte("session key")}}
+	defer client.conn.Close()
+
+	credentials := rpcv2.GSSCredentials{Version: rpcv2.GSSVersion, Procedure: rpcv2.GSSProcedureInit, Service: rpcv2.GSSServiceNone}
+	arguments, _ := xdr.Marshal(&rpcv2.GSSInitArgs{Token: []byte("hello")})
+
+	// clients can't create any number of contexts without establishing them
+	for i := 0; ; i++ {
+		if i == 1000 {
+			t.Fatalf("Expected %v but created %v contexts", rpcv2.GSSFailure, i)
+		}
+
+		_, results := client.call(0, credentials, false, arguments)
+
+		var initRes rpcv2.GSSInitRes
+
+		_, err := xdr.Unmarshal(results, &initRes)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if initRes.Major == rpcv2.GSSFailure {
+			break
+		}
+	}
+}
+
+func TestGSSContextLimits(t *testing.T) {
+	gssAuthenticator := rpcv2.NewGSSAuthenticator(fakeMechanism{}, 4)
+	gssAuthenticator.SetMaxContexts(2)
+	gssAuthenticator.SetIdleTimeout(100 * time.Millisecond)
+
+	client := &gssClient{t: t, conn: newGSSAuthenticatorService(t, gssAuthenticator), context: &fakeContext{key: []byte("session key")}}
+	defer client.conn.Close()
+
+	argument := []byte{0, 0, 0, 1}
+	handles := make([][]byte, 3)
+
+	for i := range handles {
+		client.establish()
+		handles[i] = client.handle
+
+		if i == 1 { // use the first context after establishing the second
+			client.handle = handles[0]
+			client.call(testProcedure, client.data(1, rpcv2.GSSServiceNone), true, argument)
+		}
+	}
+
+	// the least recently used context makes room for the third
+	tests := []struct {
+		handle []byte
+		stat   uint32
+	}{
+		{handles[0], rpcv2.AuthenticationOK},
+		{handles[1], rpcv2.GSSCredentialsProblem},
+		{handles[2], rpcv2.AuthenticationOK},
+	}
+
+	for i, test := range tests {
+		client.handle = test.handle
+
+		reply, _ := client.call(testProcedure, client.data(2, rpcv2.GSSServiceNone), true, argument)
+		if reply.ReplyStatus == rpcv2.MessageAccepted && test.stat != rpcv2.AuthenticationOK || reply.ReplyStatus == rpcv2.MessageDenied && reply.Rejected.Stat != test.stat {
+			t.Fatalf("Expected %v but got %+v (test %d)", test.stat, reply, i)
+		}
+	}
+
+	// contexts not used within the idle timeout are removed
+	time.Sleep(200 * time.Millisecond)
+
+	reply, _ := client.call(testProcedure, client.data(3, rpcv2.GSSServiceNone), true, argument)
+	if reply.ReplyStatus != rpcv2.MessageDenied || reply.Rejected.Stat != rpcv2.GSSCredentialsProblem {
+		t.Fatalf("Expected %v but got %+v", rpcv2.GSSCredentialsProblem, reply)
+	}
+}
@@ -6,10 +6,14 @@ package rpcv2
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"sync"
 
 	"github.com/dlorch/base-nfs/xdr"
 )
@@ -66,6 +70,54 @@ type OpaqueAuth struct {
 	Body   []byte
 }
 
+// AuthUnixParms are the credentials of the AUTH_UNIX flavor, also known as AUTH_SYS
+// (RFC5531: struct authsys_parms)
+type AuthUnixParms struct {
+	Stamp       uint32   // arbitrary ID generated by the caller
+	MachineName string   // name of the caller's machine
+	UID         uint32   // effective user ID of the caller
+	GID         uint32   // effective group ID of the caller
+	GIDs        []uint32 // groups the caller is a member of
+}
+
+// GSSCredentials are the credentials of the RPCSEC_GSS flavor (RFC2203: struct rpc_gss_cred_vers_1_t)
+type GSSCredentials struct {
+	Version   uint32 // GSSVersion
+	Procedure uint32 // control procedure (enum rpc_gss_proc_t)
+	SeqNum    uint32 // sequence number of the call
+	Service   uint32 // protection of arguments and results (enum rpc_gss_service_t)
+	Handle    []byte // context handle, empty when creating a context
+}
+
+// GSSInitArgs are the arguments of RPCSEC_GSS_INIT and RPCSEC_GSS_CONTINUE_INIT calls
+// (RFC2203: rpc_gss_init_arg)
+type GSSInitArgs struct {
+	Token []byte // token produced by the GSS mechanism of the client
+}
+
+// GSSInitRes are the results of RPCSEC_GSS_INIT and RPCSEC_GSS_CONTINUE_INIT calls
+// (RFC2203: struct rpc_gss_init_res)
+type GSSInitRes struct {
+	Handle    []byte // context handle the client sends in subsequent calls
+	Major     uint32 // GSS major status
+	Minor     uint32 // GSS minor status, specific to the mechanism
+	SeqWindow uint32 // size of the sequence window
+	Token     []byte // token to be passed to the GSS mechanism of the client
+}
+
+// GSSIntegData are arguments or results protected by the integrity service
+// (RFC2203: struct rpc_gss_integ_data)
+type GSSIntegData struct {
+	Body     []byte // sequence number followed by the arguments or results (rpc_gss_data_t)
+	Checksum []byte // MIC of Body
+}
+
+// GSSPrivData are arguments or results protected by the privacy service
+// (RFC2203: struct rpc_gss_priv_data)
+type GSSPrivData struct {
+	Body []byte // wrapped sequence number followed by the arguments or results
+}
+
 // Void is a void reply
 type Void struct{}
 
@@ -75,6 +127,54 @@ const (
 	AuthenticationUNIX  uint32 = 1 // AUTH_UNIX
 	AuthenticationShort uint32 = 2 // AUTH_SHORT
 	AuthenticationDES   uint32 = 3 // AUTH_DES
+	AuthenticationGSS   uint32 = 6 // RPCSEC_GSS (RFC2203)
+	AuthenticationTLS   uint32 = 7 // AUTH_TLS, probes for RPC-over-TLS (RFC9289)
+)
+
+// Reasons for authentication failures (RFC1057: enum auth_stat)
+const (
+	AuthenticationOK           uint32 = 0  // success
+	AuthenticationBadCred      uint32 = 1  // bad credentials (seal broken)
+	AuthenticationRejectedCred uint32 = 2  // client must begin new session
+	AuthenticationBadVerf      uint32 = 3  // bad verifier (seal broken)
+	AuthenticationRejectedVerf uint32 = 4  // verifier expired or replayed
+	AuthenticationTooWeak      uint32 = 5  // rejected for security reasons
+	GSSCredentialsProblem      uint32 = 13 // no credentials for user (RFC2203: RPCSEC_GSS_CREDPROBLEM)
+	GSSContextProblem          uint32 = 14 // problem with context (RFC2203: RPCSEC_GSS_CTXPROBLEM)
+)
+
+// RPCSEC_GSS control procedures (RFC2203: enum rpc_gss_proc_t)
+const (
+	GSSProcedureData         uint32 = 0 // RPCSEC_GSS_DATA
+	GSSProcedureInit         uint32 = 1 // RPCSEC_GSS_INIT
+	GSSProcedureContinueInit uint32 = 2 // RPCSEC_GSS_CONTINUE_INIT
+	GSSProcedureDestroy      uint32 = 3 // RPCSEC_GSS_DESTROY
+)
+
+// RPCSEC_GSS protection of arguments and results (RFC2203: enum rpc_gss_service_t)
+const (
+	GSSServiceNone      uint32 = 1 // rpc_gss_svc_none
+	GSSServiceIntegrity uint32 = 2 // rpc_gss_svc_integrity
+	GSSServicePrivacy   uint32 = 3 // rpc_gss_svc_privacy
+)
+
+// Constants for RPCSEC_GSS (RFC2203)
+const (
+	GSSVersion uint32 = 1          // RPCSEC_GSS_VERS_1
+	GSSMaxSeq  uint32 = 0x80000000 // MAXSEQ, sequence numbers must be below
+)
+
+// GSS major status codes (RFC2744: 3.9.1 GSS status codes)
+const (
+	GSSComplete       uint32 = 0        // GSS_S_COMPLETE
+	GSSContinueNeeded uint32 = 1        // GSS_S_CONTINUE_NEEDED
+	GSSFailure        uint32 = 13 << 16 // GSS_S_FAILURE
+)
+
+// Limits of AUTH_UNIX credentials (RFC5531: struct authsys_parms)
+const (
+	AuthUnixMachineNameMaxLength uint32 = 255 // maximal length of AuthUnixParms.MachineName
+	AuthUnixGIDsMaxLength        uint32 = 16  // maximal number of AuthUnixParms.GIDs
 )
 
 // Constants for RPCv2 (RFC 1057)
@@ -95,53 +195,244 @@ const (
 	OpaqueAuthBodyMaxLength uint32 = 400     // maximal length of OpaqueAuth.Body
 )
 
+// tcpConnection is the state of a TCP connection shared by the calls received over it
+type tcpConnection struct {
+	writing  sync.Mutex         // serializes writing replies
+	mutex    sync.Mutex         // protects conn, err and active
+	conn     net.Conn           // connection to the client, possibly upgraded to TLS
+	err      error              // first error which closed the connection
+	active   int                // number of calls in progress
+	calls    sync.WaitGroup     // calls in progress
+	cancel   context.CancelFunc // cancels the contexts of the calls
+	startTLS bool               // start the TLS handshake once the reply to the current call is sent
+}
+
 // handleTCPClient handles TCP client connections, reads requests and delimits them into
-// individual messages (RFC 1057: 10. Record Marking Standard) for further processing
-func handleTCPClient(clientConnection net.Conn, rpcProcedures map[uint32]rpcProcedureHandler) error {
-	var responseBytes []byte
-	requestBytes, isLastFragment, err := readNextRequestFragment(clientConnection) // TODO not sure this is correct, shouldn't fragments be concatenated?
+// individual messages (RFC5531: 11. Record Marking Standard) for further processing.
+// Calls are processed concurrently by the worker pool, and their replies are sent in
+// the order they are completed. Clients match them to their calls by XID.
+func (rpcService *RPCService) handleTCPClient(clientConnection net.Conn) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	tcpConnection := &tcpConnection{conn: clientConnection, cancel: cancel}
+
+	if !rpcService.addConnection(tcpConnection) {
+		cancel()
+		clientConnection.Close()
+		return ErrServiceClosed
+	}
+
+	defer func() {
+		cancel()                   // the client is gone, so calls in progress may give up
+		tcpConnection.calls.Wait() // but let them send their replies
+		tcpConnection.mutex.Lock()
+		tcpConnection.conn.Close() // possibly upgraded to TLS in the meantime
+		tcpConnection.mutex.Unlock()
+		rpcService.removeConnection(tcpConnection)
+	}()
+
+	connection := CallContext{
+		RemoteAddr: clientConnection.RemoteAddr(),
+		Transport:  TransportTCP,
+		ctx:        ctx,
+		connection: tcpConnection,
+	}
 
 	for {
+		requestBytes, err := readRecord(clientConnection, rpcService.maxRecordSize)
+
 		if err != nil {
-			if err.Error() == "EOF" { // all good, we reached the end of the TCP request
-				return nil
-			}
-			return err
+			return tcpConnection.failure(err)
 		}
 
-		responseBytes, err = handleClient(requestBytes, rpcProcedures)
+		if rpcService.tlsConfig != nil && isTLSProbe(requestBytes) {
+			// the client starts the handshake after the reply to its probe, so no more
+			// calls may be read until then
+			tcpConnection.calls.Wait()
 
-		if err != nil {
-			return err
-		}
+			if !tcpConnection.begin() {
+				return tcpConnection.failure(nil)
+			}
 
-		err = writeResponseFragment(clientConnection, responseBytes, isLastFragment)
+			rpcService.handleTCPCall(connection, requestBytes)
+			tcpConnection.end()
 
-		if err != nil {
-			return err
+			if !tcpConnection.startTLS {
+				continue
+			}
+
+			tcpConnection.startTLS = false
+
+			tlsConnection := tls.Server(clientConnection, rpcService.tlsConfig)
+
+			err = tlsConnection.Handshake()
+
+			if err != nil {
+				return tcpConnection.failure(err)
+			}
+
+			state := tlsConnection.ConnectionState()
+			connection.TLS = &state
+			clientConnection = tlsConnection
+
+			tcpConnection.mutex.Lock()
+			tcpConnection.conn = tlsConnection
+			tcpConnection.mutex.Unlock()
+
+			continue
+		}
+
+		if !tcpConnection.begin() { // closed by Shutdown() in the meantime
+			return tcpConnection.failure(nil)
 		}
 
-		requestBytes, isLastFragment, err = readNextRequestFragment(clientConnection)
+		rpcService.workers <- struct{}{}
+
+		go func(connection CallContext) {
+			defer func() {
+				<-rpcService.workers
+				tcpConnection.end()
+			}()
+
+			rpcService.handleTCPCall(connection, requestBytes)
+		}(connection)
+	}
+}
+
+// handleTCPCall processes a call received over a TCP connection and sends the reply.
+// The connection is closed if the call is malformed or the reply can't be sent.
+func (rpcService *RPCService) handleTCPCall(connection CallContext, requestBytes []byte) {
+	responseBytes, err := rpcService.handleClient(connection, requestBytes)
+
+	if err != nil {
+		connection.connection.close(err)
+		return
+	}
+
+	if responseBytes == nil { // calls are discarded without reply, e.g. replays
+		return
+	}
+
+	connection.connection.writing.Lock()
+	connection.connection.mutex.Lock()
+	conn := connection.connection.conn
+	connection.connection.mutex.Unlock()
+	err = writeRecord(conn, responseBytes, rpcService.maxFragmentSize)
+	connection.connection.writing.Unlock()
+
+	if err != nil {
+		connection.connection.close(err)
 	}
 }
 
+// close closes the connection because of err, unless it was closed before
+func (tcpConnection *tcpConnection) close(err error) {
+	tcpConnection.mutex.Lock()
+	defer tcpConnection.mutex.Unlock()
+
+	if tcpConnection.err == nil {
+		tcpConnection.err = err
+		tcpConnection.conn.Close()
+	}
+}
+
+// closeIdle closes the connection if no calls are in progress, unless it was closed before
+func (tcpConnection *tcpConnection) closeIdle() {
+	tcpConnection.mutex.Lock()
+	defer tcpConnection.mutex.Unlock()
+
+	if tcpConnection.err == nil && tcpConnection.active == 0 {
+		tcpConnection.err = ErrServiceClosed
+		tcpConnection.conn.Close()
+	}
+}
+
+// begin counts a call read from the connection as in progress. It returns false if
+// the connection was closed in the meantime, in which case the call is dropped.
+func (tcpConnection *tcpConnection) begin() bool {
+	tcpConnection.mutex.Lock()
+	defer tcpConnection.mutex.Unlock()
+
+	if tcpConnection.err != nil {
+		return false
+	}
+
+	tcpConnection.active++
+	tcpConnection.calls.Add(1)
+
+	return true
+}
+
+// end counts a call counted by begin as done
+func (tcpConnection *tcpConnection) end() {
+	tcpConnection.mutex.Lock()
+	tcpConnection.active--
+	tcpConnection.mutex.Unlock()
+
+	tcpConnection.calls.Done()
+}
+
+// failure returns why reading from the connection failed with err. It is the error
+// which closed the connection if any, and nil if the client or Shutdown closed the
+// connection.
+func (tcpConnection *tcpConnection) failure(err error) error {
+	tcpConnection.mutex.Lock()
+	defer tcpConnection.mutex.Unlock()
+
+	if tcpConnection.err == ErrServiceClosed {
+		return nil
+	}
+
+	if tcpConnection.err != nil {
+		return tcpConnection.err
+	}
+
+	if err == io.EOF {
+		return nil
+	}
+
+	return err
+}
+
+// isTLSProbe returns whether a request is an AUTH_TLS probe (RFC9289: 4.1 Discovering
+// Server-Side TLS Support)
+func isTLSProbe(requestBytes []byte) bool {
+	rpcRequest, _, err := parseRPCCallBody(requestBytes)
+
+	return err == nil && rpcRequest.CBody.Credentials.Flavor == AuthenticationTLS
+}
+
 // handleUDPClient handles UDP connections
-func handleUDPClient(requestBytes []byte, serverConnection *net.UDPConn, clientAddress *net.UDPAddr, rpcProcedures map[uint32]rpcProcedureHandler) error {
-	responseBytes, err := handleClient(requestBytes, rpcProcedures)
+func (rpcService *RPCService) handleUDPClient(requestBytes []byte, serverConnection *net.UDPConn, clientAddress *net.UDPAddr) error {
+	connection := CallContext{
+		RemoteAddr: clientAddress,
+		Transport:  TransportUDP,
+		ctx:        context.Background(),
+	}
+
+	responseBytes, err := rpcService.handleClient(connection, requestBytes)
 
 	if err != nil {
 		return err
 	}
 
-	serverConnection.WriteToUDP(responseBytes, clientAddress)
+	if responseBytes != nil { // calls are discarded without reply, e.g. replays
+		serverConnection.WriteToUDP(responseBytes, clientAddress)
+	}
 
 	return nil
 }
 
-func handleClient(requestBytes []byte, rpcProcedures map[uint32]rpcProcedureHandler) (responseBytes []byte, err error) {
+// handleClient processes a single request received over connection, which describes
+// the caller, and returns the response
+func (rpcService *RPCService) handleClient(connection CallContext, requestBytes []byte) (responseBytes []byte, err error) {
 	rpcRequest, argumentsIndex, err := parseRPCCallBody(requestBytes)
 
-	if err != nil {
+	switch {
+	case errors.Is(err, errBadCredentials):
+		return authenticationError(rpcRequest.XID, AuthenticationBadCred)
+	case errors.Is(err, errBadVerifier):
+		return authenticationError(rpcRequest.XID, AuthenticationBadVerf)
+	case err != nil:
 		return responseBytes, errors.New("Malformed RPC request")
 	}
 
@@ -166,84 +457,170 @@ func handleClient(requestBytes []byte, rpcProcedures map[uint32]rpcProcedureHand
 		return xdr.Marshal(rpcMismatch)
 	}
 
-	// TODO how to check for ProgramMismatch properly?
-	/*
-		if rpcRequest.CBody.Program == 100000 && rpcRequest.CBody.ProgramVersion != 2 {
-			programVersionMismatch := &RPCMessage{
-				XID:         rpcRequest.XID,
-				MessageType: Reply,
-				RBody: ReplyBody{
-					ReplyStatus: MessageAccepted,
-					AReply: AcceptedReply{
-						AcceptState: ProgramMismatch,
-						MismatchInfo: MismatchInfo{
-							Low:  2,
-							High: 2,
-						},
-					},
-				},
-			}
+	call := &connection
+	call.XID = rpcRequest.XID
+	call.Program = rpcRequest.CBody.Program
+	call.ProgramVersion = rpcRequest.CBody.ProgramVersion
+	call.Procedure = rpcRequest.CBody.Procedure
+	call.Credentials = rpcRequest.CBody.Credentials
+	call.Verifier = rpcRequest.CBody.Verifier
+	call.header = requestBytes[:callHeaderLength(uint32(len(call.Credentials.Body)))]
+
+	if call.Credentials.Flavor == AuthenticationTLS && rpcService.tlsConfig != nil {
+		return rpcService.probeTLS(call)
+	}
 
-			return xdr.Marshal(programVersionMismatch)
+	program, found := rpcService.programs[call.Program]
+
+	if !found {
+		program = rpcService
+	}
+
+	return program.dispatch(call, requestBytes[argumentsIndex:])
+}
+
+// dispatch authenticates a call of the program of the service, and returns the reply
+// of the procedure called
+func (rpcService *RPCService) dispatch(call *CallContext, arguments []byte) (responseBytes []byte, err error) {
+	if rpcService.tlsRequired && call.TLS == nil && call.Procedure != 0 {
+		return authenticationError(call.XID, AuthenticationTooWeak)
+	}
+
+	authenticator, replyVerifier, stat := rpcService.authenticate(call)
+
+	switch stat {
+	case AuthenticationOK:
+	case authenticationDiscard:
+		return nil, nil
+	default:
+		return authenticationError(call.XID, stat)
+	}
+
+	protection, protected := authenticator.(callProtection)
+
+	if protected {
+		results, verifier, handled, err := protection.control(call, arguments)
+
+		if err != nil {
+			fmt.Println("Error: ", err.Error())
+			return unsuccessful(call.XID, replyVerifier, GarbageArguments)
 		}
-	*/
 
-	rpcProcedure, found := rpcProcedures[rpcRequest.CBody.Procedure]
+		if handled {
+			return success(call.XID, verifier, results)
+		}
+	}
+
+	if call.Program != rpcService.program {
+		return unsuccessful(call.XID, replyVerifier, ProgramUnavailable)
+	}
+
+	procedures, found := rpcService.procedures[call.ProgramVersion]
 
 	if !found {
-		procUnavail := &RPCMessage{
-			XID:         rpcRequest.XID,
+		low, high := rpcService.versionRange()
+
+		programMismatch := &RPCMessage{
+			XID:         call.XID,
 			MessageType: Reply,
 			RBody: ReplyBody{
 				ReplyStatus: MessageAccepted,
 				AReply: AcceptedReply{
-					Verf: OpaqueAuth{
-						Flavor: AuthenticationNull,
-						Body:   []byte{},
+					Verf:        replyVerifier,
+					AcceptState: ProgramMismatch,
+					MismatchInfo: MismatchInfo{
+						Low:  low,
+						High: high,
 					},
-					AcceptState: ProcedureUnavailable,
 				},
 			},
 		}
 
-		return xdr.Marshal(procUnavail)
+		return xdr.Marshal(programMismatch)
+	}
+
+	rpcProcedure, found := procedures[call.Procedure]
+
+	if !found {
+		return unsuccessful(call.XID, replyVerifier, ProcedureUnavailable)
 	}
 
-	procedureResponse, err := rpcProcedure(requestBytes[argumentsIndex:])
+	if protected {
+		arguments, err = protection.unwrapArguments(call, arguments)
+
+		if err != nil {
+			fmt.Println("Error: ", err.Error())
+			return unsuccessful(call.XID, replyVerifier, GarbageArguments)
+		}
+	}
+
+	var procedureResponse interface{}
+
+	if rpcService.cachedProcedures[procedureKey{version: call.ProgramVersion, procedure: call.Procedure}] {
+		// the key and the cached results are those of the unwrapped call, so that RPCSEC_GSS
+		// retransmits, which carry a new sequence number, hit the cache and get a reply
+		// protected for their own sequence number
+		key := newDuplicateRequestKey(call, arguments)
+		cachedResults, completed, seen := rpcService.duplicateRequestCache.start(key)
+
+		switch {
+		case seen && !completed: // a retransmit of a call still in progress - drop
+			return nil, nil
+		case seen: // a retransmit of a completed call - replay its results
+			procedureResponse = cachedResults
+		default:
+			procedureResponse, err = rpcService.invoke(call, rpcProcedure, arguments)
+
+			if err != nil {
+				rpcService.duplicateRequestCache.finish(key, nil)
+			} else {
+				rpcService.duplicateRequestCache.finish(key, procedureResponse)
+			}
+		}
+	} else {
+		procedureResponse, err = rpcService.invoke(call, rpcProcedure, arguments)
+	}
 
 	if err != nil {
 		fmt.Println("Error: ", err.Error())
+		return unsuccessful(call.XID, replyVerifier, GarbageArguments)
+	}
 
-		garbageArgs := &RPCMessage{
-			XID:         rpcRequest.XID,
-			MessageType: Reply,
-			RBody: ReplyBody{
-				ReplyStatus: MessageAccepted,
-				AReply: AcceptedReply{
-					Verf: OpaqueAuth{
-						Flavor: AuthenticationNull,
-						Body:   []byte{},
-					},
-					AcceptState: GarbageArguments,
-				},
-			},
+	if protected {
+		procedureResponse, err = protection.wrapResults(call, procedureResponse)
+
+		if err != nil {
+			return nil, err
 		}
+	}
+
+	return success(call.XID, replyVerifier, procedureResponse)
+}
+
+// invoke executes a procedure with the unwrapped arguments of a call, within the call
+// timeout if one is set
+func (rpcService *RPCService) invoke(call *CallContext, rpcProcedure rpcProcedureHandler, arguments []byte) (interface{}, error) {
+	if rpcService.callTimeout > 0 {
+		ctx, cancel := context.WithTimeout(call.Context(), rpcService.callTimeout)
+		defer cancel()
 
-		return xdr.Marshal(garbageArgs)
+		call.ctx = ctx
 	}
 
+	return rpcProcedure(call, arguments)
+}
+
+// success returns a reply with the results of a call
+func success(xid uint32, verifier OpaqueAuth, results interface{}) ([]byte, error) {
 	acceptedReply := &RPCMessage{
-		XID:         rpcRequest.XID,
+		XID:         xid,
 		MessageType: Reply,
 		RBody: ReplyBody{
 			ReplyStatus: MessageAccepted,
 			AReply: AcceptedReply{
-				Verf: OpaqueAuth{
-					Flavor: AuthenticationNull,
-					Body:   []byte{},
-				},
+				Verf:        verifier,
 				AcceptState: Success,
-				Results:     procedureResponse,
+				Results:     results,
 			},
 		},
 	}
@@ -251,80 +628,119 @@ func handleClient(requestBytes []byte, rpcProcedures map[uint32]rpcProcedureHand
 	return xdr.Marshal(acceptedReply)
 }
 
-func readNextRequestFragment(clientConnection net.Conn) (requestBytes []byte, isLastFragment bool, err error) {
-	var fragmentHeader uint32
-	fragmentHeaderBytes := make([]byte, 4)
-
-	_, err = clientConnection.Read(fragmentHeaderBytes)
-
-	if err != nil {
-		return requestBytes, isLastFragment, err
+// unsuccessful returns a reply to a call which was accepted but not executed for the
+// reason acceptState, e.g. GarbageArguments if the arguments can't be decoded
+func unsuccessful(xid uint32, verifier OpaqueAuth, acceptState uint32) ([]byte, error) {
+	acceptedReply := &RPCMessage{
+		XID:         xid,
+		MessageType: Reply,
+		RBody: ReplyBody{
+			ReplyStatus: MessageAccepted,
+			AReply: AcceptedReply{
+				Verf:        verifier,
+				AcceptState: acceptState,
+			},
+		},
 	}
 
-	fragmentHeaderBytesBuffer := bytes.NewBuffer(fragmentHeaderBytes)
+	return xdr.Marshal(acceptedReply)
+}
 
-	err = binary.Read(fragmentHeaderBytesBuffer, binary.BigEndian, &fragmentHeader)
+// errRecordTooLarge is wrapped by errors about records exceeding the maximum record size
+var errRecordTooLarge = errors.New("record too large")
 
-	if err != nil {
-		return requestBytes, isLastFragment, err
-	}
+// errEmptyFragment is returned for empty fragments other than the last of a record
+var errEmptyFragment = errors.New("Invalid empty fragment. Only the last fragment of a record may be empty")
 
-	isLastFragment = (fragmentHeader & LastFragment) != 0
-	remainingFragmentLength := uint32(fragmentHeader & ^LastFragment)
+// readRecord reads a record (RFC5531: 11. Record Marking Standard), joining its fragments.
+// Records longer than maxRecordSize are rejected.
+func readRecord(reader io.Reader, maxRecordSize uint32) ([]byte, error) {
+	record := []byte{}
+	fragments := 0
 
-	messageBytesBuffer := new(bytes.Buffer)
-	readBuffer := make([]byte, 1024)
+	fragmentHeader := make([]byte, 4)
 
-	for remainingFragmentLength > 0 { // TODO rewrite this
-		bytesRead, err := clientConnection.Read(readBuffer)
+	for {
+		_, err := io.ReadFull(reader, fragmentHeader)
+
+		if err == io.EOF && fragments > 0 { // the connection was closed within the record
+			err = io.ErrUnexpectedEOF
+		}
 
 		if err != nil {
-			return requestBytes, isLastFragment, err
+			return nil, err
 		}
 
-		_, err = messageBytesBuffer.Write(readBuffer)
+		header := binary.BigEndian.Uint32(fragmentHeader)
+		fragmentLength := header &^ LastFragment
 
-		if err != nil {
-			return requestBytes, isLastFragment, err
+		if uint64(len(record))+uint64(fragmentLength) > uint64(maxRecordSize) {
+			return nil, fmt.Errorf("Invalid length '%d' of record. Maximum value of '%d' allowed: %w", uint64(len(record))+uint64(fragmentLength), maxRecordSize, errRecordTooLarge)
 		}
 
-		remainingFragmentLength -= uint32(bytesRead)
-	}
+		if fragmentLength == 0 && header&LastFragment == 0 { // would let clients send endless records
+			return nil, errEmptyFragment
+		}
 
-	requestBytes = make([]byte, messageBytesBuffer.Len())
-	copy(requestBytes, messageBytesBuffer.Bytes())
+		fragment := make([]byte, fragmentLength)
 
-	return requestBytes, isLastFragment, nil
-}
+		_, err = io.ReadFull(reader, fragment)
 
-func writeResponseFragment(clientConnection net.Conn, responseBytes []byte, lastFragment bool) error {
-	var err error
-	fragmentBuffer := new(bytes.Buffer)
-	fragmentLength := uint32(len(responseBytes))
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 
-	if lastFragment {
-		err = binary.Write(fragmentBuffer, binary.BigEndian, LastFragment|fragmentLength)
-	} else {
-		err = binary.Write(fragmentBuffer, binary.BigEndian, fragmentLength)
-	}
+		if err != nil {
+			return nil, err
+		}
 
-	if err != nil {
-		return err
+		record = append(record, fragment...) // grows geometrically, unlike copying the record for every fragment
+		fragments++
+
+		if header&LastFragment != 0 {
+			return record, nil
+		}
 	}
+}
 
-	_, err = fragmentBuffer.Write(responseBytes)
+// writeRecord writes a record split into fragments of at most maxFragmentSize bytes.
+// The fragments are written at once, so that records written concurrently don't mix.
+func writeRecord(writer io.Writer, record []byte, maxFragmentSize uint32) error {
+	buffer := make([]byte, 0, len(record)+4*(len(record)/int(maxFragmentSize)+1))
+	fragmentHeader := make([]byte, 4)
 
-	if err != nil {
-		return err
-	}
+	for {
+		fragmentLength := uint32(len(record))
+		header := LastFragment | fragmentLength
 
-	_, err = clientConnection.Write(fragmentBuffer.Bytes())
+		if fragmentLength > maxFragmentSize {
+			fragmentLength = maxFragmentSize
+			header = fragmentLength
+		}
 
-	if err != nil {
-		return err
+		binary.BigEndian.PutUint32(fragmentHeader, header)
+		buffer = append(append(buffer, fragmentHeader...), record[:fragmentLength]...)
+		record = record[fragmentLength:]
+
+		if header&LastFragment != 0 {
+			break
+		}
 	}
 
-	return nil
+	_, err := writer.Write(buffer)
+
+	return err
+}
+
+// xdrPadding returns the number of bytes padding opaque data of the given length to a multiple of four
+func xdrPadding(length uint32) int {
+	return int((4 - length%4) % 4)
+}
+
+// callHeaderLength returns the length of the call header up to and including credentials
+// with a body of the given length
+func callHeaderLength(credentialsLength uint32) int {
+	return 8*4 + int(credentialsLength) + xdrPadding(credentialsLength) // XID up to the length of the body
 }
 
 func parseRPCCallBody(requestBytes []byte) (rpcCallBody RPCMessage, bytesRead int, err error) {
@@ -382,7 +798,7 @@ func parseRPCCallBody(requestBytes []byte) (rpcCallBody RPCMessage, bytesRead in
 
 	if credentialsLength > OpaqueAuthBodyMaxLength {
 		return rpcCallBody, len(requestBytes) - requestBuffer.Len(),
-			fmt.Errorf("Invalid length '%d' for Credentials in CallBody. Maximum value of '%d' allowed", credentialsLength, OpaqueAuthBodyMaxLength)
+			fmt.Errorf("Invalid length '%d' for Credentials in CallBody. Maximum value of '%d' allowed: %w", credentialsLength, OpaqueAuthBodyMaxLength, errBadCredentials)
 	}
 
 	rpcCallBody.CBody.Credentials.Body = make([]byte, credentialsLength)
@@ -393,6 +809,8 @@ func parseRPCCallBody(requestBytes []byte) (rpcCallBody RPCMessage, bytesRead in
 		return rpcCallBody, len(requestBytes) - requestBuffer.Len(), err
 	}
 
+	requestBuffer.Next(xdrPadding(credentialsLength))
+
 	err = binary.Read(requestBuffer, binary.BigEndian, &rpcCallBody.CBody.Verifier.Flavor)
 
 	if err != nil {
@@ -409,7 +827,7 @@ func parseRPCCallBody(requestBytes []byte) (rpcCallBody RPCMessage, bytesRead in
 
 	if verifierLength > OpaqueAuthBodyMaxLength {
 		return rpcCallBody, len(requestBytes) - requestBuffer.Len(),
-			fmt.Errorf("Invalid length '%d' for Verifier in CallBody. Maximum value of '%d' allowed", verifierLength, OpaqueAuthBodyMaxLength)
+			fmt.Errorf("Invalid length '%d' for Verifier in CallBody. Maximum value of '%d' allowed: %w", verifierLength, OpaqueAuthBodyMaxLength, errBadVerifier)
 	}
 
 	rpcCallBody.CBody.Verifier.Body = make([]byte, verifierLength)
@@ -420,5 +838,7 @@ func parseRPCCallBody(requestBytes []byte) (rpcCallBody RPCMessage, bytesRead in
 		return rpcCallBody, len(requestBytes) - requestBuffer.Len(), err
 	}
 
+	requestBuffer.Next(xdrPadding(verifierLength))
+
 	return rpcCallBody, len(requestBytes) - requestBuffer.Len(), nil
 }
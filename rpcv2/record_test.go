@@ -0,0 +1,133 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpcv2_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// newRecordService returns a connection to a service whose test procedure returns its
+// arguments as opaque data, and a channel receiving the result of serving the connection
+func newRecordService(maxRecordSize uint32, maxFragmentSize uint32) (net.Conn, chan error) {
+	rpcService := rpcv2.NewRPCService("test", testProgram, testVersion)
+	rpcService.SetMaxRecordSize(maxRecordSize)
+	rpcService.SetMaxFragmentSize(maxFragmentSize)
+	rpcService.RegisterProcedure(testProcedure, func(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+		return arg, nil
+	})
+
+	serverConn, clientConn := net.Pipe()
+	done := make(chan error, 1)
+
+	go func() {
+		done <- rpcService.ServeConn(serverConn)
+	}()
+
+	return clientConn, done
+}
+
+func TestRecordFragments(t *testing.T) {
+	conn, done := newRecordService(rpcv2.DefaultMaxRecordSize, 16)
+	defer conn.Close()
+
+	arguments := bytes.Repeat([]byte("fragment"), 10)
+
+	callBytes, err := xdr.Marshal(nullCall(1, testProcedure))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	callBytes = append(callBytes, arguments...)
+
+	// send the call in fragments of 7 bytes
+	for len(callBytes) > 0 {
+		length := uint32(len(callBytes))
+		header := length | rpcv2.LastFragment
+
+		if length > 7 {
+			length = 7
+			header = length
+		}
+
+		fragment := make([]byte, 4)
+		binary.BigEndian.PutUint32(fragment, header)
+
+		_, err = conn.Write(append(fragment, callBytes[:length]...))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		callBytes = callBytes[length:]
+	}
+
+	replyBytes, fragments := readRecord(t, conn)
+
+	var reply testReply
+
+	bytesRead, err := xdr.Unmarshal(replyBytes, &reply)
+	if err != nil || reply.Accepted.AcceptState != rpcv2.Success {
+		t.Fatalf("Expected %v but got %+v (%v)", rpcv2.Success, reply, err)
+	}
+
+	var results []byte
+
+	_, err = xdr.Unmarshal(replyBytes[bytesRead:], &results)
+	if err != nil || !bytes.Equal(results, arguments) {
+		t.Fatalf("Expected %q but got %q (%v)", arguments, results, err)
+	}
+
+	if expected := (len(replyBytes) + 15) / 16; fragments != expected {
+		t.Fatalf("Expected %v but got %v", expected, fragments)
+	}
+
+	conn.Close()
+
+	if err := <-done; err != nil {
+		t.Fatalf("Expected %v but got %v", nil, err)
+	}
+}
+
+func TestMaxRecordSize(t *testing.T) {
+	conn, done := newRecordService(64, 0)
+	defer conn.Close()
+
+	// the second fragment exceeds the maximum record size
+	for _, header := range []uint32{40, 40 | rpcv2.LastFragment} {
+		fragment := make([]byte, 44)
+		binary.BigEndian.PutUint32(fragment, header)
+
+		_, err := conn.Write(fragment)
+		if err != nil {
+			break // the server closed the connection
+		}
+	}
+
+	if err := <-done; err == nil {
+		t.Fatalf("Expected error but got %v", err)
+	}
+}
+
+func TestEmptyFragment(t *testing.T) {
+	conn, done := newRecordService(rpcv2.DefaultMaxRecordSize, 0)
+	defer conn.Close()
+
+	// empty fragments which don't end the record are rejected
+	for i := 0; i < 2; i++ {
+		_, err := conn.Write(make([]byte, 4))
+		if err != nil {
+			break // the server closed the connection
+		}
+	}
+
+	if err := <-done; err == nil {
+		t.Fatalf("Expected error but got %v", err)
+	}
+}
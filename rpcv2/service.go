@@ -5,10 +5,49 @@
 package rpcv2
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"time"
+)
+
+// Default limits of records sent over TCP
+const (
+	DefaultMaxRecordSize   uint32 = 4 << 20 // longest record accepted, large enough for 1 MB writes
+	DefaultMaxFragmentSize uint32 = 1 << 20 // longest fragment of records sent
+)
+
+// DefaultMaxWorkers is the default number of calls processed concurrently
+const DefaultMaxWorkers = 64
+
+// Bounds of the delay before accepting connections or reading datagrams again after
+// errors, such as EMFILE when running out of file descriptors
+const (
+	minRetryDelay = 5 * time.Millisecond
+	maxRetryDelay = time.Second
+)
+
+// retryDelay returns the delay before trying again after another error, doubling the
+// previous delay up to maxRetryDelay
+func retryDelay(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return minRetryDelay
+	}
+
+	if delay *= 2; delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+
+	return delay
+}
+
+// Transports over which calls are received
+const (
+	TransportTCP = "tcp"
+	TransportUDP = "udp"
 )
 
 type udpClient struct {
@@ -17,20 +56,77 @@ type udpClient struct {
 	clientAddress    *net.UDPAddr
 }
 
-type rpcProcedureHandler func([]byte) (interface{}, error)
+// CallContext describes the RPC call a procedure is invoked for
+type CallContext struct {
+	XID            uint32               // transaction ID chosen by the caller
+	Program        uint32               // RPC program number
+	ProgramVersion uint32               // version of the program requested by the caller
+	Procedure      uint32               // RPC procedure number
+	Credentials    OpaqueAuth           // credentials of the caller as sent in the call header
+	Unix           *AuthUnixParms       // credentials of AUTH_UNIX and AUTH_SHORT callers, nil for other flavors
+	Verifier       OpaqueAuth           // verifier of the credentials as sent in the call header
+	RemoteAddr     net.Addr             // network address of the caller
+	Transport      string               // TransportTCP or TransportUDP
+	Principal      string               // name of callers authenticated with RPCSEC_GSS, empty otherwise
+	TLS            *tls.ConnectionState // state of connections secured with TLS, nil otherwise
+	ctx            context.Context
+	connection     *tcpConnection // state of the TCP connection, nil for UDP
+	header         []byte         // call header from the XID up to and including the credentials
+	gss            *gssCall       // state of calls authenticated with RPCSEC_GSS
+}
+
+// Context returns the context of the call. It is cancelled when the connection of
+// the caller is closed or the timeout configured with SetCallTimeout expires.
+func (call *CallContext) Context() context.Context {
+	if call.ctx != nil {
+		return call.ctx
+	}
+
+	return context.Background()
+}
+
+// WithContext returns a copy of the call with its context changed to ctx
+func (call *CallContext) WithContext(ctx context.Context) *CallContext {
+	callCopy := *call
+	callCopy.ctx = ctx
+
+	return &callCopy
+}
+
+type rpcProcedureHandler func(*CallContext, []byte) (interface{}, error)
+
+// procedureKey identifies a procedure of a version of the program
+type procedureKey struct {
+	version   uint32
+	procedure uint32
+}
 
 // RPCService represents an RPC service
 type RPCService struct {
-	shortName    string // friendly name (for logging)
-	program      uint32 // RPC program number
-	version      uint32 // RPC program version
-	tcpClients   chan net.Conn
-	tcpListeners []net.Listener
-	udpClients   chan udpClient
-	udpListeners []*net.UDPConn
-	procedures   map[uint32]rpcProcedureHandler
-	listening    bool
-	waitGroup    sync.WaitGroup
+	shortName             string // friendly name (for logging)
+	program               uint32 // RPC program number
+	version               uint32 // RPC program version
+	tcpClients            chan net.Conn
+	tcpListeners          []net.Listener
+	udpClients            chan udpClient
+	udpListeners          []*net.UDPConn
+	procedures            map[uint32]map[uint32]rpcProcedureHandler // procedures by version and number
+	programs              map[uint32]*RPCService                    // further programs served on the listeners of the service
+	authenticators        map[uint32]Authenticator                  // authenticators by accepted flavor
+	callTimeout           time.Duration                             // deadline of the context of each call, zero for none
+	tlsConfig             *tls.Config                               // configuration of RPC-over-TLS, nil if not offered
+	tlsRequired           bool                                      // reject calls on connections without TLS
+	maxRecordSize         uint32                                    // longest record accepted over TCP
+	maxFragmentSize       uint32                                    // longest fragment of records sent over TCP
+	workers               chan struct{}                             // holds a token for every call in progress
+	duplicateRequestCache *DuplicateRequestCache                    // results of calls of cachedProcedures
+	cachedProcedures      map[procedureKey]bool                     // procedures whose results are cached
+	mutex                 sync.Mutex                                // protects the listeners, connections and shuttingDown
+	connections           map[*tcpConnection]bool                   // TCP connections being served
+	shuttingDown          bool                                      // set once Shutdown is called
+	closing               chan struct{}                             // closed once Shutdown is called
+	handlers              sync.WaitGroup                            // TCP connections and UDP calls being served
+	waitGroup             sync.WaitGroup                            // goroutines reading from the listeners
 }
 
 // NewRPCService returns a new RPC service
@@ -41,8 +137,17 @@ func NewRPCService(shortName string, program uint32, version uint32) *RPCService
 		version:    version,
 		tcpClients: make(chan net.Conn),
 		udpClients: make(chan udpClient),
-		procedures: make(map[uint32]rpcProcedureHandler),
-		listening:  false,
+		procedures: make(map[uint32]map[uint32]rpcProcedureHandler),
+		programs:   make(map[uint32]*RPCService),
+		authenticators: map[uint32]Authenticator{
+			AuthenticationNull: NullAuthenticator{},
+			AuthenticationUNIX: NewUnixAuthenticator(0),
+		},
+		maxRecordSize:   DefaultMaxRecordSize,
+		maxFragmentSize: DefaultMaxFragmentSize,
+		workers:         make(chan struct{}, DefaultMaxWorkers),
+		connections:     make(map[*tcpConnection]bool),
+		closing:         make(chan struct{}),
 	}
 
 	return rpcService
@@ -60,25 +165,41 @@ func (rpcService *RPCService) AddListener(network string, address string) (err e
 
 		fmt.Printf("[%s] Listening on TCP %s\n", rpcService.shortName, tcpListener.Addr())
 
-		rpcService.listening = true
+		rpcService.mutex.Lock()
 		rpcService.tcpListeners = append(rpcService.tcpListeners, tcpListener)
+		rpcService.mutex.Unlock()
 		rpcService.waitGroup.Add(1)
 
 		go func() {
-			for rpcService.listening {
+			defer rpcService.waitGroup.Done()
+
+			var delay time.Duration // how long to wait after errors, doubled on each consecutive one
+
+			for {
 				clientConnection, err := tcpListener.Accept()
 
-				if rpcService.listening { // closing the tcpListener in RemoveAllListeners() will cause an accept error - ignore
-					if err != nil {
-						fmt.Printf("[%s] Error: %s\n", rpcService.shortName, err.Error())
-					} else {
-						fmt.Printf("[%s] Received TCP request from %s\n", rpcService.shortName, clientConnection.RemoteAddr())
-						rpcService.tcpClients <- clientConnection
+				if err != nil {
+					if !rpcService.hasTCPListener(tcpListener) { // closed by RemoveAllListeners() - stop
+						return
 					}
+
+					fmt.Printf("[%s] Error: %s\n", rpcService.shortName, err.Error())
+
+					delay = retryDelay(delay)
+					time.Sleep(delay)
+					continue
 				}
-			}
 
-			rpcService.waitGroup.Done()
+				delay = 0
+
+				fmt.Printf("[%s] Received TCP request from %s\n", rpcService.shortName, clientConnection.RemoteAddr())
+
+				select {
+				case rpcService.tcpClients <- clientConnection:
+				case <-rpcService.closing:
+					clientConnection.Close()
+				}
+			}
 		}()
 	case "udp", "udp4", "udp6":
 		serverAddress, err := net.ResolveUDPAddr(network, address)
@@ -95,11 +216,14 @@ func (rpcService *RPCService) AddListener(network string, address string) (err e
 
 		fmt.Printf("[%s] Listening on UDP %s\n", rpcService.shortName, serverAddress)
 
-		rpcService.listening = true
+		rpcService.mutex.Lock()
 		rpcService.udpListeners = append(rpcService.udpListeners, serverConnection)
+		rpcService.mutex.Unlock()
 		rpcService.waitGroup.Add(1)
 
 		go func() {
+			defer rpcService.waitGroup.Done()
+
 			// What is the largest UDP datagram for an RPC request? RFC1057 does not mention a maximum size.
 			// The Linux NFS page https://wiki.linux-nfs.org/wiki/index.php?title=NetworkTracing&oldid=2945#RPC_over_UDP_datagrams
 			// talks about a maximum size of 64 KB, without giving any sources. This value probably originates
@@ -108,30 +232,41 @@ func (rpcService *RPCService) AddListener(network string, address string) (err e
 			// safe upper limit.
 			b := make([]byte, 65536)
 
-			for rpcService.listening {
+			var delay time.Duration // how long to wait after errors, doubled on each consecutive one
+
+			for {
 				n, clientAddress, err := serverConnection.ReadFromUDP(b)
 
-				if rpcService.listening { // closing the udpListener in RemoveAllListeners() will cause a read error - ignore
-					if err != nil {
-						fmt.Printf("[%s] Error: %s\n", rpcService.shortName, err.Error())
-					} else {
-						fmt.Printf("[%s] Received UDP request from %s\n", rpcService.shortName, clientAddress)
+				if err != nil {
+					if !rpcService.hasUDPListener(serverConnection) { // closed by RemoveAllListeners() - stop
+						return
+					}
 
-						requestBytes := make([]byte, n)
-						copy(requestBytes, b)
+					fmt.Printf("[%s] Error: %s\n", rpcService.shortName, err.Error())
 
-						udpClient := udpClient{
-							requestBytes:     requestBytes,
-							serverConnection: serverConnection,
-							clientAddress:    clientAddress,
-						}
+					delay = retryDelay(delay)
+					time.Sleep(delay)
+					continue
+				}
 
-						rpcService.udpClients <- udpClient
-					}
+				delay = 0
+
+				fmt.Printf("[%s] Received UDP request from %s\n", rpcService.shortName, clientAddress)
+
+				requestBytes := make([]byte, n)
+				copy(requestBytes, b)
+
+				udpClient := udpClient{
+					requestBytes:     requestBytes,
+					serverConnection: serverConnection,
+					clientAddress:    clientAddress,
 				}
-			}
 
-			rpcService.waitGroup.Done()
+				select {
+				case rpcService.udpClients <- udpClient:
+				case <-rpcService.closing:
+				}
+			}
 		}()
 	default:
 		return errors.New("Invalid network provided. Valid options are: tcp, tcp4, tpc6, udp, udp4 or udp6")
@@ -140,42 +275,237 @@ func (rpcService *RPCService) AddListener(network string, address string) (err e
 	return nil
 }
 
-// HandleClients accepts and processes clients
+// HandleClients accepts and processes clients until the service is shut down
 func (rpcService *RPCService) HandleClients() {
-	var err error
+	rpcService.logError(rpcService.Serve(context.Background()))
+}
 
+// Serve accepts and processes clients. Every TCP connection is served by a goroutine
+// of its own, and calls are processed concurrently by a pool of workers whose size is
+// set with SetMaxWorkers. Serve returns ErrServiceClosed once Shutdown is called. If
+// ctx is done before, the service is shut down without waiting for calls in progress,
+// and Serve returns the error of ctx.
+func (rpcService *RPCService) Serve(ctx context.Context) error {
 	for {
 		select {
 		case clientConnection := <-rpcService.tcpClients:
-			err = handleTCPClient(clientConnection, rpcService.procedures)
+			go func() {
+				rpcService.logError(rpcService.handleTCPClient(clientConnection))
+			}()
 		case udpClient := <-rpcService.udpClients:
-			err = handleUDPClient(udpClient.requestBytes, udpClient.serverConnection, udpClient.clientAddress, rpcService.procedures)
-		}
+			if !rpcService.startHandler() {
+				continue
+			}
 
-		if err != nil {
-			fmt.Printf("[%s] Error: %s\n", rpcService.shortName, err.Error())
+			go func() {
+				rpcService.workers <- struct{}{} // wait for a worker to become available, without holding up other clients
+
+				defer func() {
+					<-rpcService.workers
+					rpcService.handlers.Done()
+				}()
+
+				rpcService.logError(rpcService.handleUDPClient(udpClient.requestBytes, udpClient.serverConnection, udpClient.clientAddress))
+			}()
+		case <-rpcService.closing:
+			return ErrServiceClosed
+		case <-ctx.Done():
+			rpcService.Shutdown(ctx)
+			return ctx.Err()
 		}
 	}
 }
 
+// logError logs errors which occurred while handling clients
+func (rpcService *RPCService) logError(err error) {
+	if err != nil && err != ErrServiceClosed {
+		fmt.Printf("[%s] Error: %s\n", rpcService.shortName, err.Error())
+	}
+}
+
+// ServeConn handles the calls received over a single connection until the client
+// closes it or sends an invalid record, and closes the connection. It blocks until
+// the connection is done. Connections are closed by Shutdown like the ones accepted
+// by Serve, and ErrServiceClosed is returned once the service is shut down.
+func (rpcService *RPCService) ServeConn(conn net.Conn) error {
+	return rpcService.handleTCPClient(conn)
+}
+
 // RegisterProcedure registers a callback function for a given RPC procedure number
+// of the version of the program the service was created with
 func (rpcService *RPCService) RegisterProcedure(procedure uint32, rpcProcedureHandler rpcProcedureHandler) {
-	rpcService.procedures[procedure] = rpcProcedureHandler
+	rpcService.RegisterVersionProcedure(rpcService.version, procedure, rpcProcedureHandler)
+}
+
+// RegisterVersionProcedure registers a callback function for a given RPC procedure
+// number of another version of the program. Calls of versions without procedures are
+// rejected with PROG_MISMATCH.
+func (rpcService *RPCService) RegisterVersionProcedure(version uint32, procedure uint32, handler rpcProcedureHandler) {
+	if rpcService.procedures[version] == nil {
+		rpcService.procedures[version] = make(map[uint32]rpcProcedureHandler)
+	}
+
+	rpcService.procedures[version][procedure] = handler
+}
+
+// versionRange returns the lowest and highest version of the program with procedures
+func (rpcService *RPCService) versionRange() (low uint32, high uint32) {
+	low = ^uint32(0)
+
+	for version := range rpcService.procedures {
+		if version < low {
+			low = version
+		}
+
+		if version > high {
+			high = version
+		}
+	}
+
+	return low, high
+}
+
+// AddProgram serves the program of another service on the listeners of this service,
+// so that several programs share a port. Calls of the program are dispatched to the
+// procedures of the other service, and authenticated by its authenticators. The other
+// service need not listen itself. Programs must be added before clients are handled.
+func (rpcService *RPCService) AddProgram(program *RPCService) {
+	rpcService.programs[program.program] = program
+}
+
+// RegisterAuthenticator accepts calls with credentials of the given flavor, which are
+// verified by authenticator. Services accept AUTH_NULL and AUTH_UNIX by default.
+// Authenticators must be registered before clients are handled.
+func (rpcService *RPCService) RegisterAuthenticator(flavor uint32, authenticator Authenticator) {
+	rpcService.authenticators[flavor] = authenticator
+}
+
+// UnregisterAuthenticator rejects calls with credentials of the given flavor with
+// AUTH_TOOWEAK. The NULL procedure remains available with AUTH_NULL, so that clients
+// can probe the service.
+func (rpcService *RPCService) UnregisterAuthenticator(flavor uint32) {
+	delete(rpcService.authenticators, flavor)
+}
+
+// authenticate verifies the credentials of a call with the authenticator registered
+// for its flavor, and returns the authenticator and the verifier of the reply or an auth_stat
+func (rpcService *RPCService) authenticate(call *CallContext) (Authenticator, OpaqueAuth, uint32) {
+	authenticator, found := rpcService.authenticators[call.Credentials.Flavor]
+
+	if !found {
+		if call.Procedure != 0 || call.Credentials.Flavor != AuthenticationNull {
+			return nil, OpaqueAuth{}, AuthenticationTooWeak
+		}
+
+		authenticator = NullAuthenticator{}
+	}
+
+	verifier, stat := authenticator.Authenticate(call)
+
+	return authenticator, verifier, stat
+}
+
+// SetCallTimeout sets the deadline of the context procedures are called with. A
+// timeout of zero, the default, leaves calls without deadline.
+func (rpcService *RPCService) SetCallTimeout(timeout time.Duration) {
+	rpcService.callTimeout = timeout
+}
+
+// SetDuplicateRequestCache caches the results of calls of the given procedures of the
+// version of the program the service was created with. These are typically procedures
+// which aren't idempotent, so that retransmits aren't executed twice. A nil cache
+// disables caching. It must be set before clients are handled.
+func (rpcService *RPCService) SetDuplicateRequestCache(cache *DuplicateRequestCache, procedures ...uint32) {
+	rpcService.duplicateRequestCache = cache
+	rpcService.cachedProcedures = make(map[procedureKey]bool)
+
+	if cache == nil {
+		return
+	}
+
+	for _, procedure := range procedures {
+		rpcService.cachedProcedures[procedureKey{version: rpcService.version, procedure: procedure}] = true
+	}
+}
+
+// DuplicateRequestCache returns the duplicate request cache of the service, or nil if
+// there is none
+func (rpcService *RPCService) DuplicateRequestCache() *DuplicateRequestCache {
+	return rpcService.duplicateRequestCache
+}
+
+// SetMaxWorkers sets the number of calls processed concurrently. Further calls wait
+// for a call in progress to complete. It must be set before clients are handled.
+func (rpcService *RPCService) SetMaxWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	rpcService.workers = make(chan struct{}, workers)
+}
+
+// SetMaxRecordSize sets the size of the longest record accepted over TCP. Connections
+// sending longer records are closed.
+func (rpcService *RPCService) SetMaxRecordSize(size uint32) {
+	rpcService.maxRecordSize = size
+}
+
+// SetMaxFragmentSize sets the size of the longest fragment sent over TCP. Longer
+// replies are split into several fragments. A size of zero sends replies in as few
+// fragments as possible.
+func (rpcService *RPCService) SetMaxFragmentSize(size uint32) {
+	if size == 0 || size > ^LastFragment {
+		size = ^LastFragment
+	}
+
+	rpcService.maxFragmentSize = size
 }
 
 // RemoveAllListeners stops all UDP and TCP listeners, and removes them
 func (rpcService *RPCService) RemoveAllListeners() {
-	rpcService.listening = false
+	rpcService.mutex.Lock()
+	tcpListeners, udpListeners := rpcService.tcpListeners, rpcService.udpListeners
+	rpcService.tcpListeners = make([]net.Listener, 0)
+	rpcService.udpListeners = make([]*net.UDPConn, 0)
+	rpcService.mutex.Unlock()
 
-	for _, tcpListener := range rpcService.tcpListeners {
+	// closing the listeners causes errors in the goroutines reading from them, which
+	// stop as the listeners are no longer found
+	for _, tcpListener := range tcpListeners {
 		tcpListener.Close()
 	}
-	rpcService.tcpListeners = make([]net.Listener, 0)
 
-	for _, udpListener := range rpcService.udpListeners {
+	for _, udpListener := range udpListeners {
 		udpListener.Close()
 	}
-	rpcService.udpListeners = make([]*net.UDPConn, 0)
+}
+
+// hasTCPListener returns whether a TCP listener was added and not removed since
+func (rpcService *RPCService) hasTCPListener(tcpListener net.Listener) bool {
+	rpcService.mutex.Lock()
+	defer rpcService.mutex.Unlock()
+
+	for _, listener := range rpcService.tcpListeners {
+		if listener == tcpListener {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasUDPListener returns whether a UDP listener was added and not removed since
+func (rpcService *RPCService) hasUDPListener(udpListener *net.UDPConn) bool {
+	rpcService.mutex.Lock()
+	defer rpcService.mutex.Unlock()
+
+	for _, listener := range rpcService.udpListeners {
+		if listener == udpListener {
+			return true
+		}
+	}
+
+	return false
 }
 
 // WaitUntilDone is a blocking call that waits until all listeners are stopped
@@ -0,0 +1,441 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpcv2_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dlorch/base-nfs/rpcv2"
+	"github.com/dlorch/base-nfs/xdr"
+)
+
+// newBlockingService returns a connection to a service whose test procedure reports
+// the XID of each call on started, and blocks calls with XID 1 until release is closed
+func newBlockingService(workers int) (net.Conn, chan uint32, chan struct{}) {
+	started := make(chan uint32, 2)
+	release := make(chan struct{})
+
+	rpcService := rpcv2.NewRPCService("test", testProgram, testVersion)
+	rpcService.SetMaxWorkers(workers)
+	rpcService.RegisterProcedure(testProcedure, func(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+		started <- call.XID
+
+		if call.XID == 1 {
+			<-release
+		}
+
+		return rpcv2.Void{}, nil
+	})
+
+	serverConn, clientConn := net.Pipe()
+
+	go rpcService.ServeConn(serverConn)
+
+	return clientConn, started, release
+}
+
+// readXID returns the XID of the next reply
+func readXID(t *testing.T, conn net.Conn) uint32 {
+	replyBytes, _ := readRecord(t, conn)
+
+	var reply testReply
+
+	_, err := xdr.Unmarshal(replyBytes, &reply)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return reply.XID
+}
+
+func TestPipelinedCalls(t *testing.T) {
+	conn, _, release := newBlockingService(rpcv2.DefaultMaxWorkers)
+	defer conn.Close()
+
+	writeCall(t, conn, nullCall(1, testProcedure), nil)
+	writeCall(t, conn, nullCall(2, testProcedure), nil)
+
+	// the reply to the second call overtakes the blocked first call
+	if xid := readXID(t, conn); xid != 2 {
+		t.Fatalf("Expected %v but got %v", 2, xid)
+	}
+
+	close(release)
+
+	if xid := readXID(t, conn); xid != 1 {
+		t.Fatalf("Expected %v but got %v", 1, xid)
+	}
+}
+
+func TestMaxWorkers(t *testing.T) {
+	conn, started, release := newBlockingService(1)
+	defer conn.Close()
+
+	writeCall(t, conn, nullCall(1, testProcedure), nil)
+
+	if xid := <-started; xid != 1 {
+		t.Fatalf("Expected %v but got %v", 1, xid)
+	}
+
+	writeCall(t, conn, nullCall(2, testProcedure), nil)
+
+	select {
+	case xid := <-started:
+		t.Fatalf("Expected call %v to wait for a worker but it started", xid)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	for _, expected := range []uint32{1, 2} {
+		if xid := readXID(t, conn); xid != expected {
+			t.Fatalf("Expected %v but got %v", expected, xid)
+		}
+	}
+}
+
+func TestProgramVersions(t *testing.T) {
+	rpcService := rpcv2.NewRPCService("test", testProgram, testVersion)
+	rpcService.RegisterProcedure(0, func(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+		return rpcv2.Void{}, nil
+	})
+	rpcService.RegisterVersionProcedure(3, 0, func(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+		return rpcv2.Void{}, nil
+	})
+
+	serverConn, conn := net.Pipe()
+	defer conn.Close()
+
+	go rpcService.ServeConn(serverConn)
+
+	tests := []struct {
+		program     uint32
+		version     uint32
+		procedure   uint32
+		acceptState uint32
+	}{
+		{testProgram, testVersion, 0, rpcv2.Success},
+		{testProgram, 3, 0, rpcv2.Success},
+		{testProgram, 3, 1, rpcv2.ProcedureUnavailable},
+		{testProgram, 2, 0, rpcv2.ProgramMismatch},
+		{testProgram + 1, testVersion, 0, rpcv2.ProgramUnavailable},
+	}
+
+	for i, test := range tests {
+		call := nullCall(uint32(i), test.procedure)
+		call.CBody.Program = test.program
+		call.CBody.ProgramVersion = test.version
+
+		writeCall(t, conn, call, nil)
+
+		reply, results := readReply(t, conn, uint32(i))
+		if reply.ReplyStatus != rpcv2.MessageAccepted || reply.Accepted.AcceptState != test.acceptState {
+			t.Fatalf("Expected %v but got %+v (test %d)", test.acceptState, reply, i)
+		}
+
+		if test.acceptState == rpcv2.ProgramMismatch {
+			var mismatchInfo rpcv2.MismatchInfo
+
+			xdr.Unmarshal(results, &mismatchInfo)
+
+			if mismatchInfo.Low != testVersion || mismatchInfo.High != 3 {
+				t.Fatalf("Expected versions %v to %v but got %+v", testVersion, 3, mismatchInfo)
+			}
+		}
+	}
+}
+
+func TestAddProgram(t *testing.T) {
+	services := make([]*rpcv2.RPCService, 2)
+
+	for i := range services {
+		result := uint32(i)
+
+		services[i] = rpcv2.NewRPCService("test", testProgram+result, testVersion)
+		services[i].RegisterProcedure(testProcedure, func(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+			return result, nil
+		})
+	}
+
+	services[0].AddProgram(services[1])
+	services[1].UnregisterAuthenticator(rpcv2.AuthenticationNull)
+
+	serverConn, conn := net.Pipe()
+	defer conn.Close()
+
+	go services[0].ServeConn(serverConn)
+
+	for i, program := range []uint32{testProgram, testProgram + 1} {
+		call := nullCall(uint32(i), testProcedure)
+		call.CBody.Program = program
+		call.CBody.Credentials.Flavor = rpcv2.AuthenticationUNIX
+		call.CBody.Credentials.Body, _ = xdr.Marshal(&rpcv2.AuthUnixParms{MachineName: "client", GIDs: []uint32{}})
+
+		writeCall(t, conn, call, nil)
+
+		reply, results := readReply(t, conn, uint32(i))
+		if reply.Accepted.AcceptState != rpcv2.Success || binary.BigEndian.Uint32(results) != uint32(i) {
+			t.Fatalf("Expected result %v but got %+v (%v)", i, reply, results)
+		}
+	}
+
+	// calls of the added program are authenticated by its own authenticators
+	call := nullCall(2, testProcedure)
+	call.CBody.Program = testProgram + 1
+
+	writeCall(t, conn, call, nil)
+
+	reply, _ := readReply(t, conn, 2)
+	if reply.ReplyStatus != rpcv2.MessageDenied || reply.Rejected.Stat != rpcv2.AuthenticationTooWeak {
+		t.Fatalf("Expected %v but got %+v", rpcv2.AuthenticationTooWeak, reply)
+	}
+
+	call = nullCall(3, testProcedure)
+	call.CBody.Program = testProgram + 2
+
+	writeCall(t, conn, call, nil)
+
+	reply, _ = readReply(t, conn, 3)
+	if reply.Accepted.AcceptState != rpcv2.ProgramUnavailable {
+		t.Fatalf("Expected %v but got %+v", rpcv2.ProgramUnavailable, reply)
+	}
+}
+
+// newShutdownService returns a service whose test procedure reports the XID of each
+// call on started, and blocks until release is closed or the context of the call is
+// cancelled
+func newShutdownService() (*rpcv2.RPCService, chan uint32, chan struct{}) {
+	started := make(chan uint32, 2)
+	release := make(chan struct{})
+
+	rpcService := rpcv2.NewRPCService("test", testProgram, testVersion)
+	rpcService.RegisterProcedure(testProcedure, func(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+		started <- call.XID
+
+		select {
+		case <-release:
+		case <-call.Context().Done():
+		}
+
+		return rpcv2.Void{}, nil
+	})
+
+	return rpcService, started, release
+}
+
+// serveConn returns a connection served by rpcService, and a channel receiving the
+// result of ServeConn
+func serveConn(rpcService *rpcv2.RPCService) (net.Conn, chan error) {
+	serverConn, clientConn := net.Pipe()
+	served := make(chan error, 1)
+
+	go func() {
+		served <- rpcService.ServeConn(serverConn)
+	}()
+
+	return clientConn, served
+}
+
+func TestShutdown(t *testing.T) {
+	rpcService, started, release := newShutdownService()
+
+	serving := make(chan error, 1)
+
+	go func() {
+		serving <- rpcService.Serve(context.Background())
+	}()
+
+	busyConn, busyServed := serveConn(rpcService)
+	defer busyConn.Close()
+
+	idleConn, idleServed := serveConn(rpcService)
+	defer idleConn.Close()
+
+	writeCall(t, busyConn, nullCall(1, testProcedure), nil)
+	<-started
+
+	shutdown := make(chan error, 1)
+
+	go func() {
+		shutdown <- rpcService.Shutdown(context.Background())
+	}()
+
+	// idle connections are closed right away
+	if _, err := idleConn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Expected %v but got %v", io.EOF, err)
+	}
+
+	if err := <-idleServed; err != nil {
+		t.Fatalf("Expected %v but got %v", nil, err)
+	}
+
+	if err := <-serving; err != rpcv2.ErrServiceClosed {
+		t.Fatalf("Expected %v but got %v", rpcv2.ErrServiceClosed, err)
+	}
+
+	// calls in progress are finished before
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Expected Shutdown to wait for the call in progress but got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	if xid := readXID(t, busyConn); xid != 1 {
+		t.Fatalf("Expected %v but got %v", 1, xid)
+	}
+
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Expected %v but got %v", nil, err)
+	}
+
+	if err := <-busyServed; err != nil {
+		t.Fatalf("Expected %v but got %v", nil, err)
+	}
+
+	// no more connections are served
+	_, served := serveConn(rpcService)
+
+	if err := <-served; err != rpcv2.ErrServiceClosed {
+		t.Fatalf("Expected %v but got %v", rpcv2.ErrServiceClosed, err)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	rpcService, started, _ := newShutdownService()
+
+	conn, served := serveConn(rpcService)
+	defer conn.Close()
+
+	writeCall(t, conn, nullCall(1, testProcedure), nil)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := rpcService.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected %v but got %v", context.DeadlineExceeded, err)
+	}
+
+	// the connection is closed and the context of the call cancelled
+	if err := <-served; err != nil {
+		t.Fatalf("Expected %v but got %v", nil, err)
+	}
+}
+
+// newContextService returns a service whose test procedure reports each call on
+// calls, and blocks until its context is done if the argument is not empty
+func newContextService() (*rpcv2.RPCService, chan *rpcv2.CallContext) {
+	calls := make(chan *rpcv2.CallContext, 1)
+
+	rpcService := rpcv2.NewRPCService("test", testProgram, testVersion)
+	rpcService.RegisterProcedure(testProcedure, func(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+		calls <- call
+
+		if len(arg) > 0 {
+			<-call.Context().Done()
+		}
+
+		return rpcv2.Void{}, nil
+	})
+
+	return rpcService, calls
+}
+
+func TestCallContextTCP(t *testing.T) {
+	rpcService, calls := newContextService()
+	rpcService.SetCallTimeout(time.Minute)
+
+	conn, _ := serveConn(rpcService)
+	defer conn.Close()
+
+	writeCall(t, conn, nullCall(7, testProcedure), nil)
+	readXID(t, conn)
+
+	call := <-calls
+	if call.XID != 7 || call.Program != testProgram || call.Procedure != testProcedure || call.Transport != rpcv2.TransportTCP || call.RemoteAddr == nil {
+		t.Fatalf("Unexpected call %+v", call)
+	}
+
+	// the deadline follows the call timeout
+	deadline, ok := call.Context().Deadline()
+	if !ok || time.Until(deadline) > time.Minute || time.Until(deadline) < 50*time.Second {
+		t.Fatalf("Expected deadline in %v but got %v (%v)", time.Minute, time.Until(deadline), ok)
+	}
+}
+
+func TestCallContextUDP(t *testing.T) {
+	rpcService, calls := newContextService()
+
+	// find a free port for the listener
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	address := probe.LocalAddr().String()
+	probe.Close()
+
+	err = rpcService.AddListener("udp", address)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	go rpcService.Serve(context.Background())
+	defer rpcService.Shutdown(context.Background())
+
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer conn.Close()
+
+	callBytes, err := xdr.Marshal(nullCall(8, testProcedure))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = conn.Write(callBytes)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	call := <-calls
+	if call.XID != 8 || call.Transport != rpcv2.TransportUDP || call.RemoteAddr.String() != conn.LocalAddr().String() {
+		t.Fatalf("Unexpected call %+v", call)
+	}
+
+	// calls have no deadline unless a call timeout is set
+	if _, ok := call.Context().Deadline(); ok {
+		t.Fatalf("Expected no deadline but got one")
+	}
+}
+
+func TestCallContextCancelled(t *testing.T) {
+	rpcService, calls := newContextService()
+
+	conn, served := serveConn(rpcService)
+
+	writeCall(t, conn, nullCall(9, testProcedure), []byte{0, 0, 0, 1})
+	call := <-calls
+
+	// closing the connection cancels the calls in progress
+	conn.Close()
+
+	select {
+	case <-call.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Expected %v but the call wasn't cancelled", context.Canceled)
+	}
+
+	if err := <-served; err != nil {
+		t.Fatalf("Expected %v but got %v", nil, err)
+	}
+}
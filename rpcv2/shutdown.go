@@ -0,0 +1,113 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpcv2
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrServiceClosed is returned by Serve and ServeConn once Shutdown is called
+var ErrServiceClosed = errors.New("rpcv2: service closed")
+
+// shutdownPollInterval is how often Shutdown looks for idle connections to close
+const shutdownPollInterval = 50 * time.Millisecond
+
+// Shutdown stops the service gracefully. It removes all listeners, so that no more
+// clients are accepted, and waits for the calls in progress to finish, closing every
+// TCP connection as soon as it is idle. Once all connections are closed, Shutdown
+// returns nil. If ctx is done before, the remaining connections are closed, the
+// contexts of their calls cancelled, and the error of ctx is returned.
+func (rpcService *RPCService) Shutdown(ctx context.Context) error {
+	rpcService.mutex.Lock()
+	if !rpcService.shuttingDown {
+		rpcService.shuttingDown = true
+		close(rpcService.closing)
+	}
+	rpcService.mutex.Unlock()
+
+	rpcService.RemoveAllListeners()
+
+	done := make(chan struct{})
+
+	go func() {
+		rpcService.handlers.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		rpcService.closeConnections(false)
+
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			rpcService.closeConnections(true)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// startHandler counts a UDP call as being served. It returns false if the service is
+// shutting down, in which case the call must be dropped.
+func (rpcService *RPCService) startHandler() bool {
+	rpcService.mutex.Lock()
+	defer rpcService.mutex.Unlock()
+
+	if rpcService.shuttingDown {
+		return false
+	}
+
+	rpcService.handlers.Add(1)
+
+	return true
+}
+
+// addConnection counts a TCP connection as being served, so that Shutdown can wait
+// for it and close it. It returns false if the service is shutting down, in which
+// case the connection must be closed.
+func (rpcService *RPCService) addConnection(connection *tcpConnection) bool {
+	rpcService.mutex.Lock()
+	defer rpcService.mutex.Unlock()
+
+	if rpcService.shuttingDown {
+		return false
+	}
+
+	rpcService.connections[connection] = true
+	rpcService.handlers.Add(1)
+
+	return true
+}
+
+// removeConnection forgets a TCP connection added with addConnection once it is closed
+func (rpcService *RPCService) removeConnection(connection *tcpConnection) {
+	rpcService.mutex.Lock()
+	delete(rpcService.connections, connection)
+	rpcService.mutex.Unlock()
+
+	rpcService.handlers.Done()
+}
+
+// closeConnections closes the TCP connections without calls in progress, or all TCP
+// connections if force is set
+func (rpcService *RPCService) closeConnections(force bool) {
+	rpcService.mutex.Lock()
+	defer rpcService.mutex.Unlock()
+
+	for connection := range rpcService.connections {
+		if force {
+			connection.cancel()
+			connection.close(ErrServiceClosed)
+		} else {
+			connection.closeIdle()
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpcv2
+
+import (
+	"crypto/tls"
+)
+
+// startTLSVerifier is the body of the verifier by which servers accept an AUTH_TLS probe
+const startTLSVerifier = "STARTTLS"
+
+// SetTLSConfig offers RPC-over-TLS (RFC9289) on TCP connections. Clients probe for
+// it by calling the NULL procedure with AUTH_TLS credentials, and start the TLS
+// handshake on the same connection after the reply. Client certificates are requested
+// and verified according to config.ClientAuth and config.ClientCAs, and are available
+// to procedures in CallContext.TLS. If required is set, all calls except to the NULL
+// procedure are rejected with AUTH_TOOWEAK on connections without TLS.
+func (rpcService *RPCService) SetTLSConfig(config *tls.Config, required bool) {
+	rpcService.tlsConfig = config
+	rpcService.tlsRequired = required
+}
+
+// probeTLS answers an AUTH_TLS probe, and has the connection of the call upgraded to
+// TLS once the reply is sent
+func (rpcService *RPCService) probeTLS(call *CallContext) ([]byte, error) {
+	if call.Procedure != 0 {
+		return authenticationError(call.XID, AuthenticationBadCred)
+	}
+
+	if call.Verifier.Flavor != AuthenticationNull {
+		return authenticationError(call.XID, AuthenticationBadVerf)
+	}
+
+	if call.connection == nil || call.TLS != nil { // not available over UDP, and TLS can't be nested
+		return authenticationError(call.XID, AuthenticationTooWeak)
+	}
+
+	call.connection.startTLS = true
+
+	return success(call.XID, OpaqueAuth{Flavor: AuthenticationNull, Body: []byte(startTLSVerifier)}, Void{})
+}
@@ -0,0 +1,147 @@
+// Copyright 2019 Daniel Lorch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpcv2_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dlorch/base-nfs/rpcv2"
+)
+
+// newCertificate returns a certificate for name signed by parent, or a self-signed
+// certificate authority if parent is nil
+func newCertificate(t *testing.T, name string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := template, interface{}(key)
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// newTLSService returns a connection to a service requiring TLS with client
+// certificates, whose test procedure returns 1 to callers with a client certificate
+func newTLSService(t *testing.T) (net.Conn, *x509.CertPool, tls.Certificate) {
+	authority := newCertificate(t, "authority", nil)
+	certificates := x509.NewCertPool()
+	certificates.AddCert(authority.Leaf)
+
+	rpcService := rpcv2.NewRPCService("test", testProgram, testVersion)
+	rpcService.SetTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{newCertificate(t, "server", &authority)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    certificates,
+	}, true)
+	rpcService.RegisterProcedure(testProcedure, func(call *rpcv2.CallContext, arg []byte) (interface{}, error) {
+		if call.TLS == nil || len(call.TLS.PeerCertificates) == 0 || call.TLS.PeerCertificates[0].Subject.CommonName != "client" {
+			return nil, errors.New("expected client certificate")
+		}
+
+		return uint32(1), nil
+	})
+
+	serverConn, clientConn := net.Pipe()
+
+	go rpcService.ServeConn(serverConn)
+
+	return clientConn, certificates, newCertificate(t, "client", &authority)
+}
+
+// nullCall returns a call without credentials
+func nullCall(xid uint32, procedure uint32) *rpcv2.RPCMessage {
+	return &rpcv2.RPCMessage{
+		XID:         xid,
+		MessageType: rpcv2.Call,
+		CBody: rpcv2.CallBody{
+			RPCVersion:     rpcv2.RPCVersion,
+			Program:        testProgram,
+			ProgramVersion: testVersion,
+			Procedure:      procedure,
+			Credentials:    rpcv2.OpaqueAuth{Flavor: rpcv2.AuthenticationNull, Body: []byte{}},
+			Verifier:       rpcv2.OpaqueAuth{Flavor: rpcv2.AuthenticationNull, Body: []byte{}},
+		},
+	}
+}
+
+func TestStartTLS(t *testing.T) {
+	conn, certificates, clientCertificate := newTLSService(t)
+	defer conn.Close()
+
+	// calls are rejected until the connection is secured
+	writeCall(t, conn, nullCall(1, testProcedure), []byte{})
+
+	reply, _ := readReply(t, conn, 1)
+	if reply.ReplyStatus != rpcv2.MessageDenied || reply.Rejected.Stat != rpcv2.AuthenticationTooWeak {
+		t.Fatalf("Expected %v but got %+v", rpcv2.AuthenticationTooWeak, reply)
+	}
+
+	probe := nullCall(2, 0)
+	probe.CBody.Credentials.Flavor = rpcv2.AuthenticationTLS
+
+	writeCall(t, conn, probe, []byte{})
+
+	reply, _ = readReply(t, conn, 2)
+	if reply.ReplyStatus != rpcv2.MessageAccepted || string(reply.Accepted.Verf.Body) != "STARTTLS" {
+		t.Fatalf("Expected STARTTLS but got %+v", reply)
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName:   "server",
+		RootCAs:      certificates,
+		Certificates: []tls.Certificate{clientCertificate},
+	})
+
+	err := tlsConn.Handshake()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	writeCall(t, tlsConn, nullCall(3, testProcedure), []byte{})
+
+	reply, results := readReply(t, tlsConn, 3)
+	if reply.ReplyStatus != rpcv2.MessageAccepted || reply.Accepted.AcceptState != rpcv2.Success || binary.BigEndian.Uint32(results) != 1 {
+		t.Fatalf("Expected %v but got %+v", rpcv2.Success, reply)
+	}
+}
@@ -25,7 +25,7 @@ func (e *UnmarshalError) Error() string {
 
 type decodeState struct {
 	data *bytes.Buffer
-	off  int // next read offset in data
+	size int // total length of data
 }
 
 // Unmarshal deserializes a byte array to an XDR format
@@ -39,7 +39,7 @@ func Unmarshal(data []byte, v interface{}) (bytesRead int, err error) {
 func (d *decodeState) unmarshal(v interface{}, sts *structTagState) (bytesRead int, err error) {
 	rv := reflect.ValueOf(v)
 	if rv.Kind() != reflect.Ptr || rv.IsNil() {
-		return d.off, &UnmarshalError{s: "invalid value for unmarshalling: must be pointer and not nil"}
+		return d.offset(), &UnmarshalError{s: "invalid value for unmarshalling: must be pointer and not nil"}
 	}
 
 	val := rv.Elem()
@@ -54,24 +54,24 @@ func (d *decodeState) unmarshal(v interface{}, sts *structTagState) (bytesRead i
 				switch s[0] {
 				case "switch":
 					if val.Field(i).Kind() != reflect.Uint32 {
-						return d.off, &MarshalError{s: fmt.Sprintf("invalid type for struct field '%s': require uint32 for `xdr:\"switch\"`", f.Name)}
+						return d.offset(), &MarshalError{s: fmt.Sprintf("invalid type for struct field '%s': require uint32 for `xdr:\"switch\"`", f.Name)}
 					}
-					_, err := d.unmarshal(val.Field(i).Addr().Interface(), sts)
+					_, err := d.unmarshal(val.Field(i).Addr().Interface(), newStructTagState())
 					if err != nil {
-						return d.off, err
+						return d.offset(), err
 					}
 					u, _ := val.Field(i).Interface().(uint32)
 					sts.switchStatement(u)
 					continue
 				case "case":
 					if !sts.isSwitch {
-						return d.off, &UnmarshalError{s: fmt.Sprintf("invalid `xdr:\"case=%s\" for struct field '%s': no corresponding `xdr:\"switch\"` statement found", s[1], f.Name)}
+						return d.offset(), &UnmarshalError{s: fmt.Sprintf("invalid `xdr:\"case=%s\" for struct field '%s': no corresponding `xdr:\"switch\"` statement found", s[1], f.Name)}
 					}
 					cs := strings.Split(s[1], ",")
 					for _, c := range cs {
 						u, err := strconv.ParseUint(c, 10, 32)
 						if err != nil {
-							return d.off, &UnmarshalError{s: fmt.Sprintf("invalid value '%s' in `xdr:\"case=%s\"` for struct field '%s': require uint32 value", c, c, f.Name)}
+							return d.offset(), &UnmarshalError{s: fmt.Sprintf("invalid value '%s' in `xdr:\"case=%s\"` for struct field '%s': require uint32 value", c, c, f.Name)}
 						}
 						sts.caseStatement(uint32(u))
 						if sts.matched {
@@ -80,15 +80,15 @@ func (d *decodeState) unmarshal(v interface{}, sts *structTagState) (bytesRead i
 					}
 				case "default":
 					if !sts.isSwitch {
-						return d.off, &UnmarshalError{s: fmt.Sprintf("invalid `xdr:\"default\"` for struct field '%s': no corresponding `xdr:\"switch\"` statement found", f.Name)}
+						return d.offset(), &UnmarshalError{s: fmt.Sprintf("invalid `xdr:\"default\"` for struct field '%s': no corresponding `xdr:\"switch\"` statement found", f.Name)}
 					}
 					sts.defaultStatement()
 				}
 
 				if sts.caseMatch() {
-					_, err := d.unmarshal(val.Field(i).Addr().Interface(), sts)
+					_, err := d.unmarshal(val.Field(i).Addr().Interface(), newStructTagState())
 					if err != nil {
-						return d.off, err
+						return d.offset(), err
 					}
 				}
 			}
@@ -98,7 +98,7 @@ func (d *decodeState) unmarshal(v interface{}, sts *structTagState) (bytesRead i
 		for i := 0; i < val.Len(); i++ {
 			err := binary.Read(d.data, binary.BigEndian, &b)
 			if err != nil {
-				return d.off, err
+				return d.offset(), err
 			}
 			val.Index(i).SetUint(uint64(b))
 		}
@@ -106,62 +106,71 @@ func (d *decodeState) unmarshal(v interface{}, sts *structTagState) (bytesRead i
 		var l uint32
 		err := binary.Read(d.data, binary.BigEndian, &l)
 		if err != nil {
-			return d.off, err
+			return d.offset(), err
 		}
 
 		_, ok := v.(*[]byte)
 		if ok {
+			if int(l) > d.data.Len() {
+				return d.offset(), &UnmarshalError{s: fmt.Sprintf("slice variable supposed to be length %d, but only %d bytes remaining", l, d.data.Len())}
+			}
 			b := make([]byte, l)
 			n, err := d.data.Read(b)
 			if err != nil {
-				return d.off, err
+				return d.offset(), err
 			}
 			if n != int(l) {
-				return d.off, &UnmarshalError{s: fmt.Sprintf("slice variable supposed to be length %d, but could only ready %d bytes", l, n)}
+				return d.offset(), &UnmarshalError{s: fmt.Sprintf("slice variable supposed to be length %d, but could only ready %d bytes", l, n)}
 			}
 			if l%4 > 0 {
 				pad := int(4 - (l % 4))
 				for i := 0; i < pad; i++ {
 					_, err := d.data.ReadByte()
 					if err != nil {
-						return d.off, err
+						return d.offset(), err
 					}
 				}
 			}
 			val.SetBytes(b)
-			return d.off, nil
+			return d.offset(), nil
 		}
 		_, ok = v.(*[]uint32)
 		if ok {
+			if int(l) > d.data.Len()/4 {
+				return d.offset(), &UnmarshalError{s: fmt.Sprintf("slice variable supposed to be length %d, but only %d bytes remaining", l, d.data.Len())}
+			}
 			u := make([]uint32, l)
 			err := binary.Read(d.data, binary.BigEndian, &u)
 			if err != nil {
-				return d.off, err
+				return d.offset(), err
 			}
 			val.Set(reflect.ValueOf(u))
-			return d.off, nil
+			return d.offset(), nil
 		}
-		return d.off, &UnmarshalError{s: "error for type " + val.Type().String() + ": type assertion to []byte / []uint32 failed"}
+		return d.offset(), &UnmarshalError{s: "error for type " + val.Type().String() + ": type assertion to []byte / []uint32 failed"}
 	case reflect.String:
 		var len uint32
 		err := binary.Read(d.data, binary.BigEndian, &len)
 		if err != nil {
-			return d.off, err
+			return d.offset(), err
+		}
+		if int(len) > d.data.Len() {
+			return d.offset(), &UnmarshalError{s: fmt.Sprintf("string variable supposed to be length %d, but only %d bytes remaining", len, d.data.Len())}
 		}
 		b := make([]byte, len)
 		n, err := d.data.Read(b)
 		if err != nil {
-			return d.off, err
+			return d.offset(), err
 		}
 		if n != int(len) {
-			return d.off, &UnmarshalError{s: fmt.Sprintf("string variable supposed to be length %d, but could only ready %d bytes", len, n)}
+			return d.offset(), &UnmarshalError{s: fmt.Sprintf("string variable supposed to be length %d, but could only ready %d bytes", len, n)}
 		}
 		if len%4 > 0 {
 			pad := int(4 - (len % 4))
 			for i := 0; i < pad; i++ {
 				_, err := d.data.ReadByte()
 				if err != nil {
-					return d.off, err
+					return d.offset(), err
 				}
 			}
 		}
@@ -170,31 +179,36 @@ func (d *decodeState) unmarshal(v interface{}, sts *structTagState) (bytesRead i
 		var v uint32
 		err := binary.Read(d.data, binary.BigEndian, &v)
 		if err != nil {
-			return d.off, err
+			return d.offset(), err
 		}
 		val.SetUint(uint64(v))
 	case reflect.Uint64:
 		var v uint64
 		err := binary.Read(d.data, binary.BigEndian, &v)
 		if err != nil {
-			return d.off, err
+			return d.offset(), err
 		}
 		val.SetUint(v)
 	case reflect.Ptr:
 		val.Set(reflect.New(val.Type().Elem()))
 		_, err := d.unmarshal(val.Elem().Addr().Interface(), newStructTagState())
 		if err != nil {
-			return d.off, err
+			return d.offset(), err
 		}
 	default:
-		return d.off, &UnmarshalError{s: "unsupported type: " + val.Type().String() + " of kind " + val.Kind().String()}
+		return d.offset(), &UnmarshalError{s: "unsupported type: " + val.Type().String() + " of kind " + val.Kind().String()}
 	}
-	return d.off, nil
+	return d.offset(), nil
 }
 
 func (d *decodeState) init(data []byte) {
 	d.data = bytes.NewBuffer(data)
-	d.off = 0
+	d.size = len(data)
+}
+
+// offset returns the number of bytes consumed from data so far
+func (d *decodeState) offset() int {
+	return d.size - d.data.Len()
 }
 
 func newDecodeState() *decodeState {
@@ -615,3 +615,57 @@ func TestDecodeUserLinkedList(t *testing.T) {
 		t.Fatalf("Expected %v but got %v", userLinkedList, got)
 	}
 }
+
+type NestedUnion struct {
+	Attribute OptionalAttribute
+	Trailer   uint32
+	Data      []byte
+}
+
+var nestedUnion = &NestedUnion{
+	Attribute: OptionalAttribute{
+		AttributeFollows: 0,
+	},
+	Trailer: 7,
+	Data:    []byte{9},
+}
+
+var nestedUnionBytes = []byte{0, 0, 0, 0, 0, 0, 0, 7, 0, 0, 0, 1, 9, 0, 0, 0}
+
+// TestDecodeNestedUnion verifies that a switch statement inside a nested struct does not
+// affect the decoding of the remaining fields of the enclosing struct
+func TestDecodeNestedUnion(t *testing.T) {
+	got := &NestedUnion{}
+	bytesRead, err := xdr.Unmarshal(nestedUnionBytes, got)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !reflect.DeepEqual(got, nestedUnion) {
+		t.Fatalf("Expected %v but got %v", nestedUnion, got)
+	}
+	if bytesRead != len(nestedUnionBytes) {
+		t.Fatalf("Expected %d bytes read but got %d", len(nestedUnionBytes), bytesRead)
+	}
+}
+
+func TestDecodeBytesRead(t *testing.T) {
+	got := &Simple{}
+	data := append(append([]byte{}, simpleBytes...), 1, 2, 3, 4)
+	bytesRead, err := xdr.Unmarshal(data, got)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if bytesRead != len(simpleBytes) {
+		t.Fatalf("Expected %d bytes read but got %d", len(simpleBytes), bytesRead)
+	}
+}
+
+var oversizedValuesBytes = []byte{0xff, 0xff, 0xff, 0xff, 1, 2, 3, 4}
+
+func TestDecodeOversizedValues(t *testing.T) {
+	got := &DynamicallySizedValues{}
+	_, err := xdr.Unmarshal(oversizedValuesBytes, got)
+	if err == nil {
+		t.Fatalf("Expected error, but got %v", got)
+	}
+}